@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// backpressure measures, for -backpressure-warn, how long after the read
+// loop finished reading a message the printer was done with it: the wait
+// in the hand-off to the printer plus the time spent writing to stdout,
+// -output, -pipe or -demux-dir. A slow consumer shows up as a growing gap.
+// Crossing the threshold is logged once per episode, as is catching up.
+type backpressure struct {
+	warn time.Duration
+	errw io.Writer
+
+	messages int
+	over     int // messages handled more than warn after receipt
+	episodes int
+	lagging  bool
+	total    time.Duration
+	max      time.Duration
+}
+
+// newBackpressure returns nil, disabling the measurement, when warn is 0.
+func newBackpressure(warn time.Duration, errw io.Writer) *backpressure {
+	if warn <= 0 {
+		return nil
+	}
+	return &backpressure{warn: warn, errw: errw}
+}
+
+// observe records one message once handle is done with it. It is a no-op
+// on a nil *backpressure.
+func (b *backpressure) observe(msg client.Message, index int) {
+	if b == nil || msg.Time.IsZero() {
+		return
+	}
+	lag := time.Since(msg.Time)
+	b.messages++
+	b.total += lag
+	b.max = max(b.max, lag)
+	switch {
+	case lag > b.warn:
+		b.over++
+		if !b.lagging {
+			b.lagging = true
+			b.episodes++
+			fmt.Fprintf(b.errw, "backpressure: output is %s behind receipt at message %d (over -backpressure-warn %s)\n",
+				lag.Round(time.Millisecond), index, b.warn)
+		}
+	case b.lagging:
+		b.lagging = false
+		fmt.Fprintf(b.errw, "backpressure: output caught up at message %d (%s behind)\n", index, lag.Round(time.Millisecond))
+	}
+}
+
+// report prints the lag summary. It is a no-op on a nil *backpressure.
+func (b *backpressure) report(w io.Writer) {
+	if b == nil || b.messages == 0 {
+		return
+	}
+	fmt.Fprintf(w, "backpressure: %d of %d message(s) handled more than %s after receipt in %d episode(s); lag mean %s, max %s\n",
+		b.over, b.messages, b.warn, b.episodes,
+		(b.total / time.Duration(b.messages)).Round(time.Microsecond), b.max.Round(time.Microsecond))
+}
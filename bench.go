@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// benchResult accumulates what the bench workers observed.
+type benchResult struct {
+	mu        sync.Mutex
+	connected int
+	sent      int
+	received  int
+	timeouts  int
+	unmatched int // frames that answered no send, e.g. a greeting
+	errors    []error
+	latencies []time.Duration // response latency of every answered message
+	behind    int             // sends that left more than one interval late (-rate)
+	end       time.Time       // when the last worker stopped sending
+
+	established []time.Duration // when each connection came up, since the start
+	handshakes  time.Duration   // sum of the handshake durations
+}
+
+func (r *benchResult) fail(err error) {
+	r.mu.Lock()
+	r.errors = append(r.errors, err)
+	r.mu.Unlock()
+}
+
+// bench opens -connections connections that each send the payload, wait for
+// its response and repeat until -duration has passed. With -ramp the
+// connections are opened evenly spread over the ramp period instead of all
+// at once. With -rate the connections instead send on a fixed schedule that
+// adds up to the target rate, without waiting for responses. A response is
+// the message carrying the send's -correlation-field id, or without it the
+// one echoing the payload; anything else is skipped as unmatched.
+func (a *app) bench(ctx context.Context, opts options) error {
+	payload, err := buildPayload(opts, "")
+	if err != nil {
+		return err
+	}
+	if opts.correlationField != "" {
+		if _, err := withCorrelationID(payload, opts.correlationField, ""); err != nil {
+			return fmt.Errorf("-correlation-field: %w", err)
+		}
+	}
+	if opts.dryRun {
+		return a.dryRun(opts, payload, [][]byte{payload})
+	}
+
+	stopMetrics, err := a.startMetrics(opts)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.benchDuration)
+	defer cancel()
+
+	var res benchResult
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if delay := rampDelay(opts.ramp, id, opts.connections); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+			a.benchWorker(ctx, opts, id, start, payload, &res)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := res.end.Sub(start)
+	if res.end.IsZero() {
+		elapsed = time.Since(start)
+	}
+
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	if opts.benchJSON {
+		if err := printBenchJSON(a.stdout, &res, opts, elapsed); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(a.stdout, "connections: %d/%d established\n", res.connected, opts.connections)
+		printTimeline(a.stdout, &res, opts.ramp)
+		fmt.Fprintf(a.stdout, "messages:    %d sent, %d received, %d timed out, %d errors\n", res.sent, res.received, res.timeouts, len(res.errors))
+		if res.unmatched > 0 {
+			fmt.Fprintf(a.stdout, "unmatched:   %d message(s) answered no send\n", res.unmatched)
+		}
+		fmt.Fprintf(a.stdout, "throughput:  %.1f msg/s over %s\n", float64(res.received)/elapsed.Seconds(), elapsed.Round(time.Millisecond))
+		if opts.rate > 0 {
+			fmt.Fprintf(a.stdout, "send rate:   %.1f msg/s (target %g)\n", float64(res.sent)/elapsed.Seconds(), opts.rate)
+		}
+		if n := len(res.latencies); n > 0 {
+			fmt.Fprintf(a.stdout, "latency:     %s average, p50 %s, p90 %s, p99 %s, max %s\n",
+				(sum(res.latencies) / time.Duration(n)).Round(time.Microsecond),
+				percentile(res.latencies, 50).Round(time.Microsecond),
+				percentile(res.latencies, 90).Round(time.Microsecond),
+				percentile(res.latencies, 99).Round(time.Microsecond),
+				res.latencies[n-1].Round(time.Microsecond))
+		}
+	}
+	if opts.rate > 0 && !rateSustained(&res, opts.rate, elapsed) {
+		fmt.Fprintf(a.stderr, "warning: could not sustain -rate %g: sent %.1f msg/s, %d sends late\n", opts.rate, float64(res.sent)/elapsed.Seconds(), res.behind)
+	}
+	for _, err := range res.errors {
+		fmt.Fprintf(a.stderr, "error: %v\n", err)
+	}
+	if len(res.errors) > 0 {
+		return fmt.Errorf("%d of %d connections failed", len(res.errors), opts.connections)
+	}
+	return nil
+}
+
+func (a *app) benchWorker(ctx context.Context, opts options, id int, start time.Time, payload []byte, res *benchResult) {
+	c, err := a.connect(ctx, opts, payload)
+	if err != nil {
+		a.metrics.fail("dial")
+		res.fail(fmt.Errorf("conn %d: %w", id, err))
+		return
+	}
+	defer func() {
+		_ = c.Close(websocket.CloseNormalClosure, "")
+		for range c.Receive() {
+		}
+	}()
+	res.mu.Lock()
+	res.connected++
+	res.established = append(res.established, time.Since(start))
+	res.handshakes += c.HandshakeDuration()
+	res.mu.Unlock()
+	defer func() {
+		res.mu.Lock()
+		if now := time.Now(); now.After(res.end) {
+			res.end = now
+		}
+		res.mu.Unlock()
+	}()
+
+	// The bench deadline ends the loop, not an in-flight write.
+	sendCtx := context.WithoutCancel(ctx)
+	m := benchMatcher{field: opts.correlationField, payload: payload}
+	if opts.rate > 0 {
+		a.benchPaced(ctx, sendCtx, c, opts, id, m, res)
+		return
+	}
+	for ctx.Err() == nil {
+		msg, key, err := m.next()
+		if err != nil {
+			res.fail(fmt.Errorf("conn %d: %w", id, err))
+			return
+		}
+		sentAt := time.Now()
+		if err := a.send(sendCtx, c, msg); err != nil {
+			if errors.Is(err, errMaxSends) {
+				return
+			}
+			if ctx.Err() == nil {
+				res.fail(fmt.Errorf("conn %d: send: %w", id, err))
+			}
+			return
+		}
+		res.mu.Lock()
+		res.sent++
+		res.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if opts.readTimeout > 0 {
+			timeout = time.After(opts.readTimeout)
+		}
+	wait:
+		for {
+			select {
+			case msg, ok := <-c.Receive():
+				if !ok {
+					a.metrics.fail("closed")
+					res.fail(fmt.Errorf("conn %d: connection closed: %v", id, c.Err()))
+					return
+				}
+				a.metrics.receivedBytes(len(msg.Data))
+				if k, ok := m.key(msg); !ok || k != key {
+					res.mu.Lock()
+					res.unmatched++
+					res.mu.Unlock()
+					continue
+				}
+				a.metrics.response(time.Since(sentAt))
+				res.mu.Lock()
+				res.received++
+				res.latencies = append(res.latencies, time.Since(sentAt))
+				res.mu.Unlock()
+				break wait
+			case <-timeout:
+				a.metrics.fail("timeout")
+				res.mu.Lock()
+				res.timeouts++
+				res.mu.Unlock()
+				break wait
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// benchMatcher pairs responses with bench sends by a key: the fresh
+// -correlation-field id of every send, or without it the payload itself,
+// which an echo server returns unchanged.
+type benchMatcher struct {
+	field   string
+	payload []byte
+}
+
+// next returns the message to send and the key its response carries.
+func (m benchMatcher) next() ([]byte, string, error) {
+	if m.field == "" {
+		return m.payload, string(m.payload), nil
+	}
+	id := newCorrelationID()
+	msg, err := withCorrelationID(m.payload, m.field, id)
+	return msg, id, err
+}
+
+// key returns the key msg carries, if it can answer a send at all.
+func (m benchMatcher) key(msg client.Message) (string, bool) {
+	if m.field == "" {
+		return string(msg.Data), true
+	}
+	var doc any
+	if err := json.Unmarshal(msg.Data, &doc); err != nil {
+		return "", false
+	}
+	v, ok := lookupPath(doc, m.field)
+	if !ok {
+		return "", false
+	}
+	return valueString(v), true
+}
+
+// benchSend is a paced send waiting for its response.
+type benchSend struct {
+	key string
+	at  time.Time
+}
+
+// benchPaced sends a message every -connections/-rate seconds on c, so the
+// connections together reach the target rate, while a reader matches each
+// response to the oldest unanswered send with its key. Sends still
+// unanswered after -read-timeout, or when the bench ends, count as timed
+// out.
+func (a *app) benchPaced(ctx, sendCtx context.Context, c *client.Client, opts options, id int, m benchMatcher, res *benchResult) {
+	interval := time.Duration(float64(time.Second) * float64(opts.connections) / opts.rate)
+	var mu sync.Mutex
+	var pending []benchSend // waiting for their response, oldest first
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for msg := range c.Receive() {
+			a.metrics.receivedBytes(len(msg.Data))
+			k, ok := m.key(msg)
+			mu.Lock()
+			i := slices.IndexFunc(pending, func(s benchSend) bool { return ok && s.key == k })
+			if i < 0 {
+				mu.Unlock()
+				res.mu.Lock()
+				res.unmatched++
+				res.mu.Unlock()
+				continue
+			}
+			sentAt := pending[i].at
+			pending = slices.Delete(pending, i, i+1)
+			mu.Unlock()
+			latency := msg.Time.Sub(sentAt)
+			a.metrics.response(latency)
+			res.mu.Lock()
+			res.received++
+			res.latencies = append(res.latencies, latency)
+			res.mu.Unlock()
+		}
+	}()
+
+	defer func() {
+		_ = c.Close(websocket.CloseNormalClosure, "")
+		<-readDone
+	}()
+
+	expire := func(now time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for n < len(pending) && opts.readTimeout > 0 && now.Sub(pending[n].at) > opts.readTimeout {
+			n++
+		}
+		if n > 0 {
+			pending = pending[n:]
+			res.mu.Lock()
+			res.timeouts += n
+			res.mu.Unlock()
+			for range n {
+				a.metrics.fail("timeout")
+			}
+		}
+	}
+
+	next := time.Now()
+loop:
+	for {
+		select {
+		case <-time.After(time.Until(next)):
+		case <-readDone:
+			if ctx.Err() == nil {
+				a.metrics.fail("closed")
+				res.fail(fmt.Errorf("conn %d: connection closed: %v", id, c.Err()))
+			}
+			return
+		case <-ctx.Done():
+			break loop
+		}
+		now := time.Now()
+		if now.Sub(next) > interval {
+			res.mu.Lock()
+			res.behind++
+			res.mu.Unlock()
+		}
+		expire(now)
+		msg, key, err := m.next()
+		if err != nil {
+			res.fail(fmt.Errorf("conn %d: %w", id, err))
+			return
+		}
+		mu.Lock()
+		pending = append(pending, benchSend{key: key, at: now})
+		mu.Unlock()
+		if err := a.send(sendCtx, c, msg); err != nil {
+			mu.Lock()
+			pending = pending[:len(pending)-1]
+			mu.Unlock()
+			if errors.Is(err, errMaxSends) {
+				break loop
+			}
+			res.fail(fmt.Errorf("conn %d: send: %w", id, err))
+			return
+		}
+		res.mu.Lock()
+		res.sent++
+		res.mu.Unlock()
+		next = next.Add(interval)
+	}
+
+	// Give the responses to the last sends a moment to arrive.
+	wait := time.Second
+	if opts.readTimeout > 0 {
+		wait = min(opts.readTimeout, wait)
+	}
+	grace := time.After(wait)
+	for {
+		mu.Lock()
+		left := len(pending)
+		mu.Unlock()
+		if left == 0 {
+			return
+		}
+		select {
+		case <-readDone:
+			return
+		case <-grace:
+			mu.Lock()
+			n := len(pending)
+			pending = nil
+			mu.Unlock()
+			res.mu.Lock()
+			res.timeouts += n
+			res.mu.Unlock()
+			for range n {
+				a.metrics.fail("timeout")
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// rateSustained reports whether the bench got within 5% of the -rate target.
+func rateSustained(res *benchResult, rate float64, elapsed time.Duration) bool {
+	return float64(res.sent)/elapsed.Seconds() >= 0.95*rate
+}
+
+// benchSummary is the -json form of the bench report.
+type benchSummary struct {
+	Connections int     `json:"connections"`
+	Established int     `json:"established"`
+	Sent        int     `json:"sent"`
+	Received    int     `json:"received"`
+	TimedOut    int     `json:"timed_out"`
+	Unmatched   int     `json:"unmatched"`
+	Errors      int     `json:"errors"`
+	DurationSec float64 `json:"duration_sec"`
+	Throughput  float64 `json:"throughput_per_sec"`
+	TargetRate  float64 `json:"target_rate,omitempty"`
+	SendRate    float64 `json:"send_rate_per_sec,omitempty"`
+	Sustained   *bool   `json:"rate_sustained,omitempty"`
+	LatencyMs   *struct {
+		Avg float64 `json:"avg"`
+		P50 float64 `json:"p50"`
+		P90 float64 `json:"p90"`
+		P99 float64 `json:"p99"`
+		Max float64 `json:"max"`
+	} `json:"latency_ms,omitempty"`
+}
+
+func printBenchJSON(w io.Writer, res *benchResult, opts options, elapsed time.Duration) error {
+	s := benchSummary{
+		Connections: opts.connections,
+		Established: res.connected,
+		Sent:        res.sent,
+		Received:    res.received,
+		TimedOut:    res.timeouts,
+		Unmatched:   res.unmatched,
+		Errors:      len(res.errors),
+		DurationSec: elapsed.Seconds(),
+		Throughput:  float64(res.received) / elapsed.Seconds(),
+	}
+	if opts.rate > 0 {
+		sustained := rateSustained(res, opts.rate, elapsed)
+		s.TargetRate = opts.rate
+		s.SendRate = float64(res.sent) / elapsed.Seconds()
+		s.Sustained = &sustained
+	}
+	if n := len(res.latencies); n > 0 {
+		ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+		s.LatencyMs = &struct {
+			Avg float64 `json:"avg"`
+			P50 float64 `json:"p50"`
+			P90 float64 `json:"p90"`
+			P99 float64 `json:"p99"`
+			Max float64 `json:"max"`
+		}{
+			Avg: ms(sum(res.latencies) / time.Duration(n)),
+			P50: ms(percentile(res.latencies, 50)),
+			P90: ms(percentile(res.latencies, 90)),
+			P99: ms(percentile(res.latencies, 99)),
+			Max: ms(res.latencies[n-1]),
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// sum adds up ds.
+func sum(ds []time.Duration) time.Duration {
+	var total time.Duration
+	for _, d := range ds {
+		total += d
+	}
+	return total
+}
+
+// rampDelay is when connection id of n starts within the ramp period.
+func rampDelay(ramp time.Duration, id, n int) time.Duration {
+	if ramp <= 0 || n <= 1 {
+		return 0
+	}
+	return ramp * time.Duration(id) / time.Duration(n)
+}
+
+// printTimeline reports when the connections came up: the first and last
+// one, the average handshake and, with a ramp, how many connected in each
+// tenth of it.
+func printTimeline(w io.Writer, res *benchResult, ramp time.Duration) {
+	if len(res.established) == 0 {
+		return
+	}
+	sort.Slice(res.established, func(i, j int) bool { return res.established[i] < res.established[j] })
+	first, last := res.established[0], res.established[len(res.established)-1]
+	fmt.Fprintf(w, "established: first +%s, last +%s, handshake %s average\n",
+		first.Round(time.Millisecond), last.Round(time.Millisecond),
+		(res.handshakes / time.Duration(len(res.established))).Round(time.Microsecond))
+	if ramp <= 0 {
+		return
+	}
+	const buckets = 10
+	step := max(ramp/buckets, 1)
+	counts := make([]int, buckets)
+	for _, at := range res.established {
+		i := int(at / step)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		counts[i]++
+	}
+	for i, n := range counts {
+		fmt.Fprintf(w, "  +%-10s %d connected\n", (step * time.Duration(i)).Round(time.Millisecond), n)
+	}
+}
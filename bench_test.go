@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// greetThenEcho greets every connection, then echoes.
+func greetThenEcho(s *testServer, conn *websocket.Conn) {
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"welcome"}`)); err != nil {
+		return
+	}
+	echo(s, conn)
+}
+
+// runBench runs bench with -json and returns its summary.
+func runBench(t *testing.T, s *testServer, args ...string) benchSummary {
+	t.Helper()
+	args = append([]string{"bench", "-url", s.url, "-path", "/ws", "-connections", "2", "-duration", "300ms", "-json"}, args...)
+	r := runCommand(t, args...)
+	if r.err != nil {
+		t.Fatalf("bench: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	var sum benchSummary
+	if err := json.Unmarshal([]byte(r.stdout), &sum); err != nil {
+		t.Fatalf("bench -json output: %v\n%s", err, r.stdout)
+	}
+	return sum
+}
+
+// checkMatched checks that every response was matched to its send and the
+// greetings were skipped.
+func checkMatched(t *testing.T, sum benchSummary) {
+	t.Helper()
+	if !answered(sum) {
+		t.Errorf("sent %d, received %d, timed out %d: want every send answered", sum.Sent, sum.Received, sum.TimedOut)
+	}
+	if sum.Unmatched != 2 {
+		t.Errorf("unmatched = %d, want the 2 greetings", sum.Unmatched)
+	}
+}
+
+// answered reports whether every send got its response, except the one per
+// connection that may be in flight when -duration ends the loop.
+func answered(sum benchSummary) bool {
+	open := sum.Sent - sum.TimedOut - sum.Received
+	return sum.Received > 0 && open >= 0 && open <= sum.Connections
+}
+
+func TestBenchSkipsGreeting(t *testing.T) {
+	s := newTestServer(t, greetThenEcho)
+	checkMatched(t, runBench(t, s, "msg=hi"))
+}
+
+func TestBenchPacedSkipsGreeting(t *testing.T) {
+	s := newTestServer(t, greetThenEcho)
+	sum := runBench(t, s, "-rate", "100", "msg=hi")
+	checkMatched(t, sum)
+	if sum.TimedOut != 0 {
+		t.Errorf("timed out = %d, want 0", sum.TimedOut)
+	}
+}
+
+// TestBenchCorrelationField answers every request with an unrelated frame
+// first and a response carrying the request's id second; only the latter
+// may count, whatever arrives in between.
+func TestBenchCorrelationField(t *testing.T) {
+	handle := func(s *testServer, conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"welcome"}`))
+		for {
+			_, data, err := s.read(conn)
+			if err != nil {
+				return
+			}
+			var req struct {
+				ID string `json:"id"`
+			}
+			_ = json.Unmarshal(data, &req)
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"progress"}`))
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"result","id":"`+req.ID+`"}`))
+		}
+	}
+	for _, rate := range []string{"0", "100"} {
+		t.Run("rate "+rate, func(t *testing.T) {
+			s := newTestServer(t, handle)
+			sum := runBench(t, s, "-rate", rate, "-correlation-field", "id", "msg=hi")
+			if !answered(sum) {
+				t.Errorf("sent %d, received %d, timed out %d: want every send answered", sum.Sent, sum.Received, sum.TimedOut)
+			}
+			// The greetings and a progress frame per answered send.
+			if want := 2 + sum.Received; sum.Unmatched < want {
+				t.Errorf("unmatched = %d, want at least %d", sum.Unmatched, want)
+			}
+			ids := make(map[string]bool)
+			for _, m := range s.messages() {
+				var req struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal(m, &req); err != nil || req.ID == "" || ids[req.ID] {
+					t.Fatalf("send %s does not carry a fresh id", m)
+				}
+				ids[req.ID] = true
+			}
+		})
+	}
+}
+
+// TestBenchTinyRamp ramps over fewer nanoseconds than the timeline has
+// buckets, which must not leave the timeline a zero step to divide by.
+func TestBenchTinyRamp(t *testing.T) {
+	s := newTestServer(t, echo)
+	r := runCommand(t, "bench", "-url", s.url, "-path", "/ws", "-connections", "2", "-duration", "300ms", "-ramp", "5ns", "a=1")
+	if r.err != nil {
+		t.Fatalf("bench: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	if !strings.Contains(r.stdout, " connected\n") {
+		t.Errorf("no ramp timeline in output:\n%s", r.stdout)
+	}
+}
+
+// TestBenchPacedTimeoutsCounted leaves every send unanswered, so some time
+// out during the run and the rest when the final grace period ends: each
+// must reach the error breakdown as a timeout.
+func TestBenchPacedTimeoutsCounted(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		for {
+			if _, _, err := s.read(conn); err != nil {
+				return
+			}
+		}
+	})
+	isolateEnv(t)
+	opts, err := parseFlags([]string{"bench", "-url", s.url, "-path", "/ws", "-connections", "2", "-duration", "300ms",
+		"-rate", "50", "-read-timeout", "150ms", "-json", "-stats-file", filepath.Join(t.TempDir(), "stats.json"), "msg=hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr syncBuffer
+	a := newApp(opts, &stdout, &stderr)
+	if err := a.dispatch(context.Background(), opts); err != nil {
+		t.Logf("bench: %v", err) // a bench without answers may fail; the counts still matter
+	}
+	var sum benchSummary
+	if err := json.Unmarshal([]byte(stdout.String()), &sum); err != nil {
+		t.Fatalf("bench -json output: %v\n%s", err, stdout.String())
+	}
+	if sum.TimedOut == 0 || sum.TimedOut != sum.Sent {
+		t.Fatalf("sent %d, timed out %d: want every send timed out", sum.Sent, sum.TimedOut)
+	}
+	if got := a.summary(opts, time.Now(), nil, 0).Errors["timeout"]; got != sum.TimedOut {
+		t.Errorf("error breakdown has %d timeouts, want the %d reported", got, sum.TimedOut)
+	}
+}
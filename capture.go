@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// captureRef matches a {{capture.NAME}} placeholder, with the quotes around
+// it when it makes up a whole JSON string.
+var captureRef = regexp.MustCompile(`"\{\{capture\.([A-Za-z0-9_-]+)\}\}"|\{\{capture\.([A-Za-z0-9_-]+)\}\}`)
+
+var captureName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// shellName is what a -capture name must look like with -capture-file, so
+// the file can be sourced by a shell.
+var shellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// captureFlag collects -capture NAME=path values.
+type captureFlag []string
+
+func (f *captureFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *captureFlag) Set(v string) error {
+	name, path, ok := strings.Cut(v, "=")
+	if !ok || path == "" || !captureName.MatchString(name) {
+		return fmt.Errorf("invalid -capture %q (want NAME=path)", v)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// captureSet holds the -capture values: the first value every path yields
+// in a received message on the current connection. A value from an
+// earlier connection stays until the new one yields its own, so a
+// reconnect can still present it. All methods are no-ops on a nil
+// *captureSet.
+type captureSet struct {
+	mu      sync.Mutex
+	paths   map[string]string          // name -> path
+	vals    map[string]json.RawMessage // captured so far
+	fresh   map[string]bool            // captured on the current connection
+	timeout time.Duration              // -capture-timeout
+
+	file string    // -capture-file, rewritten on every new value
+	errw io.Writer // where a failed write is reported
+}
+
+func newCaptureSet(specs captureFlag, timeout time.Duration) *captureSet {
+	if len(specs) == 0 {
+		return nil
+	}
+	s := &captureSet{paths: make(map[string]string), vals: make(map[string]json.RawMessage), fresh: make(map[string]bool), timeout: timeout}
+	for _, spec := range specs {
+		name, path, _ := strings.Cut(spec, "=")
+		s.paths[name] = path
+	}
+	return s
+}
+
+// observe captures the values msg carries for names not captured yet.
+func (s *captureSet) observe(msg client.Message) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.fresh) == len(s.paths) {
+		return
+	}
+	changed := false
+	var doc any
+	if err := json.Unmarshal(msg.Data, &doc); err != nil {
+		return
+	}
+	for name, path := range s.paths {
+		if s.fresh[name] {
+			continue
+		}
+		if v, ok := lookupPath(doc, path); ok {
+			raw, _ := json.Marshal(v)
+			changed = changed || !bytes.Equal(s.vals[name], raw)
+			s.vals[name] = raw
+			s.fresh[name] = true
+		}
+	}
+	if changed && s.file != "" {
+		if err := writeFileAtomic(s.file, s.dotenv()); err != nil {
+			fmt.Fprintf(s.errw, "error: -capture-file: %v\n", err)
+		}
+	}
+}
+
+// toFile makes every new value rewrite file with all values captured so
+// far.
+func (s *captureSet) toFile(file string, errw io.Writer) {
+	if s == nil {
+		return
+	}
+	s.file, s.errw = file, errw
+}
+
+// dotenv renders the captured values as sorted NAME=value lines. Strings
+// are written as their text, other values as JSON; a value with characters
+// a shell would interpret is double-quoted and escaped.
+func (s *captureSet) dotenv() []byte {
+	names := make([]string, 0, len(s.vals))
+	for name := range s.vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		raw := s.vals[name]
+		value := string(raw)
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			value = str
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, dotenvQuote(value))
+	}
+	return b.Bytes()
+}
+
+var dotenvPlain = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+
+func dotenvQuote(v string) string {
+	if dotenvPlain.MatchString(v) {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// connected starts capturing anew for a new connection.
+func (s *captureSet) connected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.fresh)
+}
+
+// checkRefs fails when text refers to a name no -capture defines.
+func (s *captureSet) checkRefs(text []byte) error {
+	for _, name := range captureNames(text) {
+		if s == nil || s.paths[name] == "" {
+			return fmt.Errorf("{{capture.%s}} is used but no -capture %s=path is given", name, name)
+		}
+	}
+	return nil
+}
+
+// expand substitutes the captured values into text. A placeholder that is
+// a whole JSON string becomes the value in its JSON form, so numbers and
+// objects keep their type; elsewhere a string value is inserted escaped for
+// use inside a JSON string. It also returns the referenced names not
+// captured yet.
+func (s *captureSet) expand(text []byte) ([]byte, []string) {
+	if s == nil {
+		return text, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var missing []string
+	out := captureRef.ReplaceAllFunc(text, func(ref []byte) []byte {
+		m := captureRef.FindSubmatch(ref)
+		name, quoted := string(m[2]), false
+		if len(m[1]) > 0 {
+			name, quoted = string(m[1]), true
+		}
+		raw, ok := s.vals[name]
+		if !ok {
+			missing = append(missing, name)
+			return ref
+		}
+		if !quoted && len(raw) > 0 && raw[0] == '"' {
+			return raw[1 : len(raw)-1]
+		}
+		return raw
+	})
+	return out, missing
+}
+
+func captureNames(text []byte) []string {
+	var names []string
+	for _, m := range captureRef.FindAllSubmatch(text, -1) {
+		name := m[2]
+		if len(m[1]) > 0 {
+			name = m[1]
+		}
+		names = append(names, string(name))
+	}
+	return names
+}
+
+// withCaptures returns payload with its {{capture.NAME}} placeholders
+// filled in. While some are not captured yet it keeps printing incoming
+// messages, for up to -capture-timeout.
+func (a *app) withCaptures(ctx context.Context, c *client.Client, payload []byte) ([]byte, error) {
+	if a.captures == nil || !bytes.Contains(payload, []byte("{{capture.")) {
+		return payload, nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, a.captures.timeout)
+	defer cancel()
+	for {
+		out, missing := a.captures.expand(payload)
+		if len(missing) == 0 {
+			return out, nil
+		}
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				return nil, fmt.Errorf("connection closed before -capture %s matched", missing[0])
+			}
+			a.out.handle(msg)
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			a.closeAndDrain(c, "capture timeout")
+			return nil, fmt.Errorf("-capture %s: no message matched %q within %s", missing[0], a.captures.paths[missing[0]], a.captures.timeout)
+		}
+	}
+}
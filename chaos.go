@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// chaosFaults are the faults -chaos injects, in the order they are listed.
+var chaosFaults = []string{"drop", "close", "silent"}
+
+// chaosMix is the relative weight of every fault, e.g. "drop=2,close=1".
+// Faults left out are never injected.
+type chaosMix map[string]int
+
+func parseChaosMix(spec string) (chaosMix, error) {
+	mix := make(chaosMix)
+	total := 0
+	for _, part := range strings.Split(spec, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid -chaos-mix entry %q (want fault=weight)", part)
+		}
+		found := false
+		for _, f := range chaosFaults {
+			found = found || f == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown -chaos-mix fault %q (use %s)", name, strings.Join(chaosFaults, ", "))
+		}
+		mix[name] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("-chaos-mix needs at least one positive weight")
+	}
+	return mix, nil
+}
+
+// pick draws a fault according to the weights.
+func (m chaosMix) pick(rng *rand.Rand) string {
+	total := 0
+	for _, f := range chaosFaults {
+		total += m[f]
+	}
+	n := rng.IntN(total)
+	for _, f := range chaosFaults {
+		if n < m[f] {
+			return f
+		}
+		n -= m[f]
+	}
+	return chaosFaults[len(chaosFaults)-1]
+}
+
+// chaosReport is the bookkeeping of a -chaos run.
+type chaosReport struct {
+	start       time.Time
+	faults      map[string]int
+	serverCodes map[int]int // close codes of connections the server ended
+	silentClose int         // silences the server answered by ending the connection
+	silentNone  int         // silences the server let pass
+	reconnects  int         // dial attempts after the first connection
+	reconnected int         // ... that succeeded
+}
+
+// chaos keeps a session going while misbehaving on purpose: every
+// -chaos-interval it injects, with -chaos-probability, a fault drawn from
+// -chaos-mix, then reconnects and resends the payload. It stops on Ctrl-C or
+// after -max-duration and reports the faults and the server's reactions.
+func (a *app) chaos(ctx context.Context, opts options, j job) error {
+	if opts.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.maxDuration)
+		defer cancel()
+	}
+	seed := opts.chaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Fprintf(a.stderr, "chaos: seed %d\n", seed)
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	r := &chaosReport{start: time.Now(), faults: make(map[string]int), serverCodes: make(map[int]int)}
+	backoff := newBackoff(opts.reconnectDelay, opts.reconnectMaxDelay, opts.jitter)
+	first := true
+	for ctx.Err() == nil {
+		var err error
+		if j.first, err = a.connPayload(opts, j.payload); err != nil {
+			return err
+		}
+		c, err := a.connect(ctx, opts, j.signed())
+		if !first {
+			r.reconnects++
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			a.metrics.fail("dial")
+			delay := backoff.next()
+			fmt.Fprintf(a.stderr, "%s dial failed: %v; retrying in %s\n", time.Now().Format(time.RFC3339), err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if !first {
+			r.reconnected++
+			a.metrics.reconnect()
+		}
+		first = false
+		backoff.reset()
+		fmt.Fprintf(a.stderr, "%s connected\n", time.Now().Format(time.RFC3339))
+		a.chaosSession(ctx, c, opts, j, rng, r)
+		if ctx.Err() != nil {
+			break
+		}
+		delay := backoff.next()
+		fmt.Fprintf(a.stderr, "%s reconnecting in %s\n", time.Now().Format(time.RFC3339), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	r.print(a.stderr, time.Now())
+	return nil
+}
+
+// chaosSession runs one connection until a fault ends it, the server closes
+// it or ctx is done.
+func (a *app) chaosSession(ctx context.Context, c *client.Client, opts options, j job, rng *rand.Rand, r *chaosReport) {
+	defer a.startHeartbeat(c, opts)()
+	if j.payload != nil {
+		switch err := a.send(ctx, c, j.signed()); {
+		case errors.Is(err, errMaxSends):
+		case err != nil:
+			fmt.Fprintf(a.stderr, "send message: %v\n", err)
+			a.closeAndDrain(c, "")
+			return
+		default:
+			a.printSent(a.stdout, j.signed())
+		}
+	}
+
+	ticker := time.NewTicker(opts.chaosInterval)
+	defer ticker.Stop()
+	var silentEnd <-chan time.Time
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				code := closeCode(c.Err())
+				r.serverCodes[code]++
+				a.lastClose.Store(int64(code))
+				if silentEnd != nil {
+					r.silentClose++
+				}
+				fmt.Fprintf(a.stderr, "%s server ended the connection: code %d: %v\n", time.Now().Format(time.RFC3339), code, c.Err())
+				a.events.emit("closed", map[string]any{"code": code, "error": errorString(c.Err())})
+				return
+			}
+			a.out.handle(msg)
+		case <-silentEnd:
+			silentEnd = nil
+			r.silentNone++
+			fmt.Fprintf(a.stderr, "%s chaos: silence over, the server kept the connection\n", time.Now().Format(time.RFC3339))
+		case <-ticker.C:
+			if silentEnd != nil || rng.Float64() >= opts.chaosProbability {
+				continue
+			}
+			fault := opts.chaosMix.pick(rng)
+			r.faults[fault]++
+			a.events.emit("chaos", map[string]any{"fault": fault})
+			now := time.Now().Format(time.RFC3339)
+			switch fault {
+			case "drop":
+				fmt.Fprintf(a.stderr, "%s chaos: drop (connection cut without a close frame)\n", now)
+				c.Drop()
+			case "close":
+				fmt.Fprintf(a.stderr, "%s chaos: close (close frame 1001 and an immediate drop)\n", now)
+				c.CloseAbruptly(websocket.CloseGoingAway, "chaos")
+			case "silent":
+				fmt.Fprintf(a.stderr, "%s chaos: silent for %s (pings unanswered, nothing sent)\n", now, opts.chaosSilence)
+				c.Silence(opts.chaosSilence)
+				silentEnd = time.After(opts.chaosSilence)
+				continue
+			}
+			for range c.Receive() {
+			}
+			return
+		case <-ctx.Done():
+			a.closeAndDrain(c, "chaos finished")
+			return
+		}
+	}
+}
+
+func (r *chaosReport) print(w io.Writer, now time.Time) {
+	total := 0
+	var parts []string
+	for _, f := range chaosFaults {
+		total += r.faults[f]
+		parts = append(parts, fmt.Sprintf("%s %d", f, r.faults[f]))
+	}
+	fmt.Fprintf(w, "chaos: %s, %d fault(s) injected: %s\n", now.Sub(r.start).Round(time.Second), total, strings.Join(parts, ", "))
+	if len(r.serverCodes) == 0 {
+		fmt.Fprintln(w, "server closes: none")
+	} else {
+		codes := make([]int, 0, len(r.serverCodes))
+		for code := range r.serverCodes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		parts = parts[:0]
+		for _, code := range codes {
+			parts = append(parts, fmt.Sprintf("%d x%d", code, r.serverCodes[code]))
+		}
+		fmt.Fprintf(w, "server closes: %s\n", strings.Join(parts, ", "))
+	}
+	if r.faults["silent"] > 0 {
+		fmt.Fprintf(w, "silences: %d ended by the server, %d tolerated\n", r.silentClose, r.silentNone)
+	}
+	rate := 100.0
+	if r.reconnects > 0 {
+		rate = 100 * float64(r.reconnected) / float64(r.reconnects)
+	}
+	fmt.Fprintf(w, "reconnects: %d/%d succeeded (%.1f%%)\n", r.reconnected, r.reconnects, rate)
+}
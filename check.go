@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// checkCloseWait bounds how long -check waits for the server's close reply,
+// so a check never takes much longer than -dial-timeout.
+const checkCloseWait = time.Second
+
+// errCheckFailed is returned by check after it has already printed the
+// outcome line; main exits non-zero without adding an error message.
+var errCheckFailed = errors.New("check failed")
+
+// check completes one handshake, closes it with 1000 right away and prints a
+// single line such as
+//
+//	ok url=wss://example.com/ws connect_ms=42.117 status=101
+//	fail url=wss://example.com/ws connect_ms=10000.412 error="dial tcp: i/o timeout"
+func (a *app) check(ctx context.Context, opts options) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.dialTimeout)
+	defer cancel()
+
+	target := opts.baseURL
+	if copts, err := clientOptions(opts, nil); err == nil {
+		target = copts.URL
+	}
+	start := time.Now()
+	c, err := a.connect(ctx, opts, nil)
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		fmt.Fprintf(a.stdout, "fail url=%s connect_ms=%.3f error=%q\n", target, elapsed, err.Error())
+		return errCheckFailed
+	}
+	status := 0
+	if resp := c.Response(); resp != nil {
+		status = resp.StatusCode
+	}
+	_ = c.Close(websocket.CloseNormalClosure, "")
+	select {
+	case <-c.Done():
+	case <-time.After(checkCloseWait):
+	}
+	fmt.Fprintf(a.stdout, "ok url=%s connect_ms=%.3f status=%d\n", target, elapsed, status)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// churnReport is the bookkeeping of a -churn run.
+type churnReport struct {
+	mu         sync.Mutex
+	cycles     int
+	handshakes []time.Duration
+	dialFails  int
+	abnormal   int            // cycles the server ended with a code other than 1000
+	failures   []churnFailure // every failed cycle, in the order they ended
+}
+
+type churnFailure struct {
+	cycle int
+	err   error
+}
+
+func (r *churnReport) fail(cycle int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, churnFailure{cycle, err})
+	var dialErr *dialError
+	switch {
+	case errors.As(err, &dialErr):
+		r.dialFails++
+	case errors.Is(err, errAbnormalClose):
+		r.abnormal++
+	}
+}
+
+// errAbnormalClose marks a churn cycle the server ended with a close code
+// other than 1000.
+var errAbnormalClose = errors.New("server closed the connection")
+
+// churn dials, sends the payload if any, reads up to -expect-count messages,
+// closes cleanly and starts over, -churn times in all over -churn-parallel
+// loops (or until -max-duration), to shake out bugs that only show after
+// many connect/disconnect cycles. Messages are counted, not printed. A failed
+// cycle does not stop the run unless -fail-fast is set; the summary reports
+// the connect time percentiles and every failed cycle.
+func (a *app) churn(ctx context.Context, opts options, j job) error {
+	if opts.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.maxDuration)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	start := time.Now()
+	var rep churnReport
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range opts.churnParallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				cycle := int(next.Add(1))
+				if cycle > opts.churn {
+					return
+				}
+				if err := a.churnCycle(ctx, opts, j, &rep); err != nil {
+					if ctx.Err() != nil {
+						return // cut short by Ctrl-C or -max-duration
+					}
+					rep.fail(cycle, err)
+					if opts.failFast {
+						stop()
+					}
+				}
+				rep.mu.Lock()
+				rep.cycles++
+				rep.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	rep.print(a.stdout, opts.churn, time.Since(start))
+	if n := len(rep.failures); n > 0 {
+		return fmt.Errorf("%d of %d churn cycles failed", n, rep.cycles)
+	}
+	return nil
+}
+
+// churnCycle runs one connection of a -churn run.
+func (a *app) churnCycle(ctx context.Context, opts options, j job, rep *churnReport) (err error) {
+	if j.first, err = a.connPayload(opts, j.payload); err != nil {
+		return err
+	}
+	c, err := a.connect(ctx, opts, j.signed())
+	if err != nil {
+		a.metrics.fail("dial")
+		return &dialError{err}
+	}
+	rep.mu.Lock()
+	rep.handshakes = append(rep.handshakes, c.HandshakeDuration())
+	rep.mu.Unlock()
+	closed := false
+	defer func() {
+		if !closed {
+			_ = c.Close(websocket.CloseNormalClosure, "")
+		}
+		for range c.Receive() {
+		}
+	}()
+
+	if j.payload != nil {
+		if err := a.send(ctx, c, j.signed()); err != nil && !errors.Is(err, errMaxSends) {
+			return fmt.Errorf("send message: %w", err)
+		}
+	}
+	var timeout <-chan time.Time
+	if opts.readTimeout > 0 {
+		timeout = time.After(opts.readTimeout)
+	}
+	for received := 0; received < opts.expectCount; {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				closed = true
+				if code := closeCode(c.Err()); code != websocket.CloseNormalClosure {
+					a.metrics.fail("closed")
+					return fmt.Errorf("%w with %d after %d message(s)", errAbnormalClose, code, received)
+				}
+				return fmt.Errorf("server closed the connection after %d of %d message(s)", received, opts.expectCount)
+			}
+			a.metrics.received(msg)
+			received++
+		case <-timeout:
+			return fmt.Errorf("only %d of %d message(s) within %s", received, opts.expectCount, opts.readTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// maxChurnFailures caps the failed cycles listed in the summary.
+const maxChurnFailures = 20
+
+func (r *churnReport) print(w io.Writer, want int, elapsed time.Duration) {
+	fmt.Fprintf(w, "cycles:      %d of %d in %s, %d failed\n", r.cycles, want, elapsed.Round(time.Millisecond), len(r.failures))
+	fmt.Fprintf(w, "failures:    %d handshake, %d abnormal server close, %d other\n", r.dialFails, r.abnormal, len(r.failures)-r.dialFails-r.abnormal)
+	printSpread(w, "connect:    ", r.handshakes)
+	sort.Slice(r.failures, func(i, j int) bool { return r.failures[i].cycle < r.failures[j].cycle })
+	for i, f := range r.failures {
+		if i == maxChurnFailures {
+			fmt.Fprintf(w, "... and %d more failed cycles\n", len(r.failures)-i)
+			break
+		}
+		fmt.Fprintf(w, "cycle %d: %v\n", f.cycle, f.err)
+	}
+}
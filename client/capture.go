@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// errCaptured stops the dialer once the upgrade request has been captured.
+var errCaptured = errors.New("handshake request captured")
+
+// HandshakeRequest builds the upgrade request Connect would send for opts,
+// including the headers the dialer adds, and returns it in wire format
+// without opening a connection or resolving the host.
+func HandshakeRequest(ctx context.Context, opts Options) ([]byte, error) {
+	capture := &captureConn{}
+	var conn net.Conn = capture
+	if reqFn, respFn, ok := opts.rewriters(); ok {
+		conn = &handshakeConn{Conn: capture, rewriteRequest: reqFn, rewriteResponse: respFn}
+	}
+	dialer := newDialer(opts, nil, nil)
+	dial := func(context.Context, string, string) (net.Conn, error) { return conn, nil }
+	dialer.NetDialContext = dial
+	dialer.NetDialTLSContext = dial // the capture stands in for the TLS layer too
+
+	_, _, err := dialer.DialContext(ctx, opts.URL, opts.Header)
+	if capture.req.Len() == 0 {
+		return nil, err
+	}
+	head := capture.req.Bytes()
+	if i := bytes.Index(head, headerEnd); i >= 0 {
+		head = head[:i+len(headerEnd)]
+	}
+	return head, nil
+}
+
+// captureConn records what is written to it and fails every read.
+type captureConn struct {
+	req bytes.Buffer
+}
+
+func (c *captureConn) Write(p []byte) (int, error)      { return c.req.Write(p) }
+func (c *captureConn) Read([]byte) (int, error)         { return 0, errCaptured }
+func (c *captureConn) Close() error                     { return nil }
+func (c *captureConn) LocalAddr() net.Addr              { return captureAddr{} }
+func (c *captureConn) RemoteAddr() net.Addr             { return captureAddr{} }
+func (c *captureConn) SetDeadline(time.Time) error      { return nil }
+func (c *captureConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *captureConn) SetWriteDeadline(time.Time) error { return nil }
+
+type captureAddr struct{}
+
+func (captureAddr) Network() string { return "capture" }
+func (captureAddr) String() string  { return "capture" }
@@ -0,0 +1,570 @@
+// Package client implements the WebSocket session behind the postws CLI so
+// the same connect/send/receive/close flow can be embedded in other programs
+// and test suites.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeGrace is how long Close waits for the server to acknowledge the close
+// frame before tearing down the underlying connection.
+const closeGrace = 2 * time.Second
+
+// Options configures a Client. Build URL with BuildURL to get the same path
+// and port handling as the CLI.
+type Options struct {
+	URL                string        // full ws:// or wss:// URL
+	Header             http.Header   // extra handshake headers
+	DialTimeout        time.Duration // handshake timeout (0 means no limit besides ctx)
+	InsecureSkipVerify bool          // skip TLS verification for wss:// (testing only)
+	DNSServer          string        // host:port of a DNS server to resolve the host with (optional)
+
+	// ChallengeKey fixes Sec-WebSocket-Key instead of gorilla's random
+	// value, for reproducible handshakes in tests. It must be the base64
+	// encoding of 16 bytes.
+	ChallengeKey string
+
+	// Quirks bend the upgrade request and response for legacy servers;
+	// they are for interop with such servers only.
+	Quirks HandshakeQuirks
+
+	// Extensions are offered in Sec-WebSocket-Extensions, e.g.
+	// "permessage-deflate; client_max_window_bits". gorilla refuses that
+	// header in Header, so it is added to the raw request instead. Only
+	// permessage-deflate is then actually implemented by the connection.
+	Extensions []string
+
+	// Compression negotiates permessage-deflate and compresses sent
+	// messages when the server agrees.
+	Compression bool
+
+	// CountWire counts the bytes that cross the network connection, after
+	// compression and including framing (and TLS), for WireBytes.
+	CountWire bool
+
+	// SendLimit paces every outgoing byte, handshake and control frames
+	// included, through a token bucket (nil sends at full speed). Waiting
+	// for it does not count against write deadlines.
+	SendLimit *RateLimiter
+
+	// RecvLimit paces the consumption of incoming bytes the same way, so
+	// the kernel buffers fill up and TCP pushes back on the server. Its
+	// Sent reports the bytes read.
+	RecvLimit *RateLimiter
+
+	// ReadDelay sleeps before reading every message, to play a client
+	// that drains slowly. With ReadDelayMax above it, each sleep is drawn
+	// uniformly from [ReadDelay, ReadDelayMax].
+	ReadDelay    time.Duration
+	ReadDelayMax time.Duration
+
+	// TrackCompression follows the server's frame headers to fill in
+	// Message.Compressed and Message.WireSize, and CompressionStats.
+	TrackCompression bool
+
+	// MaxMessageSize makes a larger incoming message end the session with
+	// close code 1009 (0 is unlimited).
+	MaxMessageSize int64
+
+	// Termination options end the session with a graceful close (code
+	// 1000) when they are reached, as the CLI's -read-timeout and
+	// -expect-count do; Err then reports ErrIdleTimeout, ErrReadTimeout or
+	// ErrMaxMessages, and messages read while closing are discarded. Zero
+	// disables each.
+	//
+	// IdleTimeout ends the session when no message arrives for this long;
+	// every message read extends it.
+	IdleTimeout time.Duration
+	// ReadTimeout ends the session this long after the handshake, however
+	// busy it is.
+	ReadTimeout time.Duration
+	// MaxMessages ends the session once this many messages have been
+	// delivered (on Receive or to OnMessage).
+	MaxMessages int
+
+	// Hooks let importers process the session without reading the
+	// channels. They run on the read loop goroutine (OnConnect on the one
+	// calling Connect), so they should not block for long.
+	//
+	// OnConnect is called once the handshake succeeded, before any message
+	// is read.
+	OnConnect func(c *Client)
+	// OnMessage receives every message instead of Receive; the Receive
+	// channel is then only closed at the end. When it is nil, messages
+	// are delivered on Receive, which is what the CLI consumes.
+	OnMessage func(msgType int, data []byte)
+	// OnClose is called when the session ends with a close frame, with the
+	// code and reason the server sent.
+	OnClose func(code int, reason string)
+	// OnError is called when the session ends any other way, e.g. a
+	// dropped connection or a protocol error.
+	OnError func(err error)
+	// OnPing is called for every ping the server sends, with its payload.
+	OnPing func(appData []byte)
+
+	// NoAutoPong leaves the server's pings unanswered, to test how the
+	// server treats a client that ignores them.
+	NoAutoPong bool
+
+	// PingPayload is the application data of the pings Ping sends, at most
+	// 125 bytes. Empty uses a sequence number, which lets concurrent pings
+	// tell their pongs apart; a fixed payload matches any pong echoing it.
+	PingPayload []byte
+}
+
+// Message is a single frame received from the server.
+type Message struct {
+	Type int // websocket.TextMessage or websocket.BinaryMessage
+	Data []byte
+	Time time.Time // when the message had been read completely
+
+	// FirstByte is when the start of the message arrived, before its
+	// payload was read. The gap to Time is the transfer time of the
+	// message itself.
+	FirstByte time.Time
+
+	// Compressed reports that the message arrived with permessage-deflate
+	// applied, and WireSize is its payload size on the wire. Both are only
+	// set with Options.TrackCompression.
+	Compressed bool
+	WireSize   int64
+}
+
+// Client is an established WebSocket connection with a background read loop
+// delivering messages on Receive.
+type Client struct {
+	conn      *websocket.Conn
+	resp      *http.Response
+	handshake time.Duration
+
+	msgs    chan Message
+	done    chan struct{}
+	err     error
+	writeMu sync.Mutex
+
+	pingSeq atomic.Uint64
+	pongMu  sync.Mutex
+	pongs   map[string]chan time.Time
+
+	wire   *wireCounter // nil unless Options.CountWire
+	frames *frameLog    // nil unless Options.TrackCompression
+	hooks  Options      // only the On* callbacks, NoAutoPong, PingPayload and the termination options are used
+
+	silentUntil atomic.Int64 // unix nanoseconds until which pings go unanswered
+
+	slow *slowReader // nil unless ReadDelay or RecvLimit is set
+
+	readTimer *time.Timer // nil unless ReadTimeout is set
+	idleTimer *time.Timer // nil unless IdleTimeout is set
+	delivered int         // messages delivered, for MaxMessages
+	stopOnce  sync.Once
+	stopMu    sync.Mutex
+	stopErr   error // the termination option that ended the session
+
+	closeOnce sync.Once
+	closeErr  error
+	forced    chan struct{}
+	forceOnce sync.Once
+}
+
+// Connect dials opts.URL and starts the read loop. ctx bounds the dial and
+// handshake only; use Close to end the session. A refused upgrade yields a
+// *HandshakeError carrying the server's response.
+func Connect(ctx context.Context, opts Options) (*Client, error) {
+	var wire *wireCounter
+	if opts.CountWire {
+		wire = &wireCounter{}
+	}
+	var frames *frameLog
+	if opts.TrackCompression {
+		frames = &frameLog{handshake: true}
+	}
+	dialer := newDialer(opts, wire, frames)
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, opts.URL, opts.Header)
+	if err != nil {
+		if resp != nil {
+			err = newHandshakeError(resp, err)
+		}
+		return nil, fmt.Errorf("dial %s: %w", opts.URL, err)
+	}
+
+	c := &Client{
+		conn:      conn,
+		resp:      resp,
+		handshake: time.Since(start),
+		msgs:      make(chan Message),
+		done:      make(chan struct{}),
+		forced:    make(chan struct{}),
+		pongs:     make(map[string]chan time.Time),
+		wire:      wire,
+		frames:    frames,
+		hooks:     opts,
+	}
+	if opts.Compression {
+		conn.EnableWriteCompression(true)
+	}
+	if opts.MaxMessageSize > 0 {
+		conn.SetReadLimit(opts.MaxMessageSize)
+	}
+	if opts.ReadDelay > 0 || opts.RecvLimit != nil {
+		c.slow = &slowReader{delay: opts.ReadDelay, delayMax: opts.ReadDelayMax, raw: rawConn(conn.NetConn())}
+	}
+	conn.SetPongHandler(c.handlePong)
+	conn.SetPingHandler(c.handlePing)
+	if opts.OnConnect != nil {
+		opts.OnConnect(c)
+	}
+	c.startLimits()
+	go c.readLoop()
+	return c, nil
+}
+
+// newDialer builds the gorilla dialer for opts. With wire set, the network
+// connection is wrapped to count its bytes; with opts.SendLimit or
+// opts.RecvLimit, to pace them; with frames, to follow the frame headers.
+func newDialer(opts Options, wire *wireCounter, frames *frameLog) *websocket.Dialer {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout:  opts.DialTimeout,
+		EnableCompression: opts.Compression,
+	}
+	var tlsConfig *tls.Config
+	if strings.HasPrefix(opts.URL, "wss://") {
+		tlsConfig = &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify} //nolint:gosec // optional override for testing
+		dialer.TLSClientConfig = tlsConfig
+	}
+	netDialer := &net.Dialer{}
+	if opts.DNSServer != "" {
+		netDialer.Resolver = newResolver(opts.DNSServer)
+		dialer.NetDialContext = netDialer.DialContext
+	}
+
+	reqFn, respFn, rewrite := opts.rewriters()
+	if !rewrite && wire == nil && frames == nil && opts.SendLimit == nil && opts.RecvLimit == nil {
+		return dialer
+	}
+	// wrap applies the handshake rewriting and the frame tracking, which
+	// must happen above TLS.
+	wrap := func(conn net.Conn) net.Conn {
+		if rewrite {
+			conn = &handshakeConn{Conn: conn, rewriteRequest: reqFn, rewriteResponse: respFn}
+		}
+		if frames != nil {
+			conn = &sniffConn{Conn: conn, log: frames}
+		}
+		return conn
+	}
+	// dial opens the network connection, counted and paced below TLS.
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := netDialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if wire != nil {
+			conn = &countingConn{Conn: conn, wire: wire}
+		}
+		if opts.SendLimit != nil || opts.RecvLimit != nil {
+			conn = &throttledConn{Conn: conn, limit: opts.SendLimit, readLimit: opts.RecvLimit}
+		}
+		return conn, nil
+	}
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(conn), nil
+	}
+	if tlsConfig != nil {
+		// Do the TLS handshake here instead of inside gorilla, so the
+		// rewriting sits above TLS and the counting below it.
+		dialer.NetDialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg := tlsConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return wrap(tlsConn), nil
+		}
+	}
+	return dialer
+}
+
+// newResolver returns a resolver that sends every query to server instead of
+// the system-configured name servers.
+func newResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Response returns the server's handshake response.
+func (c *Client) Response() *http.Response {
+	return c.resp
+}
+
+// HandshakeDuration is how long dialing and the upgrade handshake took.
+func (c *Client) HandshakeDuration() time.Duration {
+	return c.handshake
+}
+
+// Receive returns the channel of incoming messages. It is closed when the
+// connection ends; Err then reports why. Callers must keep draining it,
+// otherwise the read loop (and a graceful Close) stalls. With
+// Options.OnMessage set, no messages are delivered on it.
+func (c *Client) Receive() <-chan Message {
+	return c.msgs
+}
+
+// Done is closed when the connection has ended, after the last message was
+// delivered on Receive.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the read loop, or ErrIdleTimeout,
+// ErrReadTimeout or ErrMaxMessages when a termination option did. It is
+// only meaningful once the Receive channel has been closed.
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Send writes payload as a text message.
+func (c *Client) Send(ctx context.Context, payload []byte) error {
+	return c.SendMessage(ctx, websocket.TextMessage, payload)
+}
+
+// SendMessage writes a message of the given type. A deadline on ctx becomes
+// the write deadline.
+func (c *Client) SendMessage(ctx context.Context, msgType int, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(msgType, data)
+}
+
+// Ping sends a ping control frame and waits for the matching pong,
+// returning the round-trip time. Pongs are handled by the read loop, so
+// Receive must keep being drained meanwhile.
+func (c *Client) Ping(ctx context.Context) (time.Duration, error) {
+	id := strconv.FormatUint(c.pingSeq.Add(1), 10)
+	if len(c.hooks.PingPayload) > 0 {
+		id = string(c.hooks.PingPayload)
+	}
+	ch := make(chan time.Time, 1)
+	c.pongMu.Lock()
+	c.pongs[id] = ch
+	c.pongMu.Unlock()
+	defer func() {
+		c.pongMu.Lock()
+		delete(c.pongs, id)
+		c.pongMu.Unlock()
+	}()
+
+	deadline, _ := ctx.Deadline()
+	start := time.Now()
+	if err := c.conn.WriteControl(websocket.PingMessage, []byte(id), deadline); err != nil {
+		return 0, err
+	}
+	select {
+	case at := <-ch:
+		return at.Sub(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.done:
+		return 0, fmt.Errorf("connection closed: %w", c.err)
+	}
+}
+
+func (c *Client) handlePong(appData string) error {
+	now := time.Now()
+	c.pongMu.Lock()
+	defer c.pongMu.Unlock()
+	if ch, ok := c.pongs[appData]; ok {
+		select {
+		case ch <- now:
+		default:
+		}
+	}
+	return nil
+}
+
+// handlePing answers like gorilla's default handler, except with
+// NoAutoPong or while Silence is in effect.
+func (c *Client) handlePing(appData string) error {
+	if c.hooks.OnPing != nil {
+		c.hooks.OnPing([]byte(appData))
+	}
+	if c.hooks.NoAutoPong || c.Silenced() {
+		return nil
+	}
+	err := c.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return nil
+	}
+	return err
+}
+
+// Silence stops answering the server's pings for d, to test how the server
+// treats an unresponsive client. Messages are still read and delivered.
+func (c *Client) Silence(d time.Duration) {
+	c.silentUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// Silenced reports whether a Silence period is in effect.
+func (c *Client) Silenced() bool {
+	return time.Now().UnixNano() < c.silentUntil.Load()
+}
+
+// Drop tears down the network connection without a close frame, as a lost
+// network would. Receive is closed once the read loop notices.
+func (c *Client) Drop() {
+	c.forceClose()
+}
+
+// CloseAbruptly sends a close frame with code and reason and drops the
+// connection right away, without waiting for the server's answer.
+func (c *Client) CloseAbruptly(code int, reason string) {
+	c.closeOnce.Do(func() {
+		_ = c.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(time.Second),
+		)
+	})
+	c.forceClose()
+}
+
+// Close starts a graceful close by sending a close frame with code and
+// reason. It returns without waiting; Receive is closed once the server
+// answers or closeGrace elapses. Only the first call has any effect.
+func (c *Client) Close(code int, reason string) error {
+	c.closeOnce.Do(func() {
+		err := c.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(time.Second),
+		)
+		if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+			c.closeErr = err
+		}
+		time.AfterFunc(closeGrace, c.forceClose)
+	})
+	return c.closeErr
+}
+
+func (c *Client) forceClose() {
+	c.forceOnce.Do(func() {
+		close(c.forced)
+		_ = c.conn.Close()
+	})
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	defer close(c.msgs)
+	defer c.forceClose()
+	defer c.stopLimits()
+	for {
+		c.slow.wait(c.forced)
+		msg, err := c.readMessage()
+		if err != nil {
+			// The read loop exits on normal close or any read error.
+			c.err = err
+			if reason := c.stopped(); reason != nil {
+				c.err = reason
+			}
+			c.notifyEnd(err)
+			return
+		}
+		c.activity()
+		if c.stopped() != nil {
+			continue // read while closing
+		}
+		if c.hooks.OnMessage != nil {
+			c.hooks.OnMessage(msg.Type, msg.Data)
+		} else {
+			select {
+			case c.msgs <- msg:
+			case <-c.forced:
+				return
+			}
+		}
+		if c.delivered++; c.hooks.MaxMessages > 0 && c.delivered == c.hooks.MaxMessages {
+			c.stop(ErrMaxMessages, "message limit reached")
+		}
+	}
+}
+
+// notifyEnd reports why the read loop ended to OnClose or OnError.
+func (c *Client) notifyEnd(err error) {
+	// gorilla reports a connection lost without a close frame as code
+	// 1006, which is never sent on the wire.
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) && ce.Code != websocket.CloseAbnormalClosure {
+		if c.hooks.OnClose != nil {
+			c.hooks.OnClose(ce.Code, ce.Text)
+		}
+		return
+	}
+	if c.hooks.OnError != nil {
+		c.hooks.OnError(err)
+	}
+}
+
+// readMessage is conn.ReadMessage split at NextReader, which returns as soon
+// as the frame header has arrived, to timestamp the first byte.
+func (c *Client) readMessage() (Message, error) {
+	msgType, r, err := c.conn.NextReader()
+	if err != nil {
+		return Message{}, err
+	}
+	first := time.Now()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{Type: msgType, Data: data, Time: time.Now(), FirstByte: first}
+	if c.frames != nil {
+		f := c.frames.next()
+		msg.Compressed, msg.WireSize = f.compressed, f.wire
+	}
+	return msg, nil
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newServer starts an httptest WebSocket server that runs handle on every
+// connection and returns its ws:// URL. It agrees to permessage-deflate
+// when the client offers it.
+func newServer(t testing.TB, handle func(r *http.Request, conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }, EnableCompression: true}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(r, conn)
+	}))
+	t.Cleanup(s.Close)
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// echo sends every message back until the client closes.
+func echo(_ *http.Request, conn *websocket.Conn) {
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(kind, data); err != nil {
+			return
+		}
+	}
+}
+
+// connect dials url with opts and closes the client when the test ends.
+func connect(t testing.TB, opts Options) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Connect(ctx, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(c.Drop)
+	return c
+}
+
+// next returns the next message on Receive, failing the test if none
+// arrives in time or the channel is closed.
+func next(t *testing.T, c *Client) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-c.Receive():
+		if !ok {
+			t.Fatalf("Receive closed: %v", c.Err())
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message within 5s")
+	}
+	return Message{}
+}
+
+// ended waits for the session to end, draining Receive, and returns the
+// messages still delivered.
+func ended(t *testing.T, c *Client) []Message {
+	t.Helper()
+	var msgs []Message
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-timeout:
+			t.Fatal("session did not end within 5s")
+		}
+	}
+}
+
+func TestConnectSendReceiveClose(t *testing.T) {
+	url := newServer(t, func(r *http.Request, conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(r.Header.Get("X-Test")))
+		echo(r, conn)
+	})
+	c := connect(t, Options{URL: url, Header: http.Header{"X-Test": {"hello"}}})
+	if got := c.Response().StatusCode; got != http.StatusSwitchingProtocols {
+		t.Errorf("handshake status = %d, want 101", got)
+	}
+	if c.HandshakeDuration() <= 0 {
+		t.Errorf("HandshakeDuration = %v, want positive", c.HandshakeDuration())
+	}
+	if msg := next(t, c); string(msg.Data) != "hello" {
+		t.Errorf("greeting = %q, want the X-Test header value", msg.Data)
+	}
+
+	if err := c.Send(context.Background(), []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg := next(t, c)
+	if msg.Type != websocket.TextMessage || string(msg.Data) != `{"a":1}` {
+		t.Errorf("echo = %d %q, want text {\"a\":1}", msg.Type, msg.Data)
+	}
+	if msg.FirstByte.IsZero() || msg.Time.Before(msg.FirstByte) {
+		t.Errorf("FirstByte %v, Time %v: want FirstByte set and not after Time", msg.FirstByte, msg.Time)
+	}
+	if err := c.SendMessage(context.Background(), websocket.BinaryMessage, []byte{0, 1}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if msg := next(t, c); msg.Type != websocket.BinaryMessage || string(msg.Data) != "\x00\x01" {
+		t.Errorf("echo = %d %q, want binary 00 01", msg.Type, msg.Data)
+	}
+
+	if err := c.Close(websocket.CloseNormalClosure, "done"); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if msgs := ended(t, c); len(msgs) != 0 {
+		t.Errorf("got %d messages after Close, want none", len(msgs))
+	}
+	if !websocket.IsCloseError(c.Err(), websocket.CloseNormalClosure) {
+		t.Errorf("Err = %v, want the server's 1000 close", c.Err())
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done not closed after Receive")
+	}
+	if err := c.Send(context.Background(), []byte("late")); err == nil {
+		t.Error("Send after close succeeded")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "go away", http.StatusForbidden)
+	}))
+	defer s.Close()
+	_, err := Connect(context.Background(), Options{URL: "ws" + strings.TrimPrefix(s.URL, "http")})
+	var he *HandshakeError
+	if !errors.As(err, &he) {
+		t.Fatalf("err = %v, want a *HandshakeError", err)
+	}
+	if he.StatusCode != http.StatusForbidden || !strings.Contains(string(he.Body), "go away") {
+		t.Errorf("HandshakeError = %d %q, want 403 with the body", he.StatusCode, he.Body)
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	c := connect(t, Options{URL: newServer(t, echo)})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Send(ctx, []byte("x")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send = %v, want context.Canceled", err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	pongs := make(chan string, 1)
+	url := newServer(t, func(_ *http.Request, conn *websocket.Conn) {
+		conn.SetPongHandler(func(data string) error { pongs <- data; return nil })
+		_ = conn.WriteControl(websocket.PingMessage, []byte("p1"), time.Now().Add(time.Second))
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("one"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("two"))
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "bye"), time.Now().Add(time.Second))
+		for { // answers the client's close and reads its pong
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	var (
+		connected bool
+		got       []string
+		pinged    []string
+		code      int
+		reason    string
+		hookErr   error
+	)
+	c := connect(t, Options{
+		URL:       url,
+		OnConnect: func(*Client) { connected = true },
+		OnMessage: func(msgType int, data []byte) {
+			got = append(got, string(data))
+		},
+		OnPing:  func(data []byte) { pinged = append(pinged, string(data)) },
+		OnClose: func(c int, r string) { code, reason = c, r },
+		OnError: func(err error) { hookErr = err },
+	})
+	if msgs := ended(t, c); len(msgs) != 0 {
+		t.Errorf("Receive delivered %d messages with OnMessage set", len(msgs))
+	}
+	<-c.Done()
+	if !connected {
+		t.Error("OnConnect not called")
+	}
+	if strings.Join(got, ",") != "one,two" {
+		t.Errorf("OnMessage got %q, want one,two", got)
+	}
+	if strings.Join(pinged, ",") != "p1" {
+		t.Errorf("OnPing got %q, want p1", pinged)
+	}
+	if code != 4000 || reason != "bye" {
+		t.Errorf("OnClose got %d %q, want 4000 bye", code, reason)
+	}
+	if hookErr != nil {
+		t.Errorf("OnError called with %v after a close frame", hookErr)
+	}
+	select {
+	case p := <-pongs:
+		if p != "p1" {
+			t.Errorf("pong = %q, want p1", p)
+		}
+	case <-time.After(time.Second):
+		t.Error("the server's ping was not answered")
+	}
+}
+
+func TestOnErrorWhenDropped(t *testing.T) {
+	url := newServer(t, func(_ *http.Request, conn *websocket.Conn) {
+		_ = conn.NetConn().Close() // no close frame
+	})
+	var closed bool
+	var hookErr error
+	c := connect(t, Options{
+		URL:     url,
+		OnClose: func(int, string) { closed = true },
+		OnError: func(err error) { hookErr = err },
+	})
+	ended(t, c)
+	<-c.Done()
+	if closed {
+		t.Error("OnClose called for a dropped connection")
+	}
+	if hookErr == nil || hookErr != c.Err() {
+		t.Errorf("OnError got %v, want Err (%v)", hookErr, c.Err())
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestCloseDuringSend closes while other goroutines keep sending: every
+// Send must either succeed or fail cleanly, and the session must end.
+func TestCloseDuringSend(t *testing.T) {
+	c := connect(t, Options{URL: newServer(t, echo)})
+	go func() {
+		for range c.Receive() { // keep the echoes flowing
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if err := c.Send(context.Background(), []byte("x")); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	_ = c.Close(websocket.CloseNormalClosure, "")
+	select {
+	case <-c.Done():
+	case <-time.After(closeGrace + time.Second):
+		t.Fatal("session did not end after Close")
+	}
+	wg.Wait()
+	if err := c.Send(context.Background(), []byte("late")); err == nil {
+		t.Error("Send after the session ended succeeded")
+	}
+}
+
+// TestReadTimeoutDuringClose lets ReadTimeout fire while a Close is still
+// waiting for the server's answer: the timeout must not start a second
+// close, and Err reports it because it ended the session's reading.
+func TestReadTimeoutDuringClose(t *testing.T) {
+	url := newServer(t, func(_ *http.Request, conn *websocket.Conn) {
+		time.Sleep(150 * time.Millisecond) // answer the close late
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	c := connect(t, Options{URL: url, ReadTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	if err := c.Close(websocket.CloseNormalClosure, "bye"); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if msgs := ended(t, c); len(msgs) != 0 {
+		t.Errorf("got %d messages while closing", len(msgs))
+	}
+	if elapsed := time.Since(start); elapsed >= closeGrace {
+		t.Errorf("session took %v to end, want the server's answer before closeGrace", elapsed)
+	}
+	if !errors.Is(c.Err(), ErrReadTimeout) {
+		t.Errorf("Err = %v, want ErrReadTimeout", c.Err())
+	}
+}
+
+// TestDropDuringReceive tears the connection down while a message loop is
+// blocked on Receive.
+func TestDropDuringReceive(t *testing.T) {
+	c := connect(t, Options{URL: newServer(t, echo)})
+	received := make(chan []Message)
+	go func() {
+		var msgs []Message
+		for msg := range c.Receive() {
+			msgs = append(msgs, msg)
+		}
+		received <- msgs
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Drop()
+	select {
+	case msgs := <-received:
+		if len(msgs) != 0 {
+			t.Errorf("got %d messages from a silent server", len(msgs))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive was not closed after Drop")
+	}
+	if c.Err() == nil {
+		t.Error("Err = nil after Drop")
+	}
+}
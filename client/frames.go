@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bytes"
+	"net"
+)
+
+// frameLog follows the frame headers of the server's messages as they are
+// read off the connection, above TLS, to tell which messages arrived
+// compressed: gorilla decompresses transparently and does not expose the
+// RSV1 bit. It is only touched by the goroutine reading the connection.
+type frameLog struct {
+	handshake bool   // still inside the HTTP response
+	tail      []byte // last bytes of the response seen, to find its end across reads
+	hdr       []byte // frame header bytes collected so far
+	skip      int64  // payload bytes of the current frame still to pass
+
+	queue []*frameInfo // messages started but not consumed yet
+	open  *frameInfo   // message waiting for continuation frames
+
+	compressed, messages int
+	wire                 int64 // payload bytes of compressed messages on the wire
+}
+
+type frameInfo struct {
+	compressed bool
+	wire       int64
+}
+
+// sniffConn feeds everything read through it into a frameLog.
+type sniffConn struct {
+	net.Conn
+	log *frameLog
+}
+
+func (s *sniffConn) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	s.log.feed(p[:n])
+	return n, err
+}
+
+func (l *frameLog) feed(b []byte) {
+	for len(b) > 0 {
+		switch {
+		case l.handshake:
+			buf := append(l.tail, b...)
+			i := bytes.Index(buf, []byte("\r\n\r\n"))
+			if i < 0 {
+				l.tail = append(l.tail[:0], buf[max(0, len(buf)-3):]...)
+				return
+			}
+			b = buf[i+4:]
+			l.handshake, l.tail = false, nil
+		case l.skip > 0:
+			n := int(min(l.skip, int64(len(b))))
+			l.skip -= int64(n)
+			b = b[n:]
+		default:
+			l.hdr = append(l.hdr, b[0])
+			b = b[1:]
+			if len(l.hdr) < 2 {
+				continue
+			}
+			need := 2
+			switch l.hdr[1] & 0x7f {
+			case 126:
+				need += 2
+			case 127:
+				need += 8
+			}
+			if l.hdr[1]&0x80 != 0 {
+				need += 4 // masked; servers should not, but tolerate it
+			}
+			if len(l.hdr) == need {
+				l.frame()
+				l.hdr = l.hdr[:0]
+			}
+		}
+	}
+}
+
+// frame handles a complete frame header in l.hdr.
+func (l *frameLog) frame() {
+	var size int64
+	switch n := l.hdr[1] & 0x7f; n {
+	case 126:
+		size = int64(l.hdr[2])<<8 | int64(l.hdr[3])
+	case 127:
+		for _, b := range l.hdr[2:10] {
+			size = size<<8 | int64(b)
+		}
+	default:
+		size = int64(n)
+	}
+	l.skip = size
+	fin := l.hdr[0]&0x80 != 0
+	switch opcode := l.hdr[0] & 0x0f; {
+	case opcode >= 8: // control frame
+		return
+	case opcode == 0: // continuation
+		if l.open != nil {
+			l.open.wire += size
+		}
+	default:
+		l.open = &frameInfo{compressed: l.hdr[0]&0x40 != 0, wire: size}
+		l.queue = append(l.queue, l.open)
+	}
+	if fin {
+		l.open = nil
+	}
+}
+
+// next returns what the frames of the next message looked like, once the
+// message has been read completely.
+func (l *frameLog) next() frameInfo {
+	if len(l.queue) == 0 {
+		return frameInfo{}
+	}
+	f := *l.queue[0]
+	l.queue = l.queue[1:]
+	l.messages++
+	if f.compressed {
+		l.compressed++
+		l.wire += f.wire
+	}
+	return f
+}
+
+// CompressionStats reports how many messages were received so far and how
+// many of them arrived compressed, with the compressed payload bytes those
+// took on the wire. ok is false unless the client was connected with
+// Options.TrackCompression. It must not be called while the read loop is
+// running, i.e. before Done is closed.
+func (c *Client) CompressionStats() (messages, compressed int, wire int64, ok bool) {
+	if c.frames == nil {
+		return 0, 0, 0, false
+	}
+	return c.frames.messages, c.frames.compressed, c.frames.wire, true
+}
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // required by RFC 6455 for Sec-WebSocket-Accept
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// headerEnd terminates the HTTP header block of the upgrade request and
+// response.
+var headerEnd = []byte("\r\n\r\n")
+
+// handshakeConn lets the client adjust the raw upgrade request and response
+// for behaviour gorilla does not expose, such as a fixed Sec-WebSocket-Key.
+// Everything after the header blocks passes through untouched.
+type handshakeConn struct {
+	net.Conn
+	rewriteRequest  func(head []byte) []byte
+	rewriteResponse func(head []byte) []byte
+
+	wbuf  []byte
+	wdone bool
+
+	rbuf    []byte
+	pending []byte
+	rdone   bool
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	if c.wdone {
+		return c.Conn.Write(p)
+	}
+	c.wbuf = append(c.wbuf, p...)
+	i := bytes.Index(c.wbuf, headerEnd)
+	if i < 0 {
+		return len(p), nil
+	}
+	end := i + len(headerEnd)
+	out := c.rewriteRequest(c.wbuf[:end])
+	out = append(out, c.wbuf[end:]...)
+	c.wdone, c.wbuf = true, nil
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.rdone {
+		return c.Conn.Read(p)
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Conn.Read(buf)
+		c.rbuf = append(c.rbuf, buf[:n]...)
+		if i := bytes.Index(c.rbuf, headerEnd); i >= 0 {
+			end := i + len(headerEnd)
+			c.pending = append(c.rewriteResponse(c.rbuf[:end]), c.rbuf[end:]...)
+			c.rdone, c.rbuf = true, nil
+			return c.Read(p)
+		}
+		if err != nil {
+			// Hand back what arrived so gorilla reports the malformed response.
+			c.pending, c.rdone, c.rbuf = c.rbuf, true, nil
+			if len(c.pending) > 0 {
+				return c.Read(p)
+			}
+			return 0, err
+		}
+	}
+}
+
+// replaceHeader rewrites the value of header name (case-insensitive) in an
+// HTTP header block and returns the new block and the previous value.
+func replaceHeader(head []byte, name, value string) ([]byte, string) {
+	lines := bytes.Split(head, []byte("\r\n"))
+	var old string
+	for i, line := range lines {
+		k, v, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !bytes.EqualFold(bytes.TrimSpace(k), []byte(name)) {
+			continue
+		}
+		old = string(bytes.TrimSpace(v))
+		lines[i] = append(append([]byte{}, k...), []byte(": "+value)...)
+	}
+	return bytes.Join(lines, []byte("\r\n")), old
+}
+
+// addHeader appends a header line to an HTTP header block.
+func addHeader(head []byte, name, value string) []byte {
+	body := bytes.TrimSuffix(head, headerEnd)
+	out := append([]byte{}, body...)
+	out = append(out, "\r\n"+name+": "+value...)
+	return append(out, headerEnd...)
+}
+
+// HandshakeQuirks are non-standard handshake behaviours some legacy servers
+// expect.
+type HandshakeQuirks struct {
+	// OmitVersion leaves Sec-WebSocket-Version out of the request, for
+	// servers that reject the header gorilla always sends.
+	OmitVersion bool
+	// OriginHeader adds the Sec-WebSocket-Origin header of the hybi-08 to
+	// hybi-10 drafts: the Origin header if one was given, else the URL's
+	// http(s) origin.
+	OriginHeader bool
+	// LenientConnection accepts a 101 response whose Connection header is
+	// missing or lacks the upgrade token, which gorilla otherwise rejects.
+	LenientConnection bool
+}
+
+// removeHeader drops every line of header name (case-insensitive) from an
+// HTTP header block.
+func removeHeader(head []byte, name string) []byte {
+	lines := bytes.Split(head, []byte("\r\n"))
+	out := lines[:0]
+	for _, line := range lines {
+		if k, _, ok := bytes.Cut(line, []byte(":")); ok && bytes.EqualFold(bytes.TrimSpace(k), []byte(name)) {
+			continue
+		}
+		out = append(out, line)
+	}
+	return bytes.Join(out, []byte("\r\n"))
+}
+
+// headerValue returns the value of header name in an HTTP header block.
+func headerValue(head []byte, name string) (string, bool) {
+	for _, line := range bytes.Split(head, []byte("\r\n")) {
+		if k, v, ok := bytes.Cut(line, []byte(":")); ok && bytes.EqualFold(bytes.TrimSpace(k), []byte(name)) {
+			return string(bytes.TrimSpace(v)), true
+		}
+	}
+	return "", false
+}
+
+// origin is the value of the OriginHeader quirk.
+func (opts Options) origin() string {
+	if o := opts.Header.Get("Origin"); o != "" {
+		return o
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return ""
+	}
+	scheme := "http"
+	if u.Scheme == "wss" {
+		scheme = "https"
+	}
+	return scheme + "://" + u.Host
+}
+
+// rewriters combines the raw handshake changes opts asks for; ok is false
+// when the handshake can go out as gorilla writes it.
+func (opts Options) rewriters() (req, resp func([]byte) []byte, ok bool) {
+	req = func(head []byte) []byte { return head }
+	resp = req
+	if opts.ChallengeKey != "" {
+		req, resp = fixedKeyRewriters(opts.ChallengeKey)
+		ok = true
+	}
+	if len(opts.Extensions) > 0 {
+		inner, value := req, strings.Join(opts.Extensions, ", ")
+		req = func(head []byte) []byte {
+			return addHeader(inner(head), "Sec-WebSocket-Extensions", value)
+		}
+		ok = true
+	}
+	if opts.Quirks.OmitVersion {
+		inner := req
+		req = func(head []byte) []byte {
+			return removeHeader(inner(head), "Sec-WebSocket-Version")
+		}
+		ok = true
+	}
+	if opts.Quirks.OriginHeader {
+		inner, value := req, opts.origin()
+		req = func(head []byte) []byte {
+			return addHeader(inner(head), "Sec-WebSocket-Origin", value)
+		}
+		ok = true
+	}
+	if opts.Quirks.LenientConnection {
+		inner := resp
+		resp = func(head []byte) []byte {
+			head = inner(head)
+			if !bytes.HasPrefix(head, []byte("HTTP/1.1 101")) {
+				return head
+			}
+			if v, _ := headerValue(head, "Connection"); !containsToken(v, "upgrade") {
+				head = addHeader(removeHeader(head, "Connection"), "Connection", "Upgrade")
+			}
+			return head
+		}
+		ok = true
+	}
+	return req, resp, ok
+}
+
+// containsToken reports whether the comma-separated list v holds token,
+// ignoring case.
+func containsToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a challenge key.
+func acceptKey(key string) string {
+	h := sha1.New() //nolint:gosec // required by RFC 6455
+	h.Write([]byte(key))
+	h.Write([]byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// fixedKeyRewriters replaces gorilla's random challenge key with key on the
+// way out and maps the server's accept value back on the way in, so gorilla's
+// own accept check still passes.
+func fixedKeyRewriters(key string) (req, resp func([]byte) []byte) {
+	var generated string
+	req = func(head []byte) []byte {
+		out, old := replaceHeader(head, "Sec-WebSocket-Key", key)
+		generated = old
+		return out
+	}
+	resp = func(head []byte) []byte {
+		if generated == "" {
+			return head
+		}
+		_, got := replaceHeader(head, "Sec-WebSocket-Accept", "")
+		if got != acceptKey(key) {
+			// Leave a wrong accept value alone so the handshake fails.
+			return head
+		}
+		out, _ := replaceHeader(head, "Sec-WebSocket-Accept", acceptKey(generated))
+		return out
+	}
+	return req, resp
+}
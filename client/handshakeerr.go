@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody bounds the decoded handshake response body kept in a
+// HandshakeError.
+const maxErrorBody = 64 << 10
+
+// HandshakeError is returned by Connect when the server answered the
+// upgrade request with something other than 101 Switching Protocols.
+type HandshakeError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body is the start of the response body (gorilla keeps the first
+	// 1 KiB), decoded according to Content-Encoding.
+	Body []byte
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%v (%s)", e.Err, e.Status)
+}
+
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+func newHandshakeError(resp *http.Response, err error) *HandshakeError {
+	he := &HandshakeError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Err: err}
+	if resp.Body != nil {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		_ = resp.Body.Close()
+		he.Body = decodeBody(raw, resp.Header.Get("Content-Encoding"))
+	}
+	return he
+}
+
+// decodeBody undoes a gzip or deflate Content-Encoding. The body may be
+// cut short, so whatever decodes before the end is kept; if nothing does,
+// the raw bytes are returned.
+func decodeBody(raw []byte, encoding string) []byte {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		// Properly zlib-wrapped, but raw deflate is common too.
+		if r, err = zlib.NewReader(bytes.NewReader(raw)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(raw)), nil
+		}
+	default:
+		return raw
+	}
+	if err != nil {
+		return raw
+	}
+	out, _ := io.ReadAll(io.LimitReader(r, maxErrorBody))
+	if len(out) == 0 {
+		return raw
+	}
+	return out
+}
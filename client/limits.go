@@ -0,0 +1,64 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Errors reported by Err when the session was ended by one of the
+// termination options rather than by the server.
+var (
+	// ErrIdleTimeout: no message arrived within Options.IdleTimeout.
+	ErrIdleTimeout = errors.New("no message within the idle timeout")
+	// ErrReadTimeout: Options.ReadTimeout elapsed since the handshake.
+	ErrReadTimeout = errors.New("read timeout reached")
+	// ErrMaxMessages: Options.MaxMessages messages were delivered.
+	ErrMaxMessages = errors.New("message limit reached")
+)
+
+// startLimits arms the ReadTimeout and IdleTimeout timers of a new session.
+func (c *Client) startLimits() {
+	if d := c.hooks.ReadTimeout; d > 0 {
+		c.readTimer = time.AfterFunc(d, func() { c.stop(ErrReadTimeout, "read timeout") })
+	}
+	if d := c.hooks.IdleTimeout; d > 0 {
+		c.idleTimer = time.AfterFunc(d, func() { c.stop(ErrIdleTimeout, "idle timeout") })
+	}
+}
+
+// stopLimits disarms the timers once the read loop has ended.
+func (c *Client) stopLimits() {
+	if c.readTimer != nil {
+		c.readTimer.Stop()
+	}
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+// activity extends IdleTimeout after a message has been read.
+func (c *Client) activity() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.hooks.IdleTimeout)
+	}
+}
+
+// stop ends the session for reason with a graceful close, once; later
+// messages are discarded.
+func (c *Client) stop(reason error, closeReason string) {
+	c.stopOnce.Do(func() {
+		c.stopMu.Lock()
+		c.stopErr = reason
+		c.stopMu.Unlock()
+		_ = c.Close(websocket.CloseNormalClosure, closeReason)
+	})
+}
+
+// stopped returns the termination option that ended the session, if any.
+func (c *Client) stopped() error {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	return c.stopErr
+}
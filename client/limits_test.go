@@ -0,0 +1,116 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamServer sends n messages (n < 0 keeps sending) every interval, then
+// waits for the client's close frame and reports it on closed.
+func streamServer(t *testing.T, n int, interval time.Duration, closed chan<- *websocket.CloseError) string {
+	t.Helper()
+	return newServer(t, func(_ *http.Request, conn *websocket.Conn) {
+		go func() {
+			for i := 0; n < 0 || i < n; i++ {
+				if err := conn.WriteMessage(websocket.TextMessage, fmt.Appendf(nil, "%d", i)); err != nil {
+					return
+				}
+				time.Sleep(interval)
+			}
+		}()
+		_, _, err := conn.ReadMessage()
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			closed <- ce
+		}
+	})
+}
+
+// checkClose checks the graceful close a termination option sends.
+func checkClose(t *testing.T, closed <-chan *websocket.CloseError, reason string) {
+	t.Helper()
+	select {
+	case ce := <-closed:
+		if ce.Code != websocket.CloseNormalClosure || ce.Text != reason {
+			t.Errorf("server saw close %d %q, want 1000 %q", ce.Code, ce.Text, reason)
+		}
+	case <-time.After(time.Second):
+		t.Error("server saw no close frame")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	closed := make(chan *websocket.CloseError, 1)
+	// Messages 60ms apart keep extending the 100ms idle timeout.
+	c := connect(t, Options{URL: streamServer(t, 3, 60*time.Millisecond, closed), IdleTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	msgs := ended(t, c)
+	if len(msgs) != 3 {
+		t.Errorf("got %d messages, want 3", len(msgs))
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("session ended after %v, want the messages to extend the idle timeout", elapsed)
+	}
+	if !errors.Is(c.Err(), ErrIdleTimeout) {
+		t.Errorf("Err = %v, want ErrIdleTimeout", c.Err())
+	}
+	checkClose(t, closed, "idle timeout")
+}
+
+func TestReadTimeout(t *testing.T) {
+	closed := make(chan *websocket.CloseError, 1)
+	c := connect(t, Options{URL: streamServer(t, -1, 5*time.Millisecond, closed), ReadTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	msgs := ended(t, c)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("session ended after %v, want about 100ms however busy", elapsed)
+	}
+	if len(msgs) == 0 {
+		t.Error("got no messages before the read timeout")
+	}
+	if !errors.Is(c.Err(), ErrReadTimeout) {
+		t.Errorf("Err = %v, want ErrReadTimeout", c.Err())
+	}
+	checkClose(t, closed, "read timeout")
+}
+
+func TestMaxMessages(t *testing.T) {
+	closed := make(chan *websocket.CloseError, 1)
+	c := connect(t, Options{URL: streamServer(t, 10, 0, closed), MaxMessages: 3})
+	msgs := ended(t, c)
+	var got []string
+	for _, m := range msgs {
+		got = append(got, string(m.Data))
+	}
+	if fmt.Sprint(got) != "[0 1 2]" {
+		t.Errorf("delivered %q, want the first 3 and nothing read while closing", got)
+	}
+	if !errors.Is(c.Err(), ErrMaxMessages) {
+		t.Errorf("Err = %v, want ErrMaxMessages", c.Err())
+	}
+	checkClose(t, closed, "message limit reached")
+}
+
+func TestMaxMessagesOnMessage(t *testing.T) {
+	closed := make(chan *websocket.CloseError, 1)
+	var got int
+	c := connect(t, Options{
+		URL:         streamServer(t, 10, 0, closed),
+		MaxMessages: 3,
+		OnMessage:   func(int, []byte) { got++ },
+	})
+	ended(t, c)
+	<-c.Done()
+	if got != 3 {
+		t.Errorf("OnMessage called %d times, want 3", got)
+	}
+	if !errors.Is(c.Err(), ErrMaxMessages) {
+		t.Errorf("Err = %v, want ErrMaxMessages", c.Err())
+	}
+	checkClose(t, closed, "message limit reached")
+}
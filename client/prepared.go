@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+
+	"github.com/gorilla/websocket"
+)
+
+// PreparedMessage is a message whose frame is built (and, with compression,
+// deflated) once, so sending the same bytes many times costs neither the
+// allocations nor the CPU again. It can be shared between clients.
+type PreparedMessage struct {
+	pm   *websocket.PreparedMessage
+	size int
+}
+
+// Prepare builds a PreparedMessage of the given type. data is copied.
+func Prepare(msgType int, data []byte) (*PreparedMessage, error) {
+	pm, err := websocket.NewPreparedMessage(msgType, data)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedMessage{pm: pm, size: len(data)}, nil
+}
+
+// Len is the payload size in bytes.
+func (p *PreparedMessage) Len() int {
+	return p.size
+}
+
+// SendPrepared writes a prepared message like SendMessage. Client frames
+// built this way reuse one masking key for every send.
+func (c *Client) SendPrepared(ctx context.Context, p *PreparedMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	return c.conn.WritePreparedMessage(p.pm)
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// discard reads and drops every message until the client goes away.
+func discard(_ *http.Request, conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// BenchmarkSend compares writing the same 1 KiB payload with SendMessage,
+// which frames (and deflates) it every time, and with SendPrepared.
+func BenchmarkSend(b *testing.B) {
+	payload := bytes.Repeat([]byte(`{"type":"tick","value":12345},`), 35)[:1024]
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "deflate"
+		}
+		b.Run(name+"/SendMessage", func(b *testing.B) {
+			c := connect(b, Options{URL: newServer(b, discard), Compression: compress})
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for b.Loop() {
+				if err := c.SendMessage(ctx, websocket.TextMessage, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/SendPrepared", func(b *testing.B) {
+			c := connect(b, Options{URL: newServer(b, discard), Compression: compress})
+			pm, err := Prepare(websocket.TextMessage, payload)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			for b.Loop() {
+				if err := c.SendPrepared(ctx, pm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSendPrepared(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		c := connect(t, Options{URL: newServer(t, echo), Compression: compress})
+		pm, err := Prepare(websocket.BinaryMessage, []byte("same"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pm.Len() != 4 {
+			t.Errorf("Len = %d, want 4", pm.Len())
+		}
+		for range 2 {
+			if err := c.SendPrepared(context.Background(), pm); err != nil {
+				t.Fatalf("SendPrepared: %v", err)
+			}
+			if msg := next(t, c); msg.Type != websocket.BinaryMessage || string(msg.Data) != "same" {
+				t.Errorf("compress=%v: echo = %d %q, want binary same", compress, msg.Type, msg.Data)
+			}
+		}
+	}
+}
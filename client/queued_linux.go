@@ -0,0 +1,41 @@
+//go:build linux
+
+package client
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// queuedBytes reports the bytes received by the kernel for conn but not
+// read yet.
+func queuedBytes(conn net.Conn) (int, bool) {
+	return ioctlInt(conn, syscall.TIOCINQ)
+}
+
+// unackedBytes reports the bytes written to conn that the peer has not
+// acknowledged yet, whether sent or still queued.
+func unackedBytes(conn net.Conn) (int, bool) {
+	return ioctlInt(conn, syscall.TIOCOUTQ)
+}
+
+// ioctlInt runs an ioctl that reports an int on the socket behind conn.
+func ioctlInt(conn net.Conn, req uintptr) (int, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var n int32
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(&n)))
+	}); err != nil || errno != 0 {
+		return 0, false
+	}
+	return int(n), true
+}
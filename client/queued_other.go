@@ -0,0 +1,15 @@
+//go:build !linux
+
+package client
+
+import "net"
+
+// queuedBytes is not implemented on this platform.
+func queuedBytes(net.Conn) (int, bool) {
+	return 0, false
+}
+
+// unackedBytes is not implemented on this platform.
+func unackedBytes(net.Conn) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeDNS is a UDP name server answering every A query with addr and every
+// other query with no records. It records the names asked for.
+type fakeDNS struct {
+	addr net.IP
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func newFakeDNS(t *testing.T, addr net.IP) (*fakeDNS, string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	d := &fakeDNS{addr: addr.To4()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := d.answer(buf[:n]); resp != nil {
+				_, _ = pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	return d, pc.LocalAddr().String()
+}
+
+// answer builds the response to query, or nil if it cannot be parsed.
+func (d *fakeDNS) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// The question follows the header: labels up to a zero byte, then
+	// type and class.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	end := i + 5
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	d.mu.Lock()
+	d.queries = append(d.queries, strings.Join(labels, "."))
+	d.mu.Unlock()
+
+	resp := append([]byte(nil), query[:end]...)
+	binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(resp[6:], 0)      // answers
+	binary.BigEndian.PutUint32(resp[8:], 0)      // authority and additional records
+	if qtype == 1 {                              // A
+		binary.BigEndian.PutUint16(resp[6:], 1)
+		resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		resp = append(resp, d.addr...)
+	}
+	return resp
+}
+
+func (d *fakeDNS) asked() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+// TestDNSServer resolves a name only the fake name server knows, so the
+// handshake can only reach the test server through the address it
+// returned.
+func TestDNSServer(t *testing.T) {
+	hosts := make(chan string, 1)
+	url := newServer(t, func(r *http.Request, conn *websocket.Conn) {
+		hosts <- r.Host
+		echo(r, conn)
+	})
+	_, port, _ := strings.Cut(strings.TrimPrefix(url, "ws://"), ":")
+	dns, server := newFakeDNS(t, net.IPv4(127, 0, 0, 1))
+
+	c := connect(t, Options{URL: "ws://postws.invalid:" + port + "/", DNSServer: server})
+	if err := c.Send(context.Background(), []byte("x")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if msg := next(t, c); string(msg.Data) != "x" {
+		t.Errorf("echo = %q, want x", msg.Data)
+	}
+	if host := <-hosts; host != "postws.invalid:"+port {
+		t.Errorf("Host = %q, want the unresolved name", host)
+	}
+	var found bool
+	for _, q := range dns.asked() {
+		found = found || q == "postws.invalid"
+	}
+	if !found {
+		t.Errorf("fake name server was asked %q, want postws.invalid", dns.asked())
+	}
+}
+
+// TestDNSServerUnreachable checks that the system resolver is not used as
+// a fallback when the configured server does not answer.
+func TestDNSServerUnreachable(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close() // bound but silent
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := Connect(ctx, Options{URL: "ws://postws.invalid:1/", DNSServer: pc.LocalAddr().String()}); err == nil {
+		t.Fatal("Connect succeeded without an answer from -dns-server")
+	} else if !strings.Contains(err.Error(), "lookup postws.invalid") {
+		t.Errorf("err = %v, want a lookup failure", err)
+	}
+}
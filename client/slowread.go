@@ -0,0 +1,96 @@
+package client
+
+import (
+	"crypto/tls"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReadBacklog describes how far a slow reader fell behind the server.
+type ReadBacklog struct {
+	Reads   int           // messages read
+	Delayed time.Duration // time slept by ReadDelay
+
+	// Measured is false where the platform cannot report the receive
+	// queue; the fields below are then zero.
+	Measured  bool
+	Waiting   int // reads that found bytes already queued in the kernel
+	MaxQueued int // most bytes found queued before a read
+}
+
+// slowReader implements Options.ReadDelay and samples the kernel receive
+// queue before every read while the connection is being drained slowly.
+type slowReader struct {
+	delay, delayMax time.Duration
+	raw             net.Conn // the network connection, below TLS and wrappers
+
+	mu      sync.Mutex
+	backlog ReadBacklog
+}
+
+// wait sleeps the read delay, cut short when the connection is torn down
+// so the read that follows fails at once, and records the queue. A nil
+// *slowReader does not wait.
+func (s *slowReader) wait(forced <-chan struct{}) {
+	if s == nil {
+		return
+	}
+	d := s.delay
+	if s.delayMax > s.delay {
+		d += rand.N(s.delayMax - s.delay + 1)
+	}
+	if d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-forced:
+			t.Stop()
+		}
+	}
+	queued, ok := queuedBytes(s.raw)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backlog.Reads++
+	s.backlog.Delayed += d
+	if ok {
+		s.backlog.Measured = true
+		if queued > 0 {
+			s.backlog.Waiting++
+		}
+		s.backlog.MaxQueued = max(s.backlog.MaxQueued, queued)
+	}
+}
+
+// Backlog reports how far the read loop fell behind, or false unless
+// Options.ReadDelay or Options.RecvLimit was set. The last read counted is
+// the one that found the connection closed.
+func (c *Client) Backlog() (ReadBacklog, bool) {
+	if c.slow == nil {
+		return ReadBacklog{}, false
+	}
+	c.slow.mu.Lock()
+	defer c.slow.mu.Unlock()
+	return c.slow.backlog, true
+}
+
+// rawConn unwraps conn down to the network connection.
+func rawConn(conn net.Conn) net.Conn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *handshakeConn:
+			conn = c.Conn
+		case *countingConn:
+			conn = c.Conn
+		case *throttledConn:
+			conn = c.Conn
+		case *sniffConn:
+			conn = c.Conn
+		default:
+			return conn
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimiter caps outgoing (or, as Options.RecvLimit, incoming) bytes with
+// a token bucket. Writes are cut into chunks of one bucket's worth, so a
+// large frame leaves at a steady pace instead of in one burst. Sharing a
+// limiter between clients caps their combined rate, like one slow uplink.
+type RateLimiter struct {
+	rate  float64 // bytes per second
+	burst int     // bucket size, and the largest chunk written at once
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time // when tokens was last refilled
+	written int64
+	busy    time.Duration // time with at least one write in progress
+	end     time.Time     // end of the latest write
+}
+
+// NewRateLimiter allows bytesPerSecond on average, with bursts of 1/20 s.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	burst := max(int(bytesPerSecond/20), 256)
+	return &RateLimiter{rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst)}
+}
+
+// reserve takes n bytes from the bucket and returns how long the caller
+// must wait before writing them. The bucket may go into debt, which makes
+// concurrent writers queue up behind each other.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// passed records n bytes moved by a Write (or Read) call that began at
+// start. Overlapping calls count their shared time once.
+func (l *RateLimiter) passed(n int, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if start.Before(l.end) {
+		start = l.end
+	}
+	if now.After(start) {
+		l.busy += now.Sub(start)
+	}
+	if now.After(l.end) {
+		l.end = now
+	}
+	l.written += int64(n)
+}
+
+// Sent returns the bytes written through the limiter and the time spent
+// writing them, throttle waits included but idle periods left out. For a
+// RecvLimit it is the bytes read and the time reads were held back.
+func (l *RateLimiter) Sent() (int64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.written, l.busy
+}
+
+// throttledConn paces Write through a RateLimiter, and Read through
+// another; either may be nil. Time spent waiting for the bucket is added to
+// the write deadline, so a deliberately slow send does not time out; only
+// the network itself can exceed the deadline.
+type throttledConn struct {
+	net.Conn
+	limit     *RateLimiter
+	readLimit *RateLimiter
+
+	deadline time.Time     // as last set by the caller
+	waited   time.Duration // throttle wait since then
+}
+
+func (t *throttledConn) SetDeadline(d time.Time) error {
+	t.deadline, t.waited = d, 0
+	return t.Conn.SetDeadline(d)
+}
+
+func (t *throttledConn) SetWriteDeadline(d time.Time) error {
+	t.deadline, t.waited = d, 0
+	return t.Conn.SetWriteDeadline(d)
+}
+
+func (t *throttledConn) Write(p []byte) (int, error) {
+	if t.limit == nil {
+		return t.Conn.Write(p)
+	}
+	start := time.Now()
+	written := 0
+	defer func() { t.limit.passed(written, start) }()
+	for len(p) > 0 {
+		chunk := p[:min(len(p), t.limit.burst)]
+		if wait := t.limit.reserve(len(chunk)); wait > 0 {
+			time.Sleep(wait)
+			if !t.deadline.IsZero() {
+				t.waited += wait
+				if err := t.Conn.SetWriteDeadline(t.deadline.Add(t.waited)); err != nil {
+					return written, err
+				}
+			}
+		}
+		n, err := t.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Read takes at most one bucket's worth and, when the bucket is empty,
+// sleeps before returning it, so the next read (and the kernel's window)
+// waits. The read loop sets no read deadlines, so none are extended.
+func (t *throttledConn) Read(p []byte) (int, error) {
+	if t.readLimit == nil {
+		return t.Conn.Read(p)
+	}
+	n, err := t.Conn.Read(p[:min(len(p), t.readLimit.burst)])
+	start := time.Now() // waiting for data to arrive is not throttling
+	if wait := t.readLimit.reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+	t.readLimit.passed(n, start)
+	return n, err
+}
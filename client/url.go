@@ -0,0 +1,35 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BuildURL joins a ws:// or wss:// base URL with path, optionally replacing
+// the port.
+func BuildURL(rawURL, path string, port int) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+	if u.Scheme == "" {
+		return "", fmt.Errorf("url must include scheme, e.g. ws://host or wss://host")
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return "", fmt.Errorf("unsupported scheme %q (use ws:// or wss://)", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("url must include host")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u.Path = path
+	if port > 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	}
+	return u.String(), nil
+}
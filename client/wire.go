@@ -0,0 +1,47 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// wireCounter counts the bytes written to and read from a connection.
+type wireCounter struct {
+	written atomic.Int64
+	read    atomic.Int64
+}
+
+// countingConn feeds everything that crosses it into a wireCounter.
+type countingConn struct {
+	net.Conn
+	wire *wireCounter
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.wire.written.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.wire.read.Add(int64(n))
+	return n, err
+}
+
+// WireBytes reports how many bytes crossed the network connection in each
+// direction so far, handshake and framing included. Both are 0 unless the
+// client was connected with Options.CountWire.
+func (c *Client) WireBytes() (written, read int64) {
+	if c.wire == nil {
+		return 0, 0
+	}
+	return c.wire.written.Load(), c.wire.read.Load()
+}
+
+// Unacked reports how many bytes written to the connection the server's
+// TCP stack has not acknowledged yet, or false where the platform cannot
+// tell (only Linux can).
+func (c *Client) Unacked() (int, bool) {
+	return unackedBytes(rawConn(c.conn.NetConn()))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configOnlyFlags are flags that select the configuration itself and so
+// cannot appear inside a profile.
+var configOnlyFlags = map[string]bool{
+	"config":        true,
+	"profile":       true,
+	"list-profiles": true,
+}
+
+// config is a parsed profile file. The format is a small YAML subset:
+//
+//	staging:
+//	  url: wss://staging.example.com
+//	  path: /ws
+//	  H:
+//	    - "Authorization: Bearer env:STAGING_TOKEN"
+//
+// Keys are flag names; values may use env:NAME or file:PATH indirection.
+type config struct {
+	path     string
+	profiles []*profile
+}
+
+type profile struct {
+	name    string
+	line    int
+	entries []configEntry
+}
+
+type configEntry struct {
+	key    string
+	values []string
+	line   int
+}
+
+// defaultConfigPath returns ~/.config/postws/config.yaml (or the
+// platform equivalent), or "" when the user config dir is unknown.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "postws", "config.yaml")
+}
+
+func loadConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseConfig(path, f)
+}
+
+func parseConfig(path string, r io.Reader) (*config, error) {
+	cfg := &config{path: path}
+	var cur *profile
+	var list *configEntry
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := stripComment(scanner.Text())
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNo, fmt.Sprintf(format, args...))
+		}
+		if strings.Contains(raw, "\t") {
+			return nil, errorf("tabs are not allowed for indentation")
+		}
+		text := strings.TrimSpace(raw)
+		indented := raw[0] == ' '
+
+		if !indented {
+			name, ok := strings.CutSuffix(text, ":")
+			if !ok || strings.TrimSpace(name) == "" || strings.Contains(name, ":") {
+				return nil, errorf("expected a profile name followed by ':'")
+			}
+			if cfg.profile(name) != nil {
+				return nil, errorf("duplicate profile %q", name)
+			}
+			cur = &profile{name: name, line: lineNo}
+			cfg.profiles = append(cfg.profiles, cur)
+			list = nil
+			continue
+		}
+		if cur == nil {
+			return nil, errorf("option outside of a profile")
+		}
+
+		if item, ok := strings.CutPrefix(text, "-"); ok {
+			if list == nil {
+				return nil, errorf("list item without a key")
+			}
+			v, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			list.values = append(list.values, v)
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errorf("expected key: value")
+		}
+		if configOnlyFlags[key] || !knownFlag(key) {
+			return nil, errorf("unknown key %q in profile %q", key, cur.name)
+		}
+		cur.entries = append(cur.entries, configEntry{key: key, line: lineNo})
+		entry := &cur.entries[len(cur.entries)-1]
+		value = strings.TrimSpace(value)
+		if value == "" {
+			list = entry
+			continue
+		}
+		list = nil
+		v, err := unquote(value)
+		if err != nil {
+			return nil, errorf("%v", err)
+		}
+		entry.values = []string{v}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *config) profile(name string) *profile {
+	for _, p := range c.profiles {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// apply sets every flag of the profile that was not given explicitly on the
+// command line, so command-line flags always win. Keys that belong to other
+// subcommands are skipped.
+func (p *profile) apply(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+	for _, e := range p.entries {
+		if explicit[e.key] || fs.Lookup(e.key) == nil {
+			continue
+		}
+		for _, v := range e.values {
+			resolved, err := resolveConfigValue(e.key, v)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s: %w", path, e.line, e.key, err)
+			}
+			if err := fs.Set(e.key, resolved); err != nil {
+				return fmt.Errorf("%s:%d: invalid value for %s: %w", path, e.line, e.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveConfigValue expands env:/file: indirection. For headers only the
+// value after "Name:" is expanded so secrets can stay out of the file.
+func resolveConfigValue(key, v string) (string, error) {
+	if key == "H" {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return v, nil
+		}
+		resolved, err := resolveSecret(strings.TrimSpace(value))
+		if err != nil {
+			return "", err
+		}
+		return name + ": " + resolved, nil
+	}
+	return resolveSecret(v)
+}
+
+func resolveSecret(v string) (string, error) {
+	if name, ok := strings.CutPrefix(v, "env:"); ok {
+		val, set := os.LookupEnv(name)
+		if !set {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val, nil
+	}
+	if path, ok := strings.CutPrefix(v, "file:"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return v, nil
+}
+
+// stripComment removes a trailing "# comment" that is not inside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", v)
+		}
+		return s, nil
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", v)
+		}
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	}
+	return v, nil
+}
+
+// loadProfile applies the named profile from the config file (or the
+// default location) beneath the explicitly set flags.
+func loadProfile(fs *flag.FlagSet, path, name string, explicit map[string]bool) error {
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p := cfg.profile(name)
+	if p == nil {
+		return fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	return p.apply(fs, path, explicit)
+}
+
+// listProfiles prints the profiles defined in the config file.
+func listProfiles(w io.Writer, path string) error {
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no config file at %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	for _, p := range cfg.profiles {
+		keys := make([]string, 0, len(p.entries))
+		for _, e := range p.entries {
+			keys = append(keys, e.key)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", p.name, strings.Join(keys, " "))
+	}
+	return nil
+}
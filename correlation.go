@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// newCorrelationID returns a random id for -correlation-field.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// correlationMatcher reports whether a message is the response carrying id
+// in field.
+func correlationMatcher(field, id string) func(client.Message) bool {
+	return func(msg client.Message) bool {
+		var doc any
+		if err := json.Unmarshal(msg.Data, &doc); err != nil {
+			return false
+		}
+		v, ok := lookupPath(doc, field)
+		return ok && valueString(v) == id
+	}
+}
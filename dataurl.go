@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dataURLTimeout bounds the whole -data-url fetch.
+const dataURLTimeout = 30 * time.Second
+
+// fetchDataURL GETs the -data-url body before connecting. It uses the
+// default HTTP transport, so HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply as
+// usual, with certificate checks skipped under -insecure-skip-verify. The -H
+// headers are not sent: they belong to the WebSocket handshake.
+func fetchDataURL(ctx context.Context, opts options) ([]byte, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.insecureTLS {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // optional override for testing
+	}
+	hc := &http.Client{Transport: transport, Timeout: dataURLTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.dataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("-data-url: %w", err)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("-data-url: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("-data-url %s: read body: %w", opts.dataURL, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("-data-url %s: %s: %.200s", opts.dataURL, resp.Status, strings.Join(strings.Fields(string(body)), " "))
+	}
+	if opts.dataURLJSON && !json.Valid(body) {
+		ctype := resp.Header.Get("Content-Type")
+		if ctype == "" {
+			ctype = "no content type"
+		}
+		return nil, fmt.Errorf("-data-url %s: body is not valid JSON (%s)", opts.dataURL, ctype)
+	}
+	return body, nil
+}
+
+// validDataURL reports whether u is an http or https URL.
+func validDataURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeFieldFlag collects -decode-field paths: dot paths or JSON Pointers
+// as with -wait-for.
+type decodeFieldFlag []string
+
+func (f *decodeFieldFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *decodeFieldFlag) Set(v string) error {
+	if v == "" || v == "/" {
+		return fmt.Errorf("invalid -decode-field %q (want a dot path or JSON Pointer)", v)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// fieldDecoder unwraps the -decode-field values of received JSON messages:
+// each string is base64-decoded, gunzipped when it starts with the gzip
+// magic bytes, and substituted back as a document when the result is JSON.
+// Paths are applied in order, so a later one can reach into a document an
+// earlier one decoded. A value that does not decode is left as it was and
+// counted in a warning. A nil *fieldDecoder leaves messages unchanged.
+type fieldDecoder struct {
+	paths    []string
+	errw     io.Writer
+	failures int
+}
+
+func newFieldDecoder(paths decodeFieldFlag, errw io.Writer) *fieldDecoder {
+	if len(paths) == 0 {
+		return nil
+	}
+	return &fieldDecoder{paths: paths, errw: errw}
+}
+
+// apply returns data with the decoded fields substituted and the paths that
+// were decoded. Messages that are not JSON or lack every path are returned
+// unchanged.
+func (d *fieldDecoder) apply(data []byte) ([]byte, []string) {
+	if d == nil {
+		return data, nil
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil {
+		return data, nil
+	}
+	var decoded []string
+	for _, path := range d.paths {
+		v, ok := lookupPath(doc, path)
+		if !ok {
+			continue
+		}
+		value, err := decodeValue(v)
+		if err == nil {
+			tokens := strings.Split(path, ".")
+			if strings.HasPrefix(path, "/") {
+				tokens = pointerTokens(path)
+			}
+			doc, err = setTokens(doc, tokens, value, path)
+		}
+		if err != nil {
+			d.failures++
+			fmt.Fprintf(d.errw, "decode-field: %s: %v; left as is (%d failure(s) so far)\n", path, err, d.failures)
+			continue
+		}
+		decoded = append(decoded, path)
+	}
+	if len(decoded) == 0 {
+		return data, nil
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return data, nil
+	}
+	return out, decoded
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodeValue base64-decodes v (standard or URL alphabet, padded or not),
+// gunzips it if it is a gzip stream and parses the result as JSON.
+func decodeValue(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("is a %s, not a base64 string", jsonKind(v))
+	}
+	raw, err := decodeBase64(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(raw, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("gunzip: %w", err)
+		}
+		if raw, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("gunzip: %w", err)
+		}
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.New("decoded value is not JSON")
+	}
+	if dec.More() {
+		return nil, errors.New("decoded value is not a single JSON document")
+	}
+	return doc, nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(s); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("not valid base64")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// demuxNoChannel is the channel of messages that lack the -demux-field.
+const demuxNoChannel = "_none"
+
+// demuxer splits a multiplexed stream by the value of -demux-field: with a
+// directory every channel gets its own CHANNEL.jsonl file, otherwise the
+// printed messages are labelled with their channel.
+type demuxer struct {
+	field string
+	dir   string
+	files map[string]*os.File
+}
+
+func newDemuxer(field, dir string) (*demuxer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("-demux-dir: %w", err)
+		}
+	}
+	return &demuxer{field: field, dir: dir, files: map[string]*os.File{}}, nil
+}
+
+// channel is the -demux-field value of msg, or demuxNoChannel.
+func (d *demuxer) channel(msg []byte) string {
+	var doc any
+	if err := json.Unmarshal(msg, &doc); err != nil {
+		return demuxNoChannel
+	}
+	v, ok := lookupPath(doc, d.field)
+	if !ok || v == nil {
+		return demuxNoChannel
+	}
+	return valueString(v)
+}
+
+// write appends msg as one line to the file of its channel, opening the file
+// on the channel's first message. JSON is compacted; other text has its
+// newlines replaced so it stays on one line.
+func (d *demuxer) write(channel string, msg []byte) error {
+	f, ok := d.files[channel]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(filepath.Join(d.dir, channelFileName(channel)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		d.files[channel] = f
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, msg); err != nil {
+		line.Reset()
+		line.WriteString(strings.ReplaceAll(string(msg), "\n", " "))
+	}
+	line.WriteByte('\n')
+	_, err := f.Write(line.Bytes())
+	return err
+}
+
+func (d *demuxer) close() {
+	for _, f := range d.files {
+		_ = f.Close()
+	}
+}
+
+// channelFileName turns a channel value into a safe file name, replacing
+// path separators and other unusual characters.
+func channelFileName(channel string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, channel)
+	if strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name + ".jsonl"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// printErrorChain shows every layer of a dial error for -debug-errors,
+// outermost first, with the details of the net.OpError, DNS and syscall
+// errors along the way and a one-line guess at the failing step.
+func (a *app) printErrorChain(err error) {
+	fmt.Fprintf(a.stderr, "dial error chain (%s):\n", dialFailureKind(err))
+	for i, e := 1, err; e != nil; i, e = i+1, errors.Unwrap(e) {
+		fmt.Fprintf(a.stderr, "  %d. %T: %v\n", i, e, e)
+		if d := errorDetails(e); d != "" {
+			fmt.Fprintf(a.stderr, "     %s\n", d)
+		}
+	}
+}
+
+func errorDetails(err error) string {
+	var parts []string
+	add := func(k string, v any) { parts = append(parts, fmt.Sprintf("%s=%v", k, v)) }
+	switch e := err.(type) {
+	case *net.OpError:
+		add("op", e.Op)
+		add("net", e.Net)
+		if e.Source != nil {
+			add("source", e.Source)
+		}
+		if e.Addr != nil {
+			add("addr", e.Addr)
+		}
+		add("timeout", e.Timeout())
+	case *net.DNSError:
+		add("name", e.Name)
+		if e.Server != "" {
+			add("server", e.Server)
+		}
+		add("not_found", e.IsNotFound)
+		add("timeout", e.IsTimeout)
+		add("temporary", e.IsTemporary)
+	case *os.SyscallError:
+		add("syscall", e.Syscall)
+	case syscall.Errno:
+		add("errno", int(e))
+	case *net.AddrError:
+		add("addr", e.Addr)
+	case *client.HandshakeError:
+		add("status", e.StatusCode)
+	}
+	return strings.Join(parts, " ")
+}
+
+// dialFailureKind names the step a dial error most likely failed at.
+func dialFailureKind(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	var alert tls.AlertError
+	var opErr *net.OpError
+	var he *client.HandshakeError
+	switch {
+	case errors.As(err, &he):
+		return fmt.Sprintf("upgrade refused with HTTP %d", he.StatusCode)
+	case errors.As(err, &dnsErr):
+		return "DNS lookup failed"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "host or network unreachable"
+	case errors.As(err, &certErr), errors.As(err, &unknownAuth), errors.As(err, &hostErr):
+		return "TLS certificate rejected"
+	case errors.As(err, &recordErr), errors.As(err, &alert):
+		return "TLS handshake failed"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timed out"
+	case errors.As(err, &opErr):
+		return "network error during " + opErr.Op
+	}
+	return "not a network error"
+}
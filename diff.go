@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// diffIgnoreFlag collects -diff-ignore paths, repeatable or comma-separated.
+// Each is a dot path or JSON Pointer as with -wait-for, where a * segment
+// matches any key or index.
+type diffIgnoreFlag [][]string
+
+func (f *diffIgnoreFlag) String() string {
+	paths := make([]string, len(*f))
+	for i, tokens := range *f {
+		paths[i] = jsonPointer(tokens)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (f *diffIgnoreFlag) Set(v string) error {
+	for _, path := range strings.Split(v, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return fmt.Errorf("invalid -diff-ignore %q (want comma-separated paths)", v)
+		}
+		tokens := strings.Split(path, ".")
+		if strings.HasPrefix(path, "/") {
+			tokens = pointerTokens(path)
+		}
+		*f = append(*f, tokens)
+	}
+	return nil
+}
+
+// ignores reports whether the document path tokens lies under an ignored
+// path.
+func (f diffIgnoreFlag) ignores(tokens []string) bool {
+	for _, ignore := range f {
+		if len(ignore) > len(tokens) {
+			continue
+		}
+		match := true
+		for i, t := range ignore {
+			if t != "*" && t != tokens[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// diffTarget resolves the -diff URL like a -targets-file line: its own path,
+// else -path.
+func diffTarget(spec, defPath string) (target, error) {
+	t := target{baseURL: spec, path: defPath}
+	if u, err := url.Parse(spec); err == nil && u.Path != "" && u.Path != "/" {
+		t.path = u.Path
+	}
+	if t.path == "" {
+		return t, fmt.Errorf("-diff %q has no path and -path is not set", spec)
+	}
+	if _, err := client.BuildURL(t.baseURL, t.path, 0); err != nil {
+		return t, fmt.Errorf("-diff: %w", err)
+	}
+	return t, nil
+}
+
+// diffLine is one difference between the two responses.
+type diffLine struct {
+	op       byte // '+' only in the -diff side, '-' only in -url's, '~' changed
+	path     string
+	old, new any
+}
+
+// diff sends the payload to the -url and the -diff endpoints at once, takes
+// the first response from each (or the one carrying the -correlation-field
+// id) and prints the paths where they differ. It fails when they do.
+func (a *app) diff(ctx context.Context, opts options, j job) (err error) {
+	// Both sides get the same bytes, transformed once.
+	if j.first, err = a.connPayload(opts, j.payload); err != nil {
+		return err
+	}
+	dopts := opts
+	dopts.baseURL, dopts.path = opts.diffTarget.baseURL, opts.diffTarget.path
+	sides := []options{opts, dopts}
+	urls := make([]string, len(sides))
+	msgs := make([]client.Message, len(sides))
+	errs := make([]error, len(sides))
+	var wg sync.WaitGroup
+	for i, o := range sides {
+		urls[i], _ = client.BuildURL(o.baseURL, o.path, o.port)
+		wg.Add(1)
+		go func(i int, o options) {
+			defer wg.Done()
+			msgs[i], errs[i] = a.diffResponse(ctx, o, j)
+		}(i, o)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s: %w", urls[i], err)
+		}
+	}
+
+	fmt.Fprintf(a.stdout, "--- %s\n+++ %s\n", urls[0], urls[1])
+	var lines []diffLine
+	var left, right any
+	if json.Unmarshal(msgs[0].Data, &left) == nil && json.Unmarshal(msgs[1].Data, &right) == nil {
+		jsonDiff(left, right, nil, opts.diffIgnore, &lines)
+	} else if !bytes.Equal(msgs[0].Data, msgs[1].Data) {
+		lines = append(lines, diffLine{op: '~', old: string(msgs[0].Data), new: string(msgs[1].Data)})
+	}
+	printDiff(a.stdout, lines)
+	if len(lines) > 0 {
+		return fmt.Errorf("responses differ at %d path(s)", len(lines))
+	}
+	return nil
+}
+
+// diffResponse runs one side of -diff: connect, send the payload and wait
+// up to -read-timeout for the response.
+func (a *app) diffResponse(ctx context.Context, opts options, j job) (client.Message, error) {
+	c, err := a.connect(ctx, opts, j.signed())
+	if err != nil {
+		return client.Message{}, err
+	}
+	defer func() {
+		_ = c.Close(websocket.CloseNormalClosure, "")
+		for range c.Receive() {
+		}
+	}()
+	var sentAt time.Time
+	if j.payload != nil {
+		sentAt = time.Now()
+		if err := a.send(ctx, c, j.signed()); err != nil && !errors.Is(err, errMaxSends) {
+			return client.Message{}, fmt.Errorf("send message: %w", err)
+		}
+	}
+	var match func(client.Message) bool
+	if j.corrID != "" {
+		match = correlationMatcher(opts.correlationField, j.corrID)
+	}
+	var timeout <-chan time.Time
+	if opts.readTimeout > 0 {
+		timeout = time.After(opts.readTimeout)
+	}
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				return client.Message{}, fmt.Errorf("connection closed before a response: %v", c.Err())
+			}
+			if msg.Time.Before(sentAt) {
+				continue // pushed before the send, e.g. a greeting
+			}
+			if match == nil || match(msg) {
+				return msg, nil
+			}
+		case <-timeout:
+			return client.Message{}, fmt.Errorf("no response within %s", opts.readTimeout)
+		case <-ctx.Done():
+			return client.Message{}, ctx.Err()
+		}
+	}
+}
+
+// jsonDiff appends the differences between the decoded documents a and b
+// below path to out, skipping ignored paths. Object keys are visited in
+// sorted order so the output is stable.
+func jsonDiff(a, b any, path []string, ignore diffIgnoreFlag, out *[]diffLine) {
+	child := func(key string) []string {
+		return append(append([]string(nil), path...), key)
+	}
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			x, inA := av[k]
+			y, inB := bv[k]
+			switch p := child(k); {
+			case ignore.ignores(p):
+			case !inB:
+				*out = append(*out, diffLine{op: '-', path: jsonPointer(p), old: x})
+			case !inA:
+				*out = append(*out, diffLine{op: '+', path: jsonPointer(p), new: y})
+			default:
+				jsonDiff(x, y, p, ignore, out)
+			}
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(av), len(bv)); i++ {
+			switch p := child(strconv.Itoa(i)); {
+			case ignore.ignores(p):
+			case i >= len(bv):
+				*out = append(*out, diffLine{op: '-', path: jsonPointer(p), old: av[i]})
+			case i >= len(av):
+				*out = append(*out, diffLine{op: '+', path: jsonPointer(p), new: bv[i]})
+			default:
+				jsonDiff(av[i], bv[i], p, ignore, out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, diffLine{op: '~', path: jsonPointer(path), old: a, new: b})
+	}
+}
+
+// jsonPointer joins tokens into a JSON Pointer, escaping ~ and /.
+func jsonPointer(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString("/" + strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+func printDiff(w io.Writer, lines []diffLine) {
+	if len(lines) == 0 {
+		fmt.Fprintln(w, "responses are identical")
+		return
+	}
+	compact := func(v any) string {
+		out, _ := json.Marshal(v)
+		return string(out)
+	}
+	for _, l := range lines {
+		path := l.path
+		if path == "" {
+			path = "(root)"
+		}
+		switch l.op {
+		case '-':
+			fmt.Fprintf(w, "- %s: %s\n", path, compact(l.old))
+		case '+':
+			fmt.Fprintf(w, "+ %s: %s\n", path, compact(l.new))
+		default:
+			fmt.Fprintf(w, "~ %s: %s -> %s\n", path, compact(l.old), compact(l.new))
+		}
+	}
+}
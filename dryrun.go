@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// dryRun prints the resolved URL, the handshake headers (secrets redacted)
+// and every payload that would be sent, without opening a connection.
+// With -print-handshake the exact upgrade request takes the place of the
+// URL and headers. signed is the payload the handshake headers would sign,
+// if any.
+func (a *app) dryRun(opts options, signed []byte, payloads [][]byte) error {
+	copts, err := clientOptions(opts, signed)
+	if err != nil {
+		return err
+	}
+	if opts.printHandshake {
+		req, err := client.HandshakeRequest(context.Background(), copts)
+		if err != nil {
+			return fmt.Errorf("build handshake request: %w", err)
+		}
+		a.stdout.Write(bytes.ReplaceAll(req, []byte("\r\n"), []byte("\n")))
+		a.printPayloads(payloads)
+		return nil
+	}
+	fmt.Fprintf(a.stdout, "url: %s\n", copts.URL)
+	fmt.Fprintln(a.stdout, "headers:")
+	names := make([]string, 0, len(copts.Header))
+	for name := range copts.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range copts.Header[name] {
+			if opts.headerCmds.has(name) {
+				v = "[redacted]"
+			}
+			fmt.Fprintf(a.stdout, "  %s: %s\n", name, redactHeader(name, v))
+		}
+	}
+	fmt.Fprintln(a.stdout, "  (plus Upgrade, Connection, Sec-WebSocket-Key and Sec-WebSocket-Version added by the dialer)")
+	a.printPayloads(payloads)
+	return nil
+}
+
+func (a *app) printPayloads(payloads [][]byte) {
+	for i, p := range payloads {
+		fmt.Fprintf(a.stdout, "payload %d: %s\n", i+1, p)
+	}
+	fmt.Fprintln(a.stderr, "dry run: nothing was sent")
+}
+
+// redactHeader hides the value of headers that usually carry credentials,
+// keeping a short prefix so different values can still be told apart.
+func redactHeader(name, value string) string {
+	if !sensitiveHeader(name) {
+		return value
+	}
+	if scheme, _, ok := strings.Cut(value, " "); ok && len(scheme) < 16 {
+		return scheme + " [redacted]"
+	}
+	return "[redacted]"
+}
+
+func sensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie":
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, word := range []string{"token", "secret", "password", "api-key", "apikey"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
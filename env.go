@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to the upper-cased flag name to form the
+// environment variable that provides its default, e.g. POSTWS_READ_TIMEOUT.
+const envPrefix = "POSTWS_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag that was not given explicitly from its POSTWS_*
+// variable. Repeatable flags such as -H take one value per line. It returns
+// the names of the flags that were set and marks them in explicit so
+// profiles do not override them.
+func applyEnv(fs *flag.FlagSet, explicit map[string]bool) ([]string, error) {
+	var fromEnv []string
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || f.Name == "list-profiles" {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		values := []string{value}
+		switch f.Value.(type) {
+		case *headerFlag, *headerCmdFlag, *setFlag, *transformFlag, *extensionFlag, *quirkFlag, *diffIgnoreFlag, *dataFileFlag, *decodeFieldFlag, *captureFlag, *expectFieldFlag:
+			values = strings.Split(strings.TrimRight(value, "\n"), "\n")
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("%s: invalid value %q for -%s: %w", name, v, f.Name, setErr)
+				return
+			}
+		}
+		explicit[f.Name] = true
+		fromEnv = append(fromEnv, f.Name)
+	})
+	return fromEnv, err
+}
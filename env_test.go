@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestApplyEnvRepeatable checks that a POSTWS_* variable of a repeatable
+// flag gives one value per line.
+func TestApplyEnvRepeatable(t *testing.T) {
+	for _, tc := range []struct {
+		flag, env string
+		got       func(options) []string
+		want      []string
+	}{
+		{"capture", "TOKEN=data.token\nSESSION=session.id\n", func(o options) []string { return o.captures }, []string{"TOKEN=data.token", "SESSION=session.id"}},
+		{"expect-field", "status=ok\ncount=2", func(o options) []string { return o.expectFields }, []string{"status=ok", "count=2"}},
+	} {
+		t.Run(tc.flag, func(t *testing.T) {
+			isolateEnv(t)
+			t.Setenv(envName(tc.flag), tc.env)
+			opts, err := parseFlags([]string{"send", "-url", "ws://127.0.0.1:18080", "-path", "/", "a=1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.got(opts); !slices.Equal(got, tc.want) {
+				t.Errorf("-%s from %s = %q, want %q", tc.flag, envName(tc.flag), got, tc.want)
+			}
+			if !slices.Contains(opts.fromEnv, tc.flag) {
+				t.Errorf("fromEnv = %q, want %s listed", opts.fromEnv, tc.flag)
+			}
+		})
+	}
+}
+
+func TestApplyEnvExplicitWins(t *testing.T) {
+	isolateEnv(t)
+	t.Setenv("POSTWS_CAPTURE", "TOKEN=data.token")
+	opts, err := parseFlags([]string{"send", "-url", "ws://127.0.0.1:18080", "-path", "/", "-capture", "ID=id", "a=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ID=id"}; !slices.Equal(opts.captures, want) {
+		t.Errorf("-capture = %q, want only the explicit %q", opts.captures, want)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventLog writes -events-json lifecycle events, one JSON object per line.
+// Every event has "event" and "time"; the other fields depend on the event.
+// All methods are no-ops on a nil *eventLog.
+type eventLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	f   *os.File // the -events-json file, if it is not stderr
+}
+
+// openEvents sets up a.events for -events-json: "stderr" or a file path.
+func (a *app) openEvents(opts options) error {
+	switch opts.eventsJSON {
+	case "":
+		return nil
+	case "stderr":
+		a.events = &eventLog{enc: json.NewEncoder(a.stderr)}
+	default:
+		f, err := os.Create(opts.eventsJSON)
+		if err != nil {
+			return fmt.Errorf("-events-json: %w", err)
+		}
+		a.events = &eventLog{enc: json.NewEncoder(f), f: f}
+	}
+	a.out.events = a.events
+	return nil
+}
+
+func (l *eventLog) emit(event string, fields map[string]any) {
+	if l == nil {
+		return
+	}
+	rec := make(map[string]any, len(fields)+2)
+	for k, v := range fields {
+		rec[k] = v
+	}
+	rec["event"] = event
+	rec["time"] = time.Now().Format(time.RFC3339Nano)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = l.enc.Encode(rec)
+}
+
+func (l *eventLog) close() {
+	if l == nil || l.f == nil {
+		return
+	}
+	_ = l.f.Close()
+}
+
+// errorString is err's message, or nil for a JSON null.
+func errorString(err error) any {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// dataField renders a message body for an event: JSON stays structured,
+// anything else becomes a string.
+func dataField(data []byte) any {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// execRunner runs the -exec command once per received message on a fixed
+// pool of workers. Messages queue up to -exec-queue deep; beyond that they
+// skip the command so the read loop never waits on it.
+type execRunner struct {
+	args     []string
+	stdout   io.Writer
+	stderr   io.Writer
+	failFast context.CancelCauseFunc // nil unless -exec-fail-fast
+	queue    chan execJob
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	runs    int
+	failed  int
+	skipped int
+}
+
+type execJob struct {
+	index int
+	msg   client.Message
+}
+
+func startExec(opts options, stdout, stderr io.Writer, cancel context.CancelCauseFunc) (*execRunner, error) {
+	args, err := splitCommand(opts.exec)
+	if err != nil {
+		return nil, fmt.Errorf("-exec: %w", err)
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("-exec: %w", err)
+	}
+	r := &execRunner{
+		args:   args,
+		stdout: stdout,
+		stderr: stderr,
+		queue:  make(chan execJob, opts.execQueue),
+	}
+	if opts.execFailFast {
+		r.failFast = cancel
+	}
+	for i := 0; i < opts.execConcurrency; i++ {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r, nil
+}
+
+// submit queues msg, the index-th shown message, without blocking.
+func (r *execRunner) submit(index int, msg client.Message) {
+	select {
+	case r.queue <- execJob{index, msg}:
+	default:
+		r.mu.Lock()
+		r.skipped++
+		r.mu.Unlock()
+		fmt.Fprintf(r.stderr, "exec: queue full; skipping message %d\n", index)
+	}
+}
+
+func (r *execRunner) work() {
+	defer r.wg.Done()
+	for job := range r.queue {
+		err := r.run(job)
+		r.mu.Lock()
+		r.runs++
+		if err != nil {
+			r.failed++
+		}
+		r.mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(r.stderr, "exec: message %d: %v\n", job.index, err)
+			if r.failFast != nil {
+				r.failFast(fmt.Errorf("-exec failed on message %d", job.index))
+			}
+		}
+	}
+}
+
+func (r *execRunner) run(job execJob) error {
+	kind := "text"
+	if job.msg.Type == websocket.BinaryMessage {
+		kind = "binary"
+	}
+	cmd := exec.Command(r.args[0], r.args[1:]...)
+	cmd.Stdin = bytes.NewReader(job.msg.Data)
+	cmd.Stdout = r.stdout
+	cmd.Stderr = r.stderr
+	cmd.Env = append(os.Environ(),
+		"POSTWS_MSG_INDEX="+strconv.Itoa(job.index),
+		"POSTWS_MSG_TIME="+job.msg.Time.Format(time.RFC3339Nano),
+		"POSTWS_MSG_TYPE="+kind,
+	)
+	return cmd.Run()
+}
+
+// close waits for the queued commands, reports the totals and fails if any
+// command did.
+func (r *execRunner) close() error {
+	close(r.queue)
+	r.wg.Wait()
+	fmt.Fprintf(r.stderr, "exec: %d runs, %d failed, %d skipped\n", r.runs, r.failed, r.skipped)
+	if r.failed > 0 {
+		return fmt.Errorf("%d of %d -exec runs failed", r.failed, r.runs)
+	}
+	return nil
+}
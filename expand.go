@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandFlag is -expand-json-strings: on its own, every string value that
+// holds a JSON object or array is expanded; with =PATHS, only those at the
+// comma-separated dot paths or JSON Pointers, where a * segment matches any
+// key or index. Paths inside an expanded string continue from its own path,
+// e.g. body.status for {"body":"{\"status\":\"ok\"}"}.
+type expandFlag struct {
+	on    bool
+	paths [][]string
+}
+
+func (f *expandFlag) String() string {
+	if f == nil || !f.on {
+		return ""
+	}
+	if len(f.paths) == 0 {
+		return "true"
+	}
+	paths := make([]string, len(f.paths))
+	for i, tokens := range f.paths {
+		paths[i] = jsonPointer(tokens)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (f *expandFlag) IsBoolFlag() bool { return true }
+
+func (f *expandFlag) Set(v string) error {
+	switch v {
+	case "true":
+		f.on, f.paths = true, nil
+		return nil
+	case "false":
+		f.on, f.paths = false, nil
+		return nil
+	}
+	for _, path := range strings.Split(v, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return fmt.Errorf("want comma-separated paths")
+		}
+		tokens := strings.Split(path, ".")
+		if strings.HasPrefix(path, "/") {
+			tokens = pointerTokens(path)
+		}
+		f.paths = append(f.paths, tokens)
+	}
+	f.on = true
+	return nil
+}
+
+// matches reports whether string values at path are to be expanded.
+func (f *expandFlag) matches(path []string) bool {
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, p := range f.paths {
+		if len(p) != len(path) {
+			continue
+		}
+		match := true
+		for i, t := range p {
+			if t != "*" && t != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// expandedMark precedes a value that was a JSON string on the wire.
+const expandedMark = "/* json string */ "
+
+// jsonExpander indents JSON messages like json.Indent, keeping the key
+// order and numbers as received, but renders string values that hold a
+// JSON object or array as that document, indented in place and marked with
+// expandedMark. The result is for reading only: it is not JSON any more.
+type jsonExpander struct {
+	flag     *expandFlag
+	sortKeys bool // also sort the keys of expanded documents, for -sort-keys
+}
+
+// newJSONExpander returns nil unless -expand-json-strings is set.
+func newJSONExpander(f *expandFlag, sortKeys bool) *jsonExpander {
+	if f == nil || !f.on {
+		return nil
+	}
+	return &jsonExpander{flag: f, sortKeys: sortKeys}
+}
+
+// indent renders msg, reporting false when it is not a JSON document or x
+// is nil.
+func (x *jsonExpander) indent(msg []byte, prefix, indent string) ([]byte, bool) {
+	if x == nil || !json.Valid(msg) {
+		return nil, false
+	}
+	var b bytes.Buffer
+	x.render(&b, msg, nil, prefix, indent)
+	return b.Bytes(), true
+}
+
+// render writes the valid JSON document doc, found at path, with its
+// nested lines starting at prefix.
+func (x *jsonExpander) render(b *bytes.Buffer, doc []byte, path []string, prefix, indent string) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	x.value(b, dec, path, prefix, indent)
+}
+
+func (x *jsonExpander) value(b *bytes.Buffer, dec *json.Decoder, path []string, prefix, indent string) {
+	tok, err := dec.Token()
+	if err != nil {
+		return // doc was checked with json.Valid
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		closing := "]"
+		if t == '{' {
+			closing = "}"
+		}
+		b.WriteString(t.String())
+		inner := prefix + indent
+		i := 0
+		for ; dec.More(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString("\n" + inner)
+			child := strconv.Itoa(i)
+			if t == '{' {
+				key, _ := dec.Token()
+				child = key.(string)
+				writeJSONString(b, child)
+				b.WriteString(": ")
+			}
+			x.value(b, dec, append(path[:len(path):len(path)], child), inner, indent)
+		}
+		if i > 0 {
+			b.WriteString("\n" + prefix)
+		}
+		_, _ = dec.Token() // the closing delimiter
+		b.WriteString(closing)
+	case string:
+		if doc, ok := x.embedded(t, path); ok {
+			b.WriteString(expandedMark)
+			x.render(b, doc, path, prefix, indent)
+			return
+		}
+		writeJSONString(b, t)
+	case json.Number:
+		b.WriteString(t.String())
+	case bool:
+		fmt.Fprint(b, t)
+	case nil:
+		b.WriteString("null")
+	}
+}
+
+// embedded returns the JSON object or array held in the string s at path,
+// if it is one and path is selected.
+func (x *jsonExpander) embedded(s string, path []string) ([]byte, bool) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" || trimmed[0] != '{' && trimmed[0] != '[' || !x.flag.matches(path) {
+		return nil, false
+	}
+	doc := []byte(trimmed)
+	if !json.Valid(doc) {
+		return nil, false
+	}
+	if x.sortKeys {
+		doc = sortedKeys(doc)
+	}
+	return doc, true
+}
+
+// writeJSONString writes s as a JSON string, leaving <, > and & as they are.
+func writeJSONString(b *bytes.Buffer, s string) {
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	b.Truncate(b.Len() - 1) // Encode appends a newline
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// countRange is an -expect-count MIN:MAX assertion on the number of
+// messages received over the whole run. max is -1 when there is no upper
+// bound.
+type countRange struct {
+	set      bool
+	min, max int
+}
+
+func (r countRange) contains(n int) bool {
+	return n >= r.min && (r.max < 0 || n <= r.max)
+}
+
+func (r countRange) String() string {
+	if r.max < 0 {
+		return fmt.Sprintf("%d:", r.min)
+	}
+	return fmt.Sprintf("%d:%d", r.min, r.max)
+}
+
+// expectCountFlag parses -expect-count: a plain N stops receiving once N
+// messages have arrived, while MIN:MAX (either side may be left empty)
+// receives until the read timeout and fails the run when the count falls
+// outside the range.
+type expectCountFlag struct{ opts *options }
+
+func (f expectCountFlag) String() string {
+	if f.opts == nil {
+		return ""
+	}
+	if f.opts.expectRange.set {
+		return f.opts.expectRange.String()
+	}
+	if f.opts.expectCount == 0 {
+		return ""
+	}
+	return strconv.Itoa(f.opts.expectCount)
+}
+
+func (f expectCountFlag) Set(v string) error {
+	lo, hi, isRange := strings.Cut(v, ":")
+	if !isRange {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid count %q (want N or MIN:MAX)", v)
+		}
+		f.opts.expectCount, f.opts.expectRange = n, countRange{}
+		return nil
+	}
+	r := countRange{set: true, max: -1}
+	var err error
+	if lo != "" {
+		if r.min, err = strconv.Atoi(lo); err != nil || r.min < 0 {
+			return fmt.Errorf("invalid minimum in %q (want MIN:MAX)", v)
+		}
+	}
+	if hi != "" {
+		if r.max, err = strconv.Atoi(hi); err != nil || r.max < 0 {
+			return fmt.Errorf("invalid maximum in %q (want MIN:MAX)", v)
+		}
+	}
+	if lo == "" && hi == "" {
+		return fmt.Errorf("invalid count %q (want N or MIN:MAX)", v)
+	}
+	if r.max >= 0 && r.min > r.max {
+		return fmt.Errorf("minimum is above maximum in %q", v)
+	}
+	f.opts.expectCount, f.opts.expectRange = 0, r
+	return nil
+}
+
+// checkCount fails the run when -expect-count MIN:MAX is set and the
+// number of messages received lies outside the range.
+func (a *app) checkCount(opts options) error {
+	if !opts.expectRange.set {
+		return nil
+	}
+	n := a.out.received
+	if opts.expectRange.contains(n) {
+		return nil
+	}
+	want := fmt.Sprintf("%d to %d", opts.expectRange.min, opts.expectRange.max)
+	if opts.expectRange.max < 0 {
+		want = fmt.Sprintf("at least %d", opts.expectRange.min)
+	}
+	return fmt.Errorf("-expect-count: received %d message(s), expected %s", n, want)
+}
+
+// expectFieldFlag collects repeated -expect-field path=value assertions.
+type expectFieldFlag []string
+
+func (f *expectFieldFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *expectFieldFlag) Set(v string) error {
+	path, _, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(path) == "" {
+		return fmt.Errorf("invalid -expect-field %q (want path=value)", v)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// fieldExpectations tracks the -expect-field assertions over the whole run.
+// Each passes once any received message has the value at its path; the
+// expected value is compared as JSON when it parses (so 42, true and null
+// are typed and "42" is a string) and as a string otherwise. All methods
+// are no-ops on a nil *fieldExpectations.
+type fieldExpectations struct {
+	mu     sync.Mutex
+	checks []fieldCheck
+}
+
+type fieldCheck struct {
+	spec, path string
+	want       any
+	matched    int // the first matching message, counted from 1; 0 if none yet
+}
+
+func newFieldExpectations(specs expectFieldFlag) *fieldExpectations {
+	if len(specs) == 0 {
+		return nil
+	}
+	f := &fieldExpectations{}
+	for _, spec := range specs {
+		path, value, _ := strings.Cut(spec, "=")
+		var want any
+		if err := json.Unmarshal([]byte(value), &want); err != nil {
+			want = value
+		}
+		f.checks = append(f.checks, fieldCheck{spec: spec, path: path, want: want})
+	}
+	return f
+}
+
+// observe checks the n-th received message against the assertions not
+// satisfied yet.
+func (f *fieldExpectations) observe(msg client.Message, n int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var doc any
+	if err := json.Unmarshal(msg.Data, &doc); err != nil {
+		return
+	}
+	for i := range f.checks {
+		c := &f.checks[i]
+		if c.matched > 0 {
+			continue
+		}
+		if v, ok := lookupPath(doc, c.path); ok && reflect.DeepEqual(v, c.want) {
+			c.matched = n
+		}
+	}
+}
+
+// report prints which assertions were satisfied and fails the run unless
+// all of them were.
+func (f *fieldExpectations) report(w io.Writer) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failed := 0
+	for _, c := range f.checks {
+		if c.matched > 0 {
+			fmt.Fprintf(w, "expect-field: %s satisfied by message %d\n", c.spec, c.matched)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "expect-field: %s not satisfied\n", c.spec)
+	}
+	if failed > 0 {
+		return fmt.Errorf("-expect-field: %d of %d assertion(s) not satisfied", failed, len(f.checks))
+	}
+	return nil
+}
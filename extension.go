@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// extensionFlag collects repeated -extension offers for the
+// Sec-WebSocket-Extensions header, e.g. "permessage-deflate;
+// client_max_window_bits".
+type extensionFlag []string
+
+func (e *extensionFlag) String() string {
+	return strings.Join(*e, ", ")
+}
+
+func (e *extensionFlag) Set(v string) error {
+	if err := checkExtension(v); err != nil {
+		return err
+	}
+	*e = append(*e, strings.TrimSpace(v))
+	return nil
+}
+
+// checkExtension validates one extension offer against RFC 6455 section
+// 9.1: a token followed by ";"-separated parameters, each a token with an
+// optional token or quoted-string value.
+func checkExtension(v string) error {
+	parts := strings.Split(v, ";")
+	if name := strings.TrimSpace(parts[0]); !isToken(name) {
+		return fmt.Errorf("invalid extension %q (want name[; param[=value]...])", v)
+	}
+	for _, param := range parts[1:] {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(param), "=")
+		if !isToken(strings.TrimSpace(name)) {
+			return fmt.Errorf("invalid extension parameter %q in %q", param, v)
+		}
+		value = strings.TrimSpace(value)
+		if hasValue && !isToken(value) && !isQuotedToken(value) {
+			return fmt.Errorf("invalid value for extension parameter %q in %q", strings.TrimSpace(name), v)
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is a non-empty HTTP token (RFC 7230).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isQuotedToken reports whether s is a quoted string whose content is a
+// token, which is all RFC 6455 allows for extension parameter values.
+func isQuotedToken(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' && isToken(s[1:len(s)-1])
+}
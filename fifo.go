@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// fifoReader reads newline-delimited messages from the -input-fifo named
+// pipe. Opening a FIFO blocks until a writer shows up and reading ends once
+// the last writer has closed it, so the pipe is reopened after every EOF and
+// writers can come and go for as long as the session runs.
+type fifoReader struct {
+	path  string
+	lines chan []byte
+	errc  chan error // an open or read failure other than EOF
+	stop  chan struct{}
+
+	mu     sync.Mutex
+	f      *os.File // currently open, for close to interrupt its read
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// checkFIFO fails unless path is an existing named pipe.
+func checkFIFO(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("-input-fifo: %w", err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("-input-fifo %s is not a named pipe (create it with mkfifo)", path)
+	}
+	return nil
+}
+
+func openFIFO(path string) *fifoReader {
+	r := &fifoReader{path: path, lines: make(chan []byte), errc: make(chan error, 1), stop: make(chan struct{})}
+	r.wg.Add(1)
+	go r.loop()
+	return r
+}
+
+func (r *fifoReader) loop() {
+	defer r.wg.Done()
+	defer close(r.lines)
+	for {
+		f, err := os.Open(r.path)
+		if err != nil {
+			r.errc <- err
+			return
+		}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			f.Close()
+			return
+		}
+		r.f = f
+		r.mu.Unlock()
+
+		sc := bufio.NewScanner(f)
+		sc.Buffer(nil, 16<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			select {
+			case r.lines <- bytes.Clone(line):
+			case <-r.stop:
+				f.Close()
+				return
+			}
+		}
+		f.Close()
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		if err := sc.Err(); err != nil {
+			r.errc <- err
+			return
+		}
+		// Every writer has gone; wait for the next one.
+	}
+}
+
+// close stops reading and waits for the reader to finish. A read in
+// progress is interrupted by closing the file; an open still waiting for a
+// writer is released by briefly opening the pipe for writing, repeated in
+// case the reader only gets to its open afterwards.
+func (r *fifoReader) close() {
+	r.mu.Lock()
+	r.closed = true
+	close(r.stop)
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	for {
+		if w, err := os.OpenFile(r.path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			w.Close()
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// inputFIFO sends the payload, if any, and then every JSON line written to
+// the -input-fifo pipe, printing what arrives meanwhile, until the server
+// closes the connection or ctx is cancelled (Ctrl-C or -max-duration).
+// Lines that are not valid JSON are counted and skipped.
+func (a *app) inputFIFO(ctx context.Context, c *client.Client, opts options, j job) (receiveResult, error) {
+	res := receiveResult{connected: true}
+	if j.payload != nil {
+		payload := j.first
+		if payload == nil {
+			var err error
+			if payload, err = a.transform.apply(j.payload); err != nil {
+				return res, err
+			}
+		}
+		switch err := a.send(ctx, c, payload); {
+		case errors.Is(err, errMaxSends):
+		case err != nil:
+			return res, fmt.Errorf("send message: %w", err)
+		default:
+			a.printSent(a.stdout, payload)
+		}
+	}
+
+	r := openFIFO(opts.inputFIFO)
+	sent, malformed := 0, 0
+	defer func() {
+		r.close()
+		fmt.Fprintf(a.stderr, "input-fifo: %d line(s) sent, %d malformed line(s) skipped\n", sent, malformed)
+	}()
+	lines := r.lines
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				break
+			}
+			if !json.Valid(line) {
+				malformed++
+				fmt.Fprintf(a.stderr, "input-fifo: skipping a line that is not valid JSON (%d so far): %.80s\n", malformed, line)
+				break
+			}
+			payload, err := a.transform.apply(line)
+			if err != nil {
+				malformed++
+				fmt.Fprintf(a.stderr, "input-fifo: skipping a line (%d so far): %v\n", malformed, err)
+				break
+			}
+			switch err := a.send(ctx, c, payload); {
+			case errors.Is(err, errMaxSends):
+				lines = nil // only receive from now on
+			case err != nil:
+				return res, fmt.Errorf("send message: %w", err)
+			default:
+				sent++
+				a.printSent(a.stdout, payload)
+			}
+		case err := <-r.errc:
+			a.closeAndDrain(c, "input-fifo failed")
+			return res, fmt.Errorf("-input-fifo: %w", err)
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				res.closeCode = closeCode(c.Err())
+				return res, nil
+			}
+			a.out.handle(msg)
+			if a.budgetSpent() {
+				a.closeAndDrain(c, budgetReason)
+				res.done = true
+				return res, nil
+			}
+		case <-ctx.Done():
+			return a.receive(ctx, c, receivePlan{}), nil
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// filePollInterval is how often -watch-file checks the data file.
+const filePollInterval = 500 * time.Millisecond
+
+// readDataFile loads a -data-file payload, compacted to a single line.
+func readDataFile(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("-data-file: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, fmt.Errorf("-data-file %s: invalid JSON: %w", path, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// watchFile sends the -data-file payload and then re-sends it every time one
+// of the files changes, printing what arrives in between, until the server closes
+// the connection or ctx is cancelled. An edit that is not valid JSON is
+// reported and skipped.
+func (a *app) watchFile(ctx context.Context, c *client.Client, opts options, j job) (receiveResult, error) {
+	res := receiveResult{connected: true}
+	first := j.first
+	if first == nil {
+		var err error
+		if first, err = a.transform.apply(j.payload); err != nil {
+			return res, err
+		}
+	}
+	switch err := a.send(ctx, c, first); {
+	case errors.Is(err, errMaxSends):
+	case err != nil:
+		return res, fmt.Errorf("send message: %w", err)
+	default:
+		a.printSent(a.stdout, first)
+	}
+
+	last := j.payload
+	lastMod := make(map[string]time.Time, len(opts.dataFiles))
+	for _, path := range opts.dataFiles {
+		if fi, err := os.Stat(path); err == nil {
+			lastMod[path] = fi.ModTime()
+		}
+	}
+
+	ticker := time.NewTicker(filePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				res.closeCode = closeCode(c.Err())
+				return res, nil
+			}
+			a.out.handle(msg)
+			if a.budgetSpent() {
+				a.closeAndDrain(c, budgetReason)
+				res.done = true
+				return res, nil
+			}
+		case <-ticker.C:
+			var changed []string
+			var modTime time.Time
+			for _, path := range opts.dataFiles {
+				fi, err := os.Stat(path)
+				if err != nil || fi.ModTime().Equal(lastMod[path]) {
+					continue
+				}
+				lastMod[path] = fi.ModTime()
+				changed = append(changed, path)
+				if fi.ModTime().After(modTime) {
+					modTime = fi.ModTime()
+				}
+			}
+			if len(changed) == 0 {
+				continue
+			}
+			payload, err := buildPayload(opts, j.corrID)
+			if err != nil {
+				fmt.Fprintf(a.stderr, "%v; keeping the connection open\n", err)
+				continue
+			}
+			if bytes.Equal(payload, last) {
+				continue
+			}
+			last = payload
+			fmt.Fprintf(a.stdout, "--- %s (%s changed) ---\n", modTime.Format(time.RFC3339), strings.Join(changed, ", "))
+			if payload, err = a.transform.apply(payload); err != nil {
+				return res, err
+			}
+			switch err := a.send(ctx, c, payload); {
+			case errors.Is(err, errMaxSends):
+			case err != nil:
+				return res, fmt.Errorf("send message: %w", err)
+			default:
+				a.printSent(a.stdout, payload)
+			}
+		case <-ctx.Done():
+			res.interrupted = true
+			fmt.Fprintln(a.stderr, "interrupted; closing connection")
+			a.closeAndDrain(c, "interrupted")
+			return res, nil
+		}
+	}
+}
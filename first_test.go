@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestFirstFormatChecks runs the output format checks on the format -first
+// picks rather than the one it replaces.
+func TestFirstFormatChecks(t *testing.T) {
+	isolateEnv(t)
+	base := []string{"send", "-url", "ws://127.0.0.1:18080", "-path", "/", "-first"}
+	for _, tc := range []struct {
+		args []string
+		want string // "" for valid
+	}{
+		{[]string{"-sort-keys"}, "-sort-keys needs -format pretty or ndjson"},
+		{[]string{"-format", "ndjson", "-sort-keys"}, ""},
+		{[]string{"-no-newline"}, ""},
+		{[]string{"-format", "pretty", "-no-newline"}, "-no-newline and -null-delimited need"},
+	} {
+		args := append(append(append([]string(nil), base...), tc.args...), "a=1")
+		_, err := parseFlags(args)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("parseFlags(%q): %v", args, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("parseFlags(%q) = %v, want %q", args, err, tc.want)
+		}
+	}
+}
+
+// TestFirstFilter skips the messages -filter hides: -first waits for the
+// first one shown.
+func TestFirstFilter(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		if _, _, err := s.read(conn); err != nil {
+			return
+		}
+		go func() { // answers a close sent after the ack
+			for {
+				if _, _, err := s.read(conn); err != nil {
+					return
+				}
+			}
+		}()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ack"}`))
+		time.Sleep(100 * time.Millisecond)
+		for _, m := range []string{`{"type":"result","token":"t1"}`, `{"type":"result","token":"t2"}`} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+				return
+			}
+		}
+	})
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-first", "-filter", "type=result", "a=1")
+	if r.err != nil {
+		t.Fatalf("send -first: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	if want := `{"type":"result","token":"t1"}` + "\n"; r.stdout != want {
+		t.Errorf("stdout = %q, want %q", r.stdout, want)
+	}
+}
@@ -0,0 +1,1228 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zsuzuki/postws/client"
+)
+
+// hiddenFlags are accepted but left out of -help because they only make
+// sense for protocol testing.
+var hiddenFlags = map[string]bool{
+	"ws-key": true,
+}
+
+type options struct {
+	command string
+	usage   func()
+
+	baseURL          string
+	path             string
+	port             int
+	dialTimeout      time.Duration
+	maxHandshake     time.Duration
+	readTimeout      time.Duration
+	firstMsgTimeout  time.Duration
+	quietPeriod      time.Duration
+	maxOpen          time.Duration
+	data             map[string]string
+	headers          headerFlag
+	headerCmds       headerCmdFlag
+	extensions       extensionFlag
+	quirks           quirkFlag
+	insecureTLS      bool
+	dnsServer        string
+	wsKey            string
+	hmacSecret       string
+	hmacHeader       string
+	hmacEncode       string
+	hashHeader       string // -payload-hash-header
+	traceparent      string
+	trace            bool
+	traceSampled     bool
+	traceField       string
+	waitFor          string
+	waitTimeout      time.Duration
+	scenario         string
+	correlationField string
+	dataFiles        dataFileFlag
+	dataURL          string
+	dataURLJSON      bool
+	dataURLBody      []byte // fetched from dataURL before the run
+	deepMerge        bool
+	sets             setFlag
+	transforms       transformFlag
+	watchFile        bool
+	expectCount      int
+	expectRange      countRange // -expect-count MIN:MAX
+	expectFields     expectFieldFlag
+	watch            time.Duration
+	watchRedial      bool
+	watchCount       int
+	truncate         int
+	format           string
+	monitor          bool
+	strict           bool
+	lastOnly         bool
+	showCompression  bool
+	first            bool
+	dataOrder        []string // Name=Value names in command-line order
+	splitFields      bool
+	messageInterval  time.Duration
+	chaos            bool
+	chaosInterval    time.Duration
+	chaosProbability float64
+	chaosMixSpec     string
+	chaosMix         chaosMix
+	chaosSilence     time.Duration
+	chaosSeed        int64
+	churn            int
+	churnParallel    int
+	failFast         bool
+	stdinLines       bool
+	inputFIFO        string
+	targetsFile      string
+	targets          []target
+	diffSpec         string
+	diffTarget       target // parsed from -diff
+	diffIgnore       diffIgnoreFlag
+	maxInflight      int
+	maxInflightBytes int64
+	expectType       string
+	messageType      string
+	retryJitter      bool
+	seed             int64
+	jitter           *jitterSource // set from -retry-jitter and -seed
+	maxSendRate      int64
+	noAutoPong       bool
+	showPing         bool
+	pingPayloadSpec  string
+	pingPayload      []byte              // parsed from -ping-payload
+	sendLimit        *client.RateLimiter // set from -max-send-rate, shared by every connection
+	readDelay        readDelayFlag
+	readRate         int64
+	recvLimit        *client.RateLimiter // set from -read-rate, likewise
+	maxMessageSize   int64
+	maxRecvBytes     int64
+	monitorPing      time.Duration
+	maxDuration      time.Duration
+	noNewline        bool
+	nullDelimited    bool
+	binaryDir        string
+	demuxField       string
+	demuxDir         string
+	pipe             string
+	timeField        string
+	timeFormat       string
+	since            string
+	until            string
+	window           *timeWindow
+	outputTemplate   string
+	outputPath       string
+	outputCompress   bool
+	outputFlush      time.Duration
+	tee              string
+	sortKeys         bool
+	expandStrings    expandFlag
+	noSanitize       bool
+	backpressureWarn time.Duration
+	decodeFields     decodeFieldFlag
+	template         *template.Template
+	filterSpec       string
+	stats            bool
+	filter           *waitCondition
+	exec             string
+	execConcurrency  int
+	execQueue        int
+	execFailFast     bool
+	forwardURL       string
+	forwardHeaders   headerFlag
+	forwardRetries   int
+	forwardDelay     time.Duration
+	forwardQueue     int
+	forwardPolicy    string
+
+	heartbeatInterval time.Duration
+	heartbeatPayload  string
+	heartbeatIdleOnly bool
+	heartbeatSend     string
+	heartbeatExpect   string
+	heartbeatExpectRe *regexp.Regexp // parsed from a re: -heartbeat-expect
+	heartbeatMisses   int
+	heartbeatShow     bool
+	sendIdleTimeout   time.Duration
+	captures          captureFlag
+	captureTimeout    time.Duration
+	captureFile       string
+	resumePayload     string
+	sendAtSpec        string
+	sendAt            time.Time // parsed from -send-at
+
+	reconnect          bool
+	reconnectDelay     time.Duration
+	reconnectMaxDelay  time.Duration
+	reconnectMax       int
+	reconnectCodes     codeList
+	retryAfterAttempts int
+	retryAfterCodes    codeList
+
+	pingCount    int
+	pingInterval time.Duration
+
+	metricsListen string
+	statsInterval time.Duration
+	statsFile     string
+	sizeStats     bool
+	sizeBuckets   sizeBucketsFlag
+	har           string
+
+	listenAddr       string
+	serveEcho        bool
+	serveGreeting    string
+	serveStream      time.Duration
+	serveStreamMsg   string
+	serveBinary      bool
+	serveCloseAfter  int
+	serveCloseCode   int
+	serveCloseReason string
+	tapRecord        string
+
+	connections   int
+	benchDuration time.Duration
+	ramp          time.Duration
+	rate          float64
+	payloadSize   int64
+	payloadFill   string
+	binary        bool
+	compress      bool
+	maxSends      int
+	benchJSON     bool
+
+	verbose        bool
+	debugErrors    bool
+	eventsJSON     string
+	dryRun         bool
+	check          bool
+	lint           bool
+	printHandshake bool
+	showVersion    bool
+	fromEnv        []string
+
+	configPath   string
+	profile      string
+	listProfiles bool
+}
+
+// flagGroup registers a related set of flags on a subcommand's FlagSet.
+type flagGroup func(fs *flag.FlagSet, opts *options)
+
+// command describes a subcommand: its synopsis, the flag groups it accepts
+// and whether it takes Name=Value payload arguments.
+type command struct {
+	name     string
+	summary  string
+	synopsis string
+	payload  bool
+	groups   []flagGroup
+}
+
+var commands = []command{
+	{
+		name:     "send",
+		summary:  "send a JSON payload and print the responses",
+		synopsis: "-url ws://host -path /ws [-port 8080] [-H 'Name: Value'] [-insecure-skip-verify] [-wait-for type=hello] Name=Value [More=Data]",
+		payload:  true,
+		groups:   []flagGroup{connFlags, configFlags, signFlags, traceFlags, readFlags(10 * time.Second), completionFlags, sendFlags, parallelFlags, watchFlags, heartbeatFlags, reconnectFlags, monitorFlags, chaosFlags, churnFlags, strictFlags, outputFlags, metricsFlags},
+	},
+	{
+		name:     "listen",
+		summary:  "connect without sending and stream what the server pushes",
+		synopsis: "-url ws://host -path /ws [-read-timeout 0]",
+		groups:   []flagGroup{connFlags, configFlags, readFlags(0), completionFlags, heartbeatFlags, reconnectFlags, monitorFlags, chaosFlags, churnFlags, strictFlags, outputFlags, metricsFlags},
+	},
+	{
+		name:     "ping",
+		summary:  "measure control-frame ping/pong round-trip time",
+		synopsis: "-url ws://host -path /ws [-count 4] [-interval 1s]",
+		groups:   []flagGroup{connFlags, configFlags, readFlags(5 * time.Second), pingFlags},
+	},
+	{
+		name:     "bench",
+		summary:  "drive request/response load over several connections",
+		synopsis: "-url ws://host -path /ws [-connections 10] [-duration 10s] Name=Value",
+		payload:  true,
+		groups:   []flagGroup{connFlags, configFlags, signFlags, traceFlags, readFlags(10 * time.Second), benchFlags, metricsFlags},
+	},
+	{
+		name:     "serve",
+		summary:  "run a local echo/test server",
+		synopsis: "[-listen :8080] [-path /ws] [-greeting TEXT] [-stream-interval 1s] [-close-after N -close-code 1011]",
+		groups:   []flagGroup{configFlags, serveFlags},
+	},
+	{
+		name:     "tap",
+		summary:  "relay a client to the server and print the traffic both ways",
+		synopsis: "[-listen :9000] -url wss://real-server -path /ws [-H 'Name: Value'] [-record traffic.jsonl]",
+		groups:   []flagGroup{connFlags, configFlags, tapFlags},
+	},
+	{
+		name:     "throughput",
+		summary:  "measure bytes per second with fixed-size frames",
+		synopsis: "-url ws://host -path /ws [-payload-size 64k] [-binary] [-compress] [-rate 1000] [-duration 10s]",
+		groups:   []flagGroup{connFlags, configFlags, throughputFlags},
+	},
+	{
+		name:    "version",
+		summary: "print version and build information",
+	},
+}
+
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// connFlags are shared by every subcommand: where to connect and how to
+// authenticate.
+func connFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.baseURL, "url", "", "WebSocket base URL (e.g. ws://localhost:8080)")
+	fs.StringVar(&opts.path, "path", "", "WebSocket path (e.g. /ws)")
+	fs.IntVar(&opts.port, "port", 0, "Port to override in the WebSocket URL (optional)")
+	fs.DurationVar(&opts.dialTimeout, "dial-timeout", 10*time.Second, "How long to wait when establishing the connection")
+	fs.DurationVar(&opts.maxHandshake, "max-handshake-latency", 0, "Fail if the handshake takes longer than this, even when it succeeds (0 disables)")
+	fs.Var(&opts.headers, "H", "Extra handshake header as \"Name: Value\" (repeatable)")
+	fs.Var(&opts.headerCmds, "H-cmd", "Handshake header as \"Name: command\", valued with the command's trimmed stdout and rerun before every (re)dial, e.g. for short-lived tokens (repeatable)")
+	fs.Var(&opts.extensions, "extension", "Offer this extension in Sec-WebSocket-Extensions, e.g. \"permessage-deflate; client_max_window_bits\" (repeatable)")
+	fs.Var(&opts.quirks, "handshake-quirk", "Legacy interop only: bend the handshake for an old server; no-version omits Sec-WebSocket-Version, origin-header adds Sec-WebSocket-Origin, lenient-connection accepts a 101 without \"Connection: Upgrade\" (repeatable or comma-separated)")
+	fs.BoolVar(&opts.insecureTLS, "insecure-skip-verify", false, "Skip TLS certificate verification (for wss://; testing only)")
+	fs.StringVar(&opts.dnsServer, "dns-server", "", "DNS server (host:port) used to resolve the WebSocket host instead of the system resolver")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Validate and print the URL, handshake headers (redacted) and payloads without connecting")
+	fs.BoolVar(&opts.printHandshake, "print-handshake", false, "Print the exact HTTP upgrade request, credentials included, and the payloads without connecting (implies -dry-run)")
+	fs.BoolVar(&opts.lint, "lint", false, "Check the flags, URL, headers and input files without connecting, report every problem found and exit non-zero if there are any")
+	fs.BoolVar(&opts.check, "check", false, "Only complete the handshake, close with 1000 and print one ok/fail line with the connect time; exits non-zero on failure")
+	fs.BoolVar(&opts.compress, "compress", false, "Negotiate permessage-deflate and compress sent messages when the server agrees")
+	fs.BoolVar(&opts.noAutoPong, "no-auto-pong", false, "Do not answer the server's pings, to test how it treats a client that ignores them")
+	fs.BoolVar(&opts.showPing, "show-ping", false, "Print every ping the server sends to stderr")
+	fs.StringVar(&opts.pingPayloadSpec, "ping-payload", "", "Application data of the pings we send, as text or hex:0a1b... (at most 125 bytes)")
+	fs.Var(sizeFlag{&opts.maxSendRate}, "max-send-rate", "Limit outgoing bytes per second across all connections, e.g. 64k, to simulate a slow uplink (0 is unlimited)")
+	fs.Var(&opts.readDelay, "read-delay", "Sleep this long (or a random time in MIN-MAX, e.g. 10ms-200ms) before reading every message, to simulate a slow reader")
+	fs.Var(sizeFlag{&opts.readRate}, "read-rate", "Limit incoming bytes per second across all connections, e.g. 16k, so TCP backpressure builds up on the server (0 is unlimited)")
+	fs.Var(sizeFlag{&opts.maxMessageSize}, "max-message-size", "End the session with close code 1009 when a message larger than this arrives, e.g. 1m (0 is unlimited)")
+	fs.StringVar(&opts.wsKey, "ws-key", "", "Fixed Sec-WebSocket-Key (base64 of 16 bytes) for reproducible handshakes; testing only")
+	fs.BoolVar(&opts.debugErrors, "debug-errors", false, "When the dial fails, print every layer of the error chain with the net.OpError, DNS and syscall details and the likely cause (DNS, refused, TLS, timeout)")
+}
+
+func configFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.verbose, "verbose", false, "Print extra diagnostics to stderr")
+	fs.StringVar(&opts.eventsJSON, "events-json", "", "Write lifecycle events as JSON lines to \"stderr\" or this file")
+	fs.BoolVar(&opts.showVersion, "version", false, "Print version and build information and exit")
+	fs.StringVar(&opts.configPath, "config", "", "Config file with named profiles (default "+defaultConfigPath()+")")
+	fs.StringVar(&opts.profile, "profile", "", "Profile from the config file to use as defaults; explicit flags override it")
+	fs.BoolVar(&opts.listProfiles, "list-profiles", false, "List the profiles defined in the config file and exit")
+}
+
+// signFlags sign the payload, so they only apply to commands that send one.
+func signFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.hmacSecret, "hmac-secret", "", "Secret for signing the payload with HMAC-SHA256 (env:NAME and file:PATH are resolved)")
+	fs.StringVar(&opts.hmacHeader, "hmac-header", "X-Signature", "Handshake header that carries the -hmac-secret signature")
+	fs.StringVar(&opts.hmacEncode, "hmac-encoding", "hex", "Encoding of the HMAC signature: hex or base64")
+	fs.StringVar(&opts.hashHeader, "payload-hash-header", "", "Send the hex SHA-256 of the payload in this handshake header, e.g. as an idempotency key; recomputed at every (re)dial, and the hash of any later send with different content is printed on stderr")
+}
+
+// traceFlags propagate a W3C trace context through the upgrade request.
+func traceFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.traceparent, "traceparent", "", "W3C traceparent header to send with the upgrade request")
+	fs.BoolVar(&opts.trace, "trace", false, "Generate a fresh traceparent for the upgrade request and print its trace id")
+	fs.BoolVar(&opts.traceSampled, "trace-sampled", true, "Set the sampled flag in the -trace traceparent")
+	fs.StringVar(&opts.traceField, "trace-field", "", "Also put the traceparent into this payload field (or JSON Pointer, e.g. /meta/traceparent)")
+}
+
+// readFlags registers -read-timeout with a per-command default.
+func readFlags(def time.Duration) flagGroup {
+	return func(fs *flag.FlagSet, opts *options) {
+		fs.DurationVar(&opts.readTimeout, "read-timeout", def, "How long to wait for responses (0 waits indefinitely)")
+	}
+}
+
+func completionFlags(fs *flag.FlagSet, opts *options) {
+	fs.DurationVar(&opts.quietPeriod, "quiet-period", 0, "Treat the stream as complete once no message has arrived for this long: close gracefully and exit 0, reporting the messages received (0 disables; with -first-message-timeout it starts at the first message)")
+}
+
+func sendFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.waitFor, "wait-for", "", "Delay sending until a received message matches path=value (or re:REGEX against the raw text)")
+	fs.DurationVar(&opts.waitTimeout, "wait-timeout", 10*time.Second, "How long to wait for the -wait-for message")
+	fs.DurationVar(&opts.firstMsgTimeout, "first-message-timeout", 0, "How long to wait from the send for the first response; -read-timeout then starts at that message. Exits 4 when nothing arrives in time (0 uses -read-timeout alone)")
+	fs.DurationVar(&opts.maxOpen, "max-open", 0, "Fail with exit code 5 if the connection is still open this long after the handshake, to check that the server closes promptly (0 disables)")
+	fs.StringVar(&opts.correlationField, "correlation-field", "", "Put a generated id in this payload field (or JSON Pointer, e.g. /meta/id) and stop once a response carrying the same id arrives")
+	fs.StringVar(&opts.messageType, "message-type", "text", "Frame type of the messages we send, whatever their content: text or binary")
+	fs.Var(&opts.dataFiles, "data-file", "Send the JSON document in this file; repeated, the objects are merged with later files overriding earlier keys and Name=Value pairs on top")
+	fs.StringVar(&opts.dataURL, "data-url", "", "Fetch the payload with an HTTP GET from this URL before connecting and send the body as is (2xx required; default HTTP client, so HTTP_PROXY/HTTPS_PROXY apply, and -insecure-skip-verify)")
+	fs.BoolVar(&opts.dataURLJSON, "data-url-json", false, "Fail unless the -data-url body is valid JSON")
+	fs.BoolVar(&opts.deepMerge, "deep-merge", false, "Merge nested objects of repeated -data-file documents key by key instead of replacing them whole")
+	fs.Var(&opts.sets, "set", "Set a payload value at a JSON Pointer, e.g. /meta/id=42 (repeatable; the value is JSON if it parses, else a string)")
+	fs.Var(&opts.transforms, "transform", "Before sending, set a value at a JSON Pointer in every outgoing message (payload, per connection with -connections, -churn, -chaos and -monitor, once for both -diff sides, -split-fields parts, -stdin-lines lines, -scenario sends, -watch-file edits), e.g. /seq={{seq}} or /ts={{unix_ms}}; {{now}}, {{unix_ms}} and {{seq}} (from 1) are filled in per message (repeatable)")
+	fs.Var(expectCountFlag{opts}, "expect-count", "Stop receiving once this many messages have arrived (0 waits for the read timeout); as MIN:MAX, receive until the read timeout and fail unless the number of messages received is in the range")
+	fs.Var(&opts.expectFields, "expect-field", "Fail the run unless some received message has this value at the JSON path (or JSON Pointer), as path=value; the value is typed JSON when it parses (42, true, \"42\" for the string), else a string (repeatable; all must hold)")
+	fs.IntVar(&opts.maxSends, "max-sends", 0, "Stop sending after this many messages in total, from any source, and only receive from then on (0 is unlimited)")
+	fs.StringVar(&opts.scenario, "scenario", "", "Run a send/expect script instead of the Name=Value payload ('>' lines are sent, '<' lines are expected substrings)")
+	fs.BoolVar(&opts.splitFields, "split-fields", false, "Send every Name=Value pair as its own message ({\"name\":\"value\"}), in command-line order, instead of one combined object")
+	fs.DurationVar(&opts.messageInterval, "message-interval", 0, "With -split-fields, wait this long between consecutive messages")
+	fs.BoolVar(&opts.stdinLines, "stdin-lines", false, "Send every line read from stdin as a message instead of the Name=Value payload, printing responses meanwhile")
+	fs.StringVar(&opts.inputFIFO, "input-fifo", "", "Keep the connection open and send every JSON line other processes write to this named pipe (reopened as writers come and go; invalid lines are counted and skipped); Name=Value data, if given, is sent first")
+	fs.StringVar(&opts.targetsFile, "targets-file", "", "Send the payload to every server listed in this file (one \"URL PATH\" or full ws:// URL per line, # for comments) at once instead of -url, and print a per-target summary")
+	fs.StringVar(&opts.diffSpec, "diff", "", "Also send the payload to this second endpoint (ws:// URL, -path if it has none) and print a JSON diff of the two first (or -correlation-field matched) responses; exits 1 when they differ")
+	fs.Var(&opts.diffIgnore, "diff-ignore", "With -diff, paths to leave out of the comparison, e.g. ts,meta.id or /items/*/id (repeatable, comma-separated)")
+	fs.IntVar(&opts.maxInflight, "max-inflight", 0, "With -stdin-lines, stop reading stdin while this many lines are unanswered (matched by -correlation-field, else in order) (0 is unlimited)")
+	fs.Var(sizeFlag{&opts.maxInflightBytes}, "max-inflight-bytes", "With -stdin-lines, stop reading stdin while this many bytes sent, e.g. 256k, are not acknowledged by the server's TCP stack yet, whether or not it answers the lines (Linux only; 0 is unlimited)")
+	fs.DurationVar(&opts.sendIdleTimeout, "send-idle-timeout", 0, "Close the connection gracefully once nothing has been sent for this long, e.g. a forgotten -stdin-lines session (0 disables; heartbeats do not count)")
+	fs.Var(&opts.captures, "capture", "NAME=path: keep the first value found at path in a received message (repeatable); {{capture.NAME}} in the payload or -scenario sends is replaced by it, and such a send waits until it is captured")
+	fs.DurationVar(&opts.captureTimeout, "capture-timeout", 10*time.Second, "How long a send waits for a -capture value it refers to")
+	fs.StringVar(&opts.captureFile, "capture-file", "", "Write the -capture values to this file as NAME=value lines (dotenv format) whenever one arrives, for the next command of a script to source")
+	fs.StringVar(&opts.resumePayload, "resume-payload", "", "On -reconnect, send this message (with {{capture.NAME}} filled in) instead of the payload once the captures it uses are populated")
+	fs.StringVar(&opts.sendAtSpec, "send-at", "", "Hold the send until this RFC 3339 time once connected, printing messages the server pushes meanwhile (a past time sends at once with a warning)")
+}
+
+func watchFlags(fs *flag.FlagSet, opts *options) {
+	fs.DurationVar(&opts.watch, "watch", 0, "Repeat the send/receive cycle every INTERVAL until interrupted (0 sends once)")
+	fs.BoolVar(&opts.watchRedial, "watch-redial", false, "With -watch, open a new connection for every cycle instead of reusing one")
+	fs.IntVar(&opts.watchCount, "watch-count", 0, "With -watch, stop after this many cycles (0 repeats until interrupted)")
+	fs.BoolVar(&opts.watchFile, "watch-file", false, "Keep the connection open and re-send -data-file whenever it changes, until interrupted")
+}
+
+func heartbeatFlags(fs *flag.FlagSet, opts *options) {
+	fs.DurationVar(&opts.heartbeatInterval, "heartbeat-interval", 0, "Send -heartbeat-payload at this interval to keep the session alive (0 disables)")
+	fs.StringVar(&opts.heartbeatPayload, "heartbeat-payload", `{"type":"ping"}`, "JSON message sent as the heartbeat; {{now}} (RFC 3339 time), {{unix_ms}} and {{seq}} (from 1) are replaced on every send")
+	fs.BoolVar(&opts.heartbeatIdleOnly, "heartbeat-idle-only", false, "Only send a heartbeat when nothing else was sent during the last interval")
+	fs.StringVar(&opts.heartbeatSend, "heartbeat-send", "", `Send this text as the heartbeat instead of -heartbeat-payload, e.g. "ping" (placeholders as in -heartbeat-payload)`)
+	fs.StringVar(&opts.heartbeatExpect, "heartbeat-expect", "", `Reply the server gives to each heartbeat, as exact text such as "pong" (or re:REGEX); replies are hidden from the output and a heartbeat without one before the next is due counts as missed`)
+	fs.IntVar(&opts.heartbeatMisses, "heartbeat-misses", 2, "With -heartbeat-expect, drop the connection after this many heartbeats in a row are missed; -reconnect then dials again, otherwise the run fails")
+	fs.BoolVar(&opts.heartbeatShow, "heartbeat-show", false, "With -heartbeat-expect, print heartbeat replies like other messages")
+}
+
+func reconnectFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.reconnect, "reconnect", false, "Reconnect (and resend the payload) when the server closes or the connection drops")
+	fs.DurationVar(&opts.reconnectDelay, "reconnect-delay", time.Second, "Initial delay before reconnecting; doubles after each consecutive failure")
+	fs.DurationVar(&opts.reconnectMaxDelay, "reconnect-max-delay", 30*time.Second, "Upper bound for the reconnect delay")
+	fs.IntVar(&opts.reconnectMax, "reconnect-max", 0, "Give up after this many reconnect attempts (0 retries forever)")
+	fs.BoolVar(&opts.retryJitter, "retry-jitter", false, "Randomize each reconnect and -forward-url retry delay between 0 and its backoff value (full jitter)")
+	fs.Int64Var(&opts.seed, "seed", 0, "Seed for -retry-jitter, for reproducible delays (0 picks a random seed)")
+	fs.Var(&opts.reconnectCodes, "reconnect-on-codes", "Only reconnect for these close codes, e.g. 1006,1011 (implies -reconnect; other codes end the run cleanly)")
+	fs.IntVar(&opts.retryAfterAttempts, "retry-after-attempts", 3, "When the server sheds load with a -retry-after-codes close, redial and resend after the reconnect backoff up to this many times, even without -reconnect; exit 3 once exhausted (0 disables)")
+	opts.retryAfterCodes = codeList{websocket.CloseTryAgainLater}
+	fs.Var(&opts.retryAfterCodes, "retry-after-codes", "Close codes that mean \"try again later\" for -retry-after-attempts, e.g. 1012,1013")
+}
+
+func monitorFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.monitor, "monitor", false, "Hold the connection open, log every disconnect and reconnect, and report drops and uptime at the end")
+	fs.DurationVar(&opts.monitorPing, "monitor-ping-interval", 30*time.Second, "With -monitor, ping the server at this interval; a missing pong counts as a drop")
+	fs.DurationVar(&opts.maxDuration, "max-duration", 0, "With -monitor, -chaos or -input-fifo, stop after this long (0 runs until interrupted)")
+}
+
+func chaosFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.chaos, "chaos", false, "Misbehave on purpose: randomly drop the connection, close it abruptly or go silent, then reconnect and resend; report the faults and the server's reactions at the end")
+	fs.DurationVar(&opts.chaosInterval, "chaos-interval", 10*time.Second, "With -chaos, consider injecting a fault this often")
+	fs.Float64Var(&opts.chaosProbability, "chaos-probability", 0.5, "With -chaos, chance (0-1] that a fault is injected at each -chaos-interval")
+	fs.StringVar(&opts.chaosMixSpec, "chaos-mix", "drop=1,close=1,silent=1", "With -chaos, relative weights of the faults: drop (no close frame), close (abrupt close frame) and silent (ignore pings)")
+	fs.DurationVar(&opts.chaosSilence, "chaos-silence", 30*time.Second, "With -chaos, how long a silent fault ignores pings and sends nothing")
+	fs.Int64Var(&opts.chaosSeed, "chaos-seed", 0, "Seed for the -chaos faults and their timing, for reproducible runs (0 picks a random seed)")
+}
+
+// churnFlags turn the session into a connect/disconnect loop.
+func churnFlags(fs *flag.FlagSet, opts *options) {
+	fs.IntVar(&opts.churn, "churn", 0, "Connect, send the payload, read -expect-count messages and close cleanly this many times (or until -max-duration), then report connect times and failed cycles")
+	fs.IntVar(&opts.churnParallel, "churn-parallel", 1, "With -churn, run this many connect/disconnect loops at once")
+	fs.BoolVar(&opts.failFast, "fail-fast", false, "With -churn, stop at the first failed cycle")
+}
+
+func strictFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolVar(&opts.strict, "strict", false, "Fail on any protocol anomaly: a refused handshake, a close code other than 1000, a text message that is not JSON or a message of the wrong type")
+	fs.StringVar(&opts.expectType, "expect-type", "text", "Message type -strict expects from the server: text or binary")
+}
+
+func outputFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.format, "format", "pretty", "How received messages are printed: pretty (indented, with recv:), raw (as received, one per line) or ndjson (compact JSON, one per line)")
+	fs.StringVar(&opts.outputTemplate, "output-template", "", "Print each shown message with this Go text/template instead of -format; fields: .Timestamp, .Index, .Type, .Size, .Raw and .JSON (the parsed document, e.g. {{.JSON.data.id}}); {{json X}} renders X as JSON")
+	fs.StringVar(&opts.outputPath, "output", "", "Write the printed messages to this file instead of stdout; gzip-compressed when the name ends in .gz or with -output-compress")
+	fs.StringVar(&opts.tee, "tee", "", "Also write every shown message to this file as compact ndjson (one JSON value per line, as -format ndjson), alongside the regular output")
+	fs.BoolVar(&opts.outputCompress, "output-compress", false, "Gzip-compress the -output file whatever its name")
+	fs.DurationVar(&opts.outputFlush, "output-flush-interval", time.Second, "Flush the compressed -output stream this often so the file stays readable during the run (0 only at the end)")
+	fs.DurationVar(&opts.backpressureWarn, "backpressure-warn", 0, "Log on stderr when a message is printed (or written to -output, -pipe, -demux-dir) more than this long after it was read, i.e. output cannot keep up, and summarize the lag at the end (0 disables)")
+	fs.BoolVar(&opts.sortKeys, "sort-keys", false, "With -format pretty or ndjson, print JSON messages with their object keys sorted, for output that diffs cleanly across runs (-format raw stays as received)")
+	fs.Var(&opts.expandStrings, "expand-json-strings", "In the pretty format, show string values holding a JSON object or array as that document, indented in place and marked /* json string */; =PATHS limits this to comma-separated paths (* matches any key). The -output file keeps the strings as received")
+	fs.BoolVar(&opts.noSanitize, "no-sanitize", false, "Print messages to a terminal as received; by default control characters and ANSI escape sequences are shown escaped (\\x1b[...) when stdout is a terminal")
+	fs.BoolVar(&opts.noNewline, "no-newline", false, "With -format raw or ndjson, do not end each message with a newline")
+	fs.BoolVar(&opts.nullDelimited, "null-delimited", false, "With -format raw or ndjson, end each message with a NUL byte instead of a newline (for xargs -0)")
+	fs.Var(truncateFlag{&opts.truncate}, "truncate", "Truncate long string values to the terminal width (or -truncate=N columns) with an ellipsis")
+	fs.StringVar(&opts.timeField, "time-field", "", "JSON path of a timestamp field; with -since/-until only messages inside the window are printed")
+	fs.StringVar(&opts.timeFormat, "time-format", time.RFC3339, "Layout of -time-field values (Go time layout, or unix / unixms)")
+	fs.StringVar(&opts.since, "since", "", "Print only messages with -time-field at or after this RFC 3339 time (or duration ago, e.g. 10m)")
+	fs.StringVar(&opts.until, "until", "", "Print only messages with -time-field at or before this RFC 3339 time (or duration ago)")
+	fs.BoolVar(&opts.lastOnly, "last-only", false, "Print only the last shown message, once the connection has closed or timed out")
+	fs.BoolVar(&opts.first, "first", false, "Print only the first message (the first one -filter lets through), as received, on stdout (everything else goes to stderr), then close and exit; exits non-zero if none arrives before -read-timeout")
+	fs.Var(sizeFlag{&opts.maxRecvBytes}, "max-recv-bytes", "Once the received payloads add up to this many bytes, e.g. 50m, finish the current message, close gracefully and exit 0 (0 is unlimited)")
+	fs.StringVar(&opts.har, "har", "", "At the end of the run, write the session as a HAR file (upgrade request and response, and every message with its time and direction in _webSocketMessages; credentials redacted)")
+	fs.BoolVar(&opts.showCompression, "show-compression", false, "Note on stderr whether each shown message arrived compressed (permessage-deflate, see -compress), and summarize per connection")
+	fs.StringVar(&opts.pipe, "pipe", "", "Stream received messages, one per line, to the stdin of this command (e.g. \"jq .data\") instead of printing them")
+	fs.BoolVar(&opts.stats, "stats", false, "Print handshake time, time to first byte and to first message, and message counts to stderr at the end")
+	fs.Var(&opts.decodeFields, "decode-field", "Base64-decode the string at this JSON path (or JSON Pointer) of received messages, gunzip it if gzipped, and show the JSON document it holds in its place (repeatable; values that do not decode are left as is with a warning)")
+	fs.StringVar(&opts.filterSpec, "filter", "", "Show only messages matching path=value (or re:REGEX against the raw text)")
+	fs.StringVar(&opts.exec, "exec", "", "Run this command for every shown message, with the message on stdin and POSTWS_MSG_INDEX/POSTWS_MSG_TIME/POSTWS_MSG_TYPE set")
+	fs.IntVar(&opts.execConcurrency, "exec-concurrency", 4, "Maximum number of -exec commands running at once")
+	fs.IntVar(&opts.execQueue, "exec-queue", 100, "Messages waiting for a free -exec slot; further messages skip the command")
+	fs.BoolVar(&opts.execFailFast, "exec-fail-fast", false, "End the session when an -exec command fails")
+	fs.StringVar(&opts.forwardURL, "forward-url", "", "POST every shown message to this HTTP endpoint (body as received)")
+	fs.Var(&opts.forwardHeaders, "forward-header", "Extra header for -forward-url requests as \"Name: Value\" (repeatable)")
+	fs.IntVar(&opts.forwardRetries, "forward-retries", 3, "Retries for a failed -forward-url request")
+	fs.DurationVar(&opts.forwardDelay, "forward-retry-delay", 500*time.Millisecond, "Delay before the first -forward-url retry; doubles after each one")
+	fs.IntVar(&opts.forwardQueue, "forward-queue", 100, "Messages buffered for -forward-url while the endpoint is slow")
+	fs.StringVar(&opts.forwardPolicy, "forward-policy", "drop", "What to do when the -forward-queue is full: drop new messages or block reading")
+	fs.StringVar(&opts.demuxField, "demux-field", "", "Split a multiplexed stream by this JSON path (or JSON Pointer): label each message with its value, or write per-value files with -demux-dir")
+	fs.StringVar(&opts.demuxDir, "demux-dir", "", "With -demux-field, append each message to DIR/VALUE.jsonl instead of printing it")
+	fs.StringVar(&opts.binaryDir, "binary-dir", "", "Write each received binary message to a numbered file in this directory instead of printing it")
+}
+
+func metricsFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.metricsListen, "metrics-listen", "", "Serve Prometheus metrics on /metrics at this address (e.g. :9090) while running")
+	fs.DurationVar(&opts.statsInterval, "stats-interval", 0, "Every INTERVAL, write a JSON checkpoint of the counters, latency percentiles and memory use for that window (to -events-json, else stderr)")
+	fs.BoolVar(&opts.sizeStats, "size-stats", false, "At the end, print the distribution of received and sent payload sizes to stderr: count, bytes, min/median/p95/max and counts per -size-buckets range (also in -stats-file and -stats-interval checkpoints)")
+	fs.Var(&opts.sizeBuckets, "size-buckets", "Boundaries of the payload size ranges, ascending and comma-separated (default 1k,16k,256k: 0-1k, 1k-16k, 16k-256k, 256k+, each including its lower bound)")
+	fs.StringVar(&opts.statsFile, "stats-file", "", "At the end of the run, successful or not, write a JSON summary (timings, counters, latency percentiles, reconnects, errors, close code, exit code) to this file")
+}
+
+func serveFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.listenAddr, "listen", ":8080", "Address to listen on")
+	fs.StringVar(&opts.path, "path", "/ws", "Path that accepts WebSocket upgrades")
+	fs.BoolVar(&opts.serveEcho, "echo", true, "Send every received message back with the same type")
+	fs.StringVar(&opts.serveGreeting, "greeting", "", "Text message sent to every new connection")
+	fs.DurationVar(&opts.serveStream, "stream-interval", 0, "Push a -stream-message to every connection at this interval (0 disables)")
+	fs.StringVar(&opts.serveStreamMsg, "stream-message", defaultStreamMessage, "Template for streamed messages; {seq} and {time} are replaced")
+	fs.BoolVar(&opts.serveBinary, "stream-binary", false, "Stream binary instead of text messages")
+	fs.IntVar(&opts.serveCloseAfter, "close-after", 0, "Close each connection after receiving this many messages (0 never)")
+	fs.IntVar(&opts.serveCloseCode, "close-code", websocket.CloseNormalClosure, "Close code used by -close-after (1006 drops the connection without a close frame)")
+	fs.StringVar(&opts.serveCloseReason, "close-reason", "", "Close reason used by -close-after")
+}
+
+func tapFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.listenAddr, "listen", ":9000", "Address to accept client connections on")
+	fs.StringVar(&opts.tapRecord, "record", "", "Also write every relayed frame as a JSON line to this file")
+	fs.BoolVar(&opts.noSanitize, "no-sanitize", false, "Print frames to a terminal as relayed; by default control characters and ANSI escape sequences are shown escaped on a terminal (-record is never altered)")
+	fs.Var(&opts.expandStrings, "expand-json-strings", "Print JSON frames indented, with string values holding a JSON object or array shown as that document and marked /* json string */; =PATHS limits this to comma-separated paths. The -record file keeps the frames as relayed")
+}
+
+func pingFlags(fs *flag.FlagSet, opts *options) {
+	fs.IntVar(&opts.pingCount, "count", 4, "Number of pings to send (0 pings until interrupted)")
+	fs.DurationVar(&opts.pingInterval, "interval", time.Second, "Delay between pings")
+}
+
+// parallelFlags run the send flow on several connections at once.
+func parallelFlags(fs *flag.FlagSet, opts *options) {
+	fs.IntVar(&opts.connections, "connections", 1, "Run the send/receive flow on this many connections at once; {{conn}} in the payload becomes the connection index")
+	fs.DurationVar(&opts.ramp, "ramp-up", 0, "With -connections, spread opening the connections evenly over this period")
+}
+
+func throughputFlags(fs *flag.FlagSet, opts *options) {
+	opts.payloadSize = 1 << 10
+	fs.Var(sizeFlag{&opts.payloadSize}, "payload-size", "Size of every frame, e.g. 512, 64k or 1m")
+	fs.StringVar(&opts.payloadFill, "payload-fill", "random", "Frame content: random or zero")
+	fs.BoolVar(&opts.binary, "binary", false, "Send binary frames instead of text frames")
+	fs.IntVar(&opts.connections, "connections", 1, "Number of concurrent connections")
+	fs.DurationVar(&opts.benchDuration, "duration", 10*time.Second, "How long to keep sending")
+	fs.Float64Var(&opts.rate, "rate", 0, "Send this many frames per second across all connections (0 sends as fast as possible)")
+}
+
+func benchFlags(fs *flag.FlagSet, opts *options) {
+	fs.IntVar(&opts.connections, "connections", 10, "Number of concurrent connections")
+	fs.DurationVar(&opts.benchDuration, "duration", 10*time.Second, "How long to keep sending")
+	fs.DurationVar(&opts.ramp, "ramp", 0, "Spread opening the connections evenly over this period instead of opening them all at once")
+	fs.IntVar(&opts.maxSends, "max-sends", 0, "Stop sending after this many messages in total across all connections (0 is unlimited)")
+	fs.Float64Var(&opts.rate, "rate", 0, "Send at this many messages per second across all connections instead of waiting for each response (0 sends as fast as responses arrive)")
+	fs.BoolVar(&opts.benchJSON, "json", false, "Print the summary as JSON")
+	fs.StringVar(&opts.correlationField, "correlation-field", "", "Put a fresh id in this payload field (or JSON Pointer, e.g. /meta/id) of every send and take the message carrying it as the response; without it the response must echo the payload. Other messages, e.g. a greeting, are counted as unmatched")
+}
+
+// knownFlag reports whether any subcommand defines the flag, so config
+// profiles can be shared between subcommands.
+func knownFlag(name string) bool {
+	for _, cmd := range commands {
+		if cmd.newFlagSet(&options{}).Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *command) newFlagSet(opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	for _, g := range cmd.groups {
+		g(fs, opts)
+	}
+	return fs
+}
+
+// parseFlags parses the subcommand (defaulting to send for the historical
+// flag-only invocation) and its flags.
+func parseFlags(args []string) (options, error) {
+	var opts options
+
+	cmd := lookupCommand("send")
+	legacy := true
+	if len(args) > 0 {
+		if c := lookupCommand(args[0]); c != nil {
+			cmd, legacy, args = c, false, args[1:]
+		}
+	}
+	opts.command = cmd.name
+
+	fs := cmd.newFlagSet(&opts)
+	fs.Usage = func() {
+		out := fs.Output()
+		if legacy {
+			printCommands(out)
+			fmt.Fprintf(out, "\nWithout a subcommand %s behaves like \"%s send\":\n\n", os.Args[0], os.Args[0])
+		}
+		fmt.Fprintf(out, "Usage: %s %s [-profile NAME] %s\n", os.Args[0], cmd.name, cmd.synopsis)
+		printVisibleDefaults(fs)
+		if fs.Lookup("metrics-listen") != nil {
+			printMetricDocs(out)
+		}
+		fmt.Fprintf(out, "\nEvery flag can also be set through %s<NAME> (e.g. POSTWS_READ_TIMEOUT); precedence is flag > environment > profile > default.\n", envPrefix)
+	}
+	opts.usage = fs.Usage
+
+	// Every problem is collected so -lint can report them all; other runs
+	// report the first, where parsing used to stop.
+	var problems []error
+	fail := func(err error) { problems = append(problems, err) }
+
+	if lintRequested(args) {
+		// A malformed flag is one more problem for -lint to report, so
+		// parsing resumes after it instead of exiting.
+		fs.Init(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		for err := fs.Parse(args); err != nil; err = fs.Parse(fs.Args()) {
+			if errors.Is(err, flag.ErrHelp) {
+				fs.SetOutput(nil)
+				fs.Usage()
+				os.Exit(0)
+			}
+			fail(err)
+		}
+	} else {
+		_ = fs.Parse(args)
+	}
+	if cmd.name == "version" {
+		opts.showVersion = true
+	}
+	if opts.showVersion {
+		return collected(opts, problems)
+	}
+	if legacy && !opts.listProfiles {
+		fmt.Fprintf(os.Stderr, "hint: running without a subcommand is deprecated; use \"%s send ...\"\n", os.Args[0])
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	fromEnv, err := applyEnv(fs, explicit)
+	if err != nil {
+		fail(err)
+	}
+	opts.fromEnv = fromEnv
+
+	if opts.listProfiles {
+		return collected(opts, problems)
+	}
+	if opts.profile != "" {
+		if err := loadProfile(fs, opts.configPath, opts.profile, explicit); err != nil {
+			fail(err)
+		}
+	}
+
+	if cmd.name == "serve" {
+		if fs.NArg() > 0 {
+			fail(fmt.Errorf("serve does not take arguments (got %q)", fs.Arg(0)))
+		}
+		if !strings.HasPrefix(opts.path, "/") {
+			fail(fmt.Errorf("-path must start with /"))
+		}
+		if opts.serveCloseCode < 1000 || opts.serveCloseCode > 4999 {
+			fail(fmt.Errorf("-close-code %d is out of range (1000-4999)", opts.serveCloseCode))
+		}
+		return collected(opts, problems)
+	}
+
+	if opts.printHandshake {
+		opts.dryRun = true
+	}
+	if opts.check && opts.dryRun {
+		fail(fmt.Errorf("-check connects, so it cannot be combined with -dry-run or -print-handshake"))
+	}
+
+	if opts.trace && opts.traceparent != "" {
+		fail(fmt.Errorf("-trace and -traceparent are mutually exclusive"))
+	}
+	if opts.traceparent != "" {
+		if err := checkTraceparent(opts.traceparent); err != nil {
+			fail(err)
+		}
+	}
+	if opts.trace {
+		opts.traceparent = newTraceparent(opts.traceSampled)
+	}
+	if opts.traceField != "" && opts.traceparent == "" {
+		fail(fmt.Errorf("-trace-field needs -trace or -traceparent"))
+	}
+
+	if opts.targetsFile != "" {
+		if opts.baseURL != "" {
+			fail(fmt.Errorf("-targets-file and -url are mutually exclusive"))
+		}
+		if opts.targets, err = loadTargets(opts.targetsFile, opts.path); err != nil {
+			fail(err)
+		} else {
+			// The first target stands in for -url where a single URL is needed.
+			opts.baseURL, opts.path = opts.targets[0].baseURL, opts.targets[0].path
+		}
+	}
+	if opts.baseURL == "" && opts.targetsFile == "" {
+		fail(fmt.Errorf("-url is required"))
+	}
+	if opts.path == "" {
+		fail(fmt.Errorf("-path is required"))
+	}
+
+	if opts.diffSpec != "" {
+		if opts.diffTarget, err = diffTarget(opts.diffSpec, opts.path); err != nil {
+			fail(err)
+		}
+	} else if len(opts.diffIgnore) > 0 {
+		fail(fmt.Errorf("-diff-ignore needs -diff"))
+	}
+
+	if opts.dnsServer != "" {
+		if _, _, err := net.SplitHostPort(opts.dnsServer); err != nil {
+			fail(fmt.Errorf("invalid -dns-server %q (want host:port): %w", opts.dnsServer, err))
+		}
+	}
+
+	if opts.wsKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(opts.wsKey); err != nil || len(key) != 16 {
+			fail(fmt.Errorf("invalid -ws-key %q (want base64 of 16 bytes)", opts.wsKey))
+		}
+	}
+	if opts.hmacSecret != "" {
+		if opts.hmacHeader == "" {
+			fail(fmt.Errorf("-hmac-header must not be empty with -hmac-secret"))
+		}
+		if opts.hmacEncode != "hex" && opts.hmacEncode != "base64" {
+			fail(fmt.Errorf("unsupported -hmac-encoding %q (use hex or base64)", opts.hmacEncode))
+		}
+		if opts.hmacSecret, err = resolveSecret(opts.hmacSecret); err != nil {
+			fail(fmt.Errorf("-hmac-secret: %w", err))
+		}
+	}
+
+	if strings.ContainsAny(opts.hashHeader, " :\t\r\n") {
+		fail(fmt.Errorf("invalid -payload-hash-header %q (want a header name)", opts.hashHeader))
+	}
+	if opts.hashHeader != "" && opts.hashHeader == opts.hmacHeader && opts.hmacSecret != "" {
+		fail(fmt.Errorf("-payload-hash-header and -hmac-header must differ"))
+	}
+
+	if (opts.since != "" || opts.until != "") && opts.timeField == "" {
+		fail(fmt.Errorf("-since/-until require -time-field"))
+	}
+	if opts.timeField != "" {
+		if opts.window, err = newTimeWindow(opts.timeField, opts.timeFormat, opts.since, opts.until, time.Now()); err != nil {
+			fail(err)
+		}
+	}
+
+	if opts.outputFlush < 0 {
+		fail(fmt.Errorf("-output-flush-interval must not be negative"))
+	}
+	if opts.backpressureWarn < 0 {
+		fail(fmt.Errorf("-backpressure-warn must not be negative"))
+	}
+	// Before the format checks, which apply to the format -first picks.
+	if opts.first {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["expect-count"] || opts.lastOnly {
+			fail(fmt.Errorf("-first cannot be combined with -expect-count or -last-only"))
+		}
+		opts.expectCount = 1
+		if !set["format"] {
+			opts.format = "raw"
+		}
+	}
+	if opts.sortKeys && (opts.format == "raw" || opts.outputTemplate != "") {
+		fail(fmt.Errorf("-sort-keys needs -format pretty or ndjson"))
+	}
+	if len(opts.sizeBuckets) > 0 && !opts.sizeStats && opts.statsFile == "" && opts.statsInterval == 0 {
+		fail(fmt.Errorf("-size-buckets needs -size-stats, -stats-file or -stats-interval"))
+	}
+	if opts.tee != "" && opts.tee == opts.outputPath {
+		fail(fmt.Errorf("-tee and -output must be different files"))
+	}
+	if opts.outputCompress && opts.outputPath == "" {
+		fail(fmt.Errorf("-output-compress needs -output"))
+	}
+	if opts.outputTemplate != "" {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if set["format"] || opts.pipe != "" {
+			fail(fmt.Errorf("-output-template cannot be combined with -format or -pipe"))
+		}
+		if opts.template, err = parseOutputTemplate(opts.outputTemplate); err != nil {
+			fail(err)
+		}
+	}
+	switch opts.format {
+	case "", "pretty": // "" for commands without -format
+		if (opts.noNewline || opts.nullDelimited) && opts.template == nil {
+			fail(fmt.Errorf("-no-newline and -null-delimited need -format raw or ndjson, or -output-template"))
+		}
+	case "raw", "ndjson":
+		if opts.noNewline && opts.nullDelimited {
+			fail(fmt.Errorf("-no-newline and -null-delimited are mutually exclusive"))
+		}
+	default:
+		fail(fmt.Errorf("unsupported -format %q (use pretty, raw or ndjson)", opts.format))
+	}
+
+	if opts.demuxDir != "" && opts.demuxField == "" {
+		fail(fmt.Errorf("-demux-dir needs -demux-field"))
+	}
+	if opts.demuxField != "" && opts.pipe != "" {
+		fail(fmt.Errorf("-demux-field and -pipe are mutually exclusive"))
+	}
+	if opts.lastOnly && (opts.pipe != "" || opts.demuxField != "") {
+		fail(fmt.Errorf("-last-only cannot be combined with -pipe or -demux-field"))
+	}
+
+	if opts.filterSpec != "" {
+		if opts.filter, err = parseCondition("filter", opts.filterSpec); err != nil {
+			fail(err)
+		}
+	}
+	if opts.exec != "" && (opts.execConcurrency < 1 || opts.execQueue < 0) {
+		fail(fmt.Errorf("-exec-concurrency must be at least 1 and -exec-queue not negative"))
+	}
+
+	if opts.forwardURL != "" {
+		if u, err := url.Parse(opts.forwardURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(fmt.Errorf("invalid -forward-url %q (want http:// or https:// URL)", opts.forwardURL))
+		}
+		if opts.forwardPolicy != "drop" && opts.forwardPolicy != "block" {
+			fail(fmt.Errorf("unsupported -forward-policy %q (use drop or block)", opts.forwardPolicy))
+		}
+		if opts.forwardRetries < 0 || opts.forwardQueue < 0 || opts.forwardDelay < 0 {
+			fail(fmt.Errorf("-forward-retries, -forward-queue and -forward-retry-delay must not be negative"))
+		}
+	}
+
+	if opts.heartbeatInterval > 0 && opts.heartbeatSend == "" && !json.Valid(heartbeatMessage(opts.heartbeatPayload, 1, time.Now())) {
+		fail(fmt.Errorf("-heartbeat-payload is not valid JSON: %s", opts.heartbeatPayload))
+	}
+	if opts.heartbeatSend != "" || opts.heartbeatExpect != "" || opts.heartbeatShow {
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		switch {
+		case opts.heartbeatInterval <= 0:
+			fail(fmt.Errorf("-heartbeat-send, -heartbeat-expect and -heartbeat-show need -heartbeat-interval"))
+		case opts.heartbeatSend != "" && set["heartbeat-payload"]:
+			fail(fmt.Errorf("-heartbeat-send and -heartbeat-payload are mutually exclusive"))
+		case opts.heartbeatShow && opts.heartbeatExpect == "":
+			fail(fmt.Errorf("-heartbeat-show needs -heartbeat-expect"))
+		}
+	}
+	if opts.heartbeatInterval > 0 && opts.heartbeatMisses < 1 {
+		fail(fmt.Errorf("-heartbeat-misses must be at least 1"))
+	}
+	if expr, ok := strings.CutPrefix(opts.heartbeatExpect, "re:"); ok {
+		if opts.heartbeatExpectRe, err = regexp.Compile(expr); err != nil {
+			fail(fmt.Errorf("-heartbeat-expect: %v", err))
+		}
+	}
+
+	if opts.monitor {
+		if opts.monitorPing <= 0 {
+			fail(fmt.Errorf("-monitor-ping-interval must be positive"))
+		}
+		if opts.watch > 0 || opts.watchFile || opts.scenario != "" || opts.reconnect || len(opts.reconnectCodes) > 0 {
+			fail(fmt.Errorf("-monitor reconnects by itself and cannot be combined with -watch, -watch-file, -scenario or -reconnect"))
+		}
+	}
+	if opts.chaos {
+		if opts.monitor || opts.watch > 0 || opts.watchFile || opts.scenario != "" || opts.reconnect || len(opts.reconnectCodes) > 0 || opts.stdinLines || opts.connections > 1 {
+			fail(fmt.Errorf("-chaos reconnects by itself and cannot be combined with -monitor, -watch, -watch-file, -scenario, -reconnect, -stdin-lines or -connections"))
+		}
+		if opts.chaosInterval <= 0 || opts.chaosSilence <= 0 {
+			fail(fmt.Errorf("-chaos-interval and -chaos-silence must be positive"))
+		}
+		if opts.chaosProbability <= 0 || opts.chaosProbability > 1 {
+			fail(fmt.Errorf("-chaos-probability must be greater than 0 and at most 1"))
+		}
+		if opts.chaosMix, err = parseChaosMix(opts.chaosMixSpec); err != nil {
+			fail(err)
+		}
+	}
+	if opts.maxDuration > 0 && !opts.monitor && !opts.chaos && opts.inputFIFO == "" && opts.churn == 0 {
+		fail(fmt.Errorf("-max-duration needs -monitor, -chaos, -churn or -input-fifo"))
+	}
+
+	if opts.expectType != "" && opts.expectType != "text" && opts.expectType != "binary" {
+		fail(fmt.Errorf("unsupported -expect-type %q (use text or binary)", opts.expectType))
+	}
+	if opts.messageType != "" && opts.messageType != "text" && opts.messageType != "binary" {
+		fail(fmt.Errorf("unsupported -message-type %q (use text or binary)", opts.messageType))
+	}
+
+	if opts.maxSends < 0 {
+		fail(fmt.Errorf("-max-sends must not be negative"))
+	}
+
+	if opts.maxSendRate > 0 {
+		opts.sendLimit = client.NewRateLimiter(opts.maxSendRate)
+	}
+	if opts.readRate > 0 {
+		opts.recvLimit = client.NewRateLimiter(opts.readRate)
+	}
+
+	if opts.retryJitter {
+		opts.jitter = newJitterSource(opts.seed)
+	} else if opts.seed != 0 {
+		fail(fmt.Errorf("-seed needs -retry-jitter"))
+	}
+
+	if len(opts.reconnectCodes) > 0 {
+		opts.reconnect = true
+	}
+	if opts.sendAtSpec != "" {
+		if opts.sendAt, err = time.Parse(time.RFC3339, opts.sendAtSpec); err != nil {
+			fail(fmt.Errorf("invalid -send-at %q (want an RFC 3339 time such as 2026-01-02T15:04:05Z)", opts.sendAtSpec))
+		}
+	}
+	if opts.pingPayloadSpec != "" {
+		if opts.pingPayload, err = parsePingPayload(opts.pingPayloadSpec); err != nil {
+			fail(err)
+		}
+	}
+	if opts.captureFile != "" {
+		if len(opts.captures) == 0 {
+			fail(fmt.Errorf("-capture-file needs -capture"))
+		}
+		for _, spec := range opts.captures {
+			if name, _, _ := strings.Cut(spec, "="); !shellName.MatchString(name) {
+				fail(fmt.Errorf("-capture-file: %q is not a valid variable name (letters, digits and _, not starting with a digit)", name))
+			}
+		}
+	}
+	if opts.resumePayload != "" {
+		if !opts.reconnect {
+			fail(fmt.Errorf("-resume-payload needs -reconnect"))
+		}
+		if len(opts.captures) == 0 {
+			fail(fmt.Errorf("-resume-payload needs -capture"))
+		}
+		if opts.scenario != "" || opts.stdinLines {
+			fail(fmt.Errorf("-resume-payload cannot be combined with -scenario or -stdin-lines"))
+		}
+	}
+	if opts.retryAfterAttempts < 0 {
+		fail(fmt.Errorf("-retry-after-attempts must not be negative"))
+	}
+	if (opts.reconnect || opts.retryAfterAttempts > 0) && opts.reconnectDelay <= 0 {
+		fail(fmt.Errorf("-reconnect-delay must be positive"))
+	}
+
+	if cmd.name == "send" && opts.connections != 1 {
+		if opts.connections < 1 {
+			fail(fmt.Errorf("-connections must be at least 1"))
+		}
+		if opts.scenario != "" || opts.watch > 0 || opts.watchFile || opts.reconnect || opts.monitor || opts.waitFor != "" || opts.correlationField != "" {
+			fail(fmt.Errorf("-connections cannot be combined with -scenario, -watch, -watch-file, -reconnect, -monitor, -wait-for or -correlation-field"))
+		}
+	}
+	if cmd.name == "send" && opts.ramp < 0 {
+		fail(fmt.Errorf("-ramp-up must not be negative"))
+	}
+	if cmd.name == "bench" && opts.connections < 1 {
+		fail(fmt.Errorf("-connections must be at least 1"))
+	}
+	if cmd.name == "throughput" {
+		if opts.payloadSize < 1 || opts.connections < 1 || opts.rate < 0 {
+			fail(fmt.Errorf("-payload-size and -connections must be positive and -rate not negative"))
+		}
+		if opts.payloadFill != "random" && opts.payloadFill != "zero" {
+			fail(fmt.Errorf("unsupported -payload-fill %q (use random or zero)", opts.payloadFill))
+		}
+	}
+	if cmd.name == "bench" && opts.rate < 0 {
+		fail(fmt.Errorf("-rate must not be negative"))
+	}
+	if cmd.name == "bench" && (opts.ramp < 0 || opts.ramp >= opts.benchDuration) {
+		fail(fmt.Errorf("-ramp must be between 0 and -duration (%s)", opts.benchDuration))
+	}
+
+	if !cmd.payload && fs.NArg() > 0 {
+		fail(fmt.Errorf("%s does not take Name=Value data (got %q)", cmd.name, fs.Arg(0)))
+	}
+	if (opts.correlationField != "" || len(opts.sets) > 0) && opts.scenario != "" {
+		fail(fmt.Errorf("-correlation-field and -set cannot be combined with -scenario"))
+	}
+	if opts.quietPeriod < 0 {
+		fail(fmt.Errorf("-quiet-period must not be negative"))
+	}
+	if opts.quietPeriod > 0 && (opts.watch > 0 || opts.watchFile || opts.stdinLines || opts.inputFIFO != "" || opts.scenario != "" || opts.connections > 1) {
+		fail(fmt.Errorf("-quiet-period is not available with -watch, -watch-file, -stdin-lines, -input-fifo, -scenario or -connections"))
+	}
+	if opts.firstMsgTimeout < 0 {
+		fail(fmt.Errorf("-first-message-timeout must not be negative"))
+	}
+	if opts.maxOpen < 0 {
+		fail(fmt.Errorf("-max-open must not be negative"))
+	}
+	if opts.maxOpen > 0 {
+		if opts.monitor || opts.chaos || opts.connections > 1 || len(opts.targets) > 0 || opts.watchRedial {
+			fail(fmt.Errorf("-max-open cannot be combined with -monitor, -chaos, -connections, -targets-file or -watch-redial"))
+		}
+		// Only the server closing in time passes, so the default
+		// -read-timeout must not close the connection first.
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["read-timeout"] {
+			opts.readTimeout = 0
+		}
+	}
+	if opts.sendIdleTimeout < 0 {
+		fail(fmt.Errorf("-send-idle-timeout must not be negative"))
+	}
+	if opts.expectCount < 0 {
+		fail(fmt.Errorf("-expect-count must not be negative"))
+	}
+	if opts.watch < 0 {
+		fail(fmt.Errorf("-watch must not be negative"))
+	}
+	if opts.watch > 0 {
+		if opts.scenario != "" {
+			fail(fmt.Errorf("-watch cannot be combined with -scenario"))
+		}
+		if opts.readTimeout == 0 && opts.expectCount == 0 && opts.correlationField == "" {
+			fail(fmt.Errorf("-watch needs a bounded cycle: set -read-timeout, -expect-count or -correlation-field"))
+		}
+	}
+	if len(opts.dataFiles) > 0 && opts.scenario != "" {
+		fail(fmt.Errorf("-data-file cannot be combined with -scenario"))
+	}
+	if opts.dataURL != "" {
+		if !validDataURL(opts.dataURL) {
+			fail(fmt.Errorf("-data-url %q must be an http:// or https:// URL", opts.dataURL))
+		}
+		if fs.NArg() > 0 || len(opts.dataFiles) > 0 || opts.scenario != "" || opts.stdinLines || opts.splitFields {
+			fail(fmt.Errorf("-data-url cannot be combined with Name=Value data, -data-file, -scenario, -stdin-lines or -split-fields"))
+		}
+	}
+	if opts.dataURLJSON && opts.dataURL == "" {
+		fail(fmt.Errorf("-data-url-json needs -data-url"))
+	}
+	if opts.deepMerge && len(opts.dataFiles) == 0 {
+		fail(fmt.Errorf("-deep-merge needs -data-file"))
+	}
+	if opts.watchFile {
+		if len(opts.dataFiles) == 0 {
+			fail(fmt.Errorf("-watch-file needs -data-file"))
+		}
+		if opts.watch > 0 {
+			fail(fmt.Errorf("-watch-file cannot be combined with -watch"))
+		}
+	}
+	if opts.splitFields {
+		if len(opts.dataFiles) > 0 || len(opts.sets) > 0 || opts.correlationField != "" || opts.traceField != "" || opts.hmacSecret != "" {
+			fail(fmt.Errorf("-split-fields cannot be combined with -data-file, -set, -correlation-field, -trace-field or -hmac-secret"))
+		}
+		if opts.watchFile || opts.monitor || opts.chaos || opts.connections > 1 || opts.stdinLines || opts.scenario != "" {
+			fail(fmt.Errorf("-split-fields cannot be combined with -watch-file, -monitor, -chaos, -connections, -stdin-lines or -scenario"))
+		}
+	}
+	if opts.messageInterval < 0 {
+		fail(fmt.Errorf("-message-interval must not be negative"))
+	}
+	if opts.messageInterval > 0 && !opts.splitFields {
+		fail(fmt.Errorf("-message-interval needs -split-fields"))
+	}
+	if opts.maxInflight < 0 {
+		fail(fmt.Errorf("-max-inflight must not be negative"))
+	}
+	if opts.maxInflight > 0 && !opts.stdinLines {
+		fail(fmt.Errorf("-max-inflight needs -stdin-lines"))
+	}
+	if opts.maxInflightBytes > 0 && !opts.stdinLines {
+		fail(fmt.Errorf("-max-inflight-bytes needs -stdin-lines"))
+	}
+	if opts.stdinLines {
+		if fs.NArg() > 0 || len(opts.dataFiles) > 0 || len(opts.sets) > 0 || opts.scenario != "" {
+			fail(fmt.Errorf("-stdin-lines cannot be combined with Name=Value data, -data-file, -set or -scenario"))
+		}
+		if opts.watch > 0 || opts.watchFile || opts.reconnect || opts.monitor || opts.connections > 1 {
+			fail(fmt.Errorf("-stdin-lines cannot be combined with -watch, -watch-file, -reconnect, -monitor or -connections"))
+		}
+	}
+	if opts.har != "" && (opts.connections > 1 || opts.targetsFile != "") {
+		fail(fmt.Errorf("-har records one connection at a time and cannot be combined with -connections or -targets-file"))
+	}
+	if opts.targetsFile != "" {
+		if opts.connections > 1 || opts.monitor || opts.chaos || opts.watch > 0 || opts.watchFile || opts.scenario != "" || opts.stdinLines || opts.inputFIFO != "" || opts.splitFields || opts.reconnect {
+			fail(fmt.Errorf("-targets-file cannot be combined with -connections, -monitor, -chaos, -watch, -watch-file, -scenario, -stdin-lines, -input-fifo, -split-fields or -reconnect"))
+		}
+	}
+	if opts.churn < 0 {
+		fail(fmt.Errorf("-churn must not be negative"))
+	}
+	if opts.churn > 0 {
+		if opts.churnParallel < 1 {
+			fail(fmt.Errorf("-churn-parallel must be at least 1"))
+		}
+		if opts.monitor || opts.chaos || opts.watch > 0 || opts.watchFile || opts.scenario != "" || opts.stdinLines || opts.inputFIFO != "" || opts.reconnect || opts.connections > 1 || opts.targetsFile != "" || opts.diffSpec != "" || opts.har != "" {
+			fail(fmt.Errorf("-churn cannot be combined with -monitor, -chaos, -watch, -watch-file, -scenario, -stdin-lines, -input-fifo, -reconnect, -connections, -targets-file, -diff or -har"))
+		}
+	} else if opts.failFast || opts.churnParallel > 1 {
+		fail(fmt.Errorf("-fail-fast and -churn-parallel need -churn"))
+	}
+	if opts.diffSpec != "" {
+		if opts.targetsFile != "" || opts.connections > 1 || opts.monitor || opts.chaos || opts.watch > 0 || opts.watchFile || opts.scenario != "" || opts.stdinLines || opts.inputFIFO != "" || opts.splitFields || opts.reconnect || opts.har != "" || opts.waitFor != "" {
+			fail(fmt.Errorf("-diff cannot be combined with -targets-file, -connections, -monitor, -chaos, -watch, -watch-file, -scenario, -stdin-lines, -input-fifo, -split-fields, -reconnect, -har or -wait-for"))
+		}
+	}
+	if opts.inputFIFO != "" {
+		if opts.stdinLines || opts.scenario != "" || opts.splitFields || opts.watch > 0 || opts.watchFile || opts.monitor || opts.chaos || opts.connections > 1 {
+			fail(fmt.Errorf("-input-fifo cannot be combined with -stdin-lines, -scenario, -split-fields, -watch, -watch-file, -monitor, -chaos or -connections"))
+		}
+	}
+	if opts.scenario != "" && fs.NArg() > 0 {
+		fail(fmt.Errorf("Name=Value data cannot be combined with -scenario"))
+	}
+
+	opts.data = make(map[string]string)
+	for _, arg := range fs.Args() {
+		if !strings.Contains(arg, "=") {
+			fail(fmt.Errorf("invalid data %q (want Name=Value)", arg))
+			continue
+		}
+		parts := strings.SplitN(arg, "=", 2)
+		if strings.TrimSpace(parts[0]) == "" {
+			fail(fmt.Errorf("missing name in %q", arg))
+			continue
+		}
+		if _, dup := opts.data[parts[0]]; !dup {
+			opts.dataOrder = append(opts.dataOrder, parts[0])
+		}
+		opts.data[parts[0]] = parts[1]
+	}
+	if opts.splitFields && len(opts.data) == 0 {
+		fail(fmt.Errorf("-split-fields needs Name=Value data"))
+	}
+
+	return collected(opts, problems)
+}
+
+// collected is what parseFlags returns for the problems it found: all of
+// them for -lint, the first one otherwise.
+func collected(opts options, problems []error) (options, error) {
+	switch {
+	case len(problems) == 0:
+		return opts, nil
+	case opts.lint:
+		return opts, errors.Join(problems...)
+	}
+	return opts, problems[0]
+}
+
+// lintRequested reports whether args ask for -lint, which has to be known
+// before they are parsed.
+func lintRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if name != "lint" || !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		on, err := strconv.ParseBool(value)
+		return err == nil && on
+	}
+	return false
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"%s <command> -h\" for the flags of a command.\n", os.Args[0])
+}
+
+// printVisibleDefaults is flag.PrintDefaults without the hiddenFlags.
+func printVisibleDefaults(fs *flag.FlagSet) {
+	visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	visible.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	})
+	visible.PrintDefaults()
+}
+
+// headerFlag collects repeated -H "Name: Value" flags.
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlag) Set(v string) error {
+	name, _, ok := strings.Cut(v, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q (want \"Name: Value\")", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func (h headerFlag) header() http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	hdr := make(http.Header)
+	for _, v := range h {
+		name, value, _ := strings.Cut(v, ":")
+		hdr.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return hdr
+}
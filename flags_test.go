@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// isolateEnv keeps the user's config file and POSTWS_* variables out of a
+// test.
+func isolateEnv(t testing.TB) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, envPrefix) {
+			t.Setenv(name, "") // restored after the test
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// TestParseFlagsEveryCommand parses each subcommand with nothing but its
+// required flags: validation for a flag group must not fire on commands
+// that do not register the group and so see its zero values.
+func TestParseFlagsEveryCommand(t *testing.T) {
+	isolateEnv(t)
+	for _, cmd := range commands {
+		args := []string{cmd.name}
+		switch cmd.name {
+		case "serve", "version":
+		default:
+			args = append(args, "-url", "ws://127.0.0.1:18080", "-path", "/")
+		}
+		if cmd.payload {
+			args = append(args, "a=1")
+		}
+		t.Run(cmd.name, func(t *testing.T) {
+			opts, err := parseFlags(args)
+			if err != nil {
+				t.Fatalf("parseFlags(%q): %v", args, err)
+			}
+			if opts.command != cmd.name {
+				t.Errorf("command = %q, want %q", opts.command, cmd.name)
+			}
+		})
+	}
+}
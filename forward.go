@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// forwardTimeout bounds a single webhook request.
+const forwardTimeout = 10 * time.Second
+
+// forwarder POSTs received messages to -forward-url from its own goroutine,
+// in order. The queue between the read loop and that goroutine holds
+// -forward-queue messages; when it is full, -forward-policy decides whether
+// new messages are dropped or the read loop waits.
+type forwarder struct {
+	url     string
+	header  http.Header
+	retries int
+	delay   time.Duration
+	jitter  *jitterSource
+	block   bool
+	http    *http.Client
+	errw    io.Writer
+	queue   chan []byte
+	done    chan struct{}
+
+	mu        sync.Mutex
+	delivered int
+	failed    int
+	dropped   int
+	retried   int
+}
+
+func startForwarder(opts options, errw io.Writer) *forwarder {
+	f := &forwarder{
+		url:     opts.forwardURL,
+		header:  opts.forwardHeaders.header(),
+		retries: opts.forwardRetries,
+		delay:   opts.forwardDelay,
+		jitter:  opts.jitter,
+		block:   opts.forwardPolicy == "block",
+		http:    &http.Client{Timeout: forwardTimeout},
+		errw:    errw,
+		queue:   make(chan []byte, opts.forwardQueue),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// submit queues msg for delivery according to the policy.
+func (f *forwarder) submit(msg client.Message) {
+	if f.block {
+		f.queue <- msg.Data
+		return
+	}
+	select {
+	case f.queue <- msg.Data:
+	default:
+		f.mu.Lock()
+		f.dropped++
+		f.mu.Unlock()
+		fmt.Fprintln(f.errw, "forward: queue full; dropping message")
+	}
+}
+
+func (f *forwarder) run() {
+	defer close(f.done)
+	for body := range f.queue {
+		err := f.deliver(body)
+		f.mu.Lock()
+		if err != nil {
+			f.failed++
+		} else {
+			f.delivered++
+		}
+		f.mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(f.errw, "forward: giving up on message: %v\n", err)
+		}
+	}
+}
+
+// deliver POSTs body, retrying up to f.retries times with a doubling delay.
+func (f *forwarder) deliver(body []byte) error {
+	b := newBackoff(f.delay, 0, f.jitter)
+	for attempt := 0; ; attempt++ {
+		err := f.post(body)
+		if err == nil || attempt >= f.retries {
+			return err
+		}
+		f.mu.Lock()
+		f.retried++
+		f.mu.Unlock()
+		time.Sleep(b.next())
+	}
+}
+
+func (f *forwarder) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range f.header {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType(body))
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent())
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", f.url, resp.Status)
+	}
+	return nil
+}
+
+// contentType guesses the webhook Content-Type of a message body.
+func contentType(body []byte) string {
+	if json.Valid(body) {
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// close delivers what is still queued, reports the totals and fails if any
+// message could not be delivered.
+func (f *forwarder) close() error {
+	close(f.queue)
+	<-f.done
+	fmt.Fprintf(f.errw, "forward: %d delivered, %d failed, %d dropped, %d retries\n", f.delivered, f.failed, f.dropped, f.retried)
+	if f.failed > 0 {
+		return fmt.Errorf("%d of %d messages could not be forwarded", f.failed, f.delivered+f.failed)
+	}
+	return nil
+}
@@ -1,5 +1,5 @@
-module postws
+module github.com/zsuzuki/postws
 
 go 1.25.4
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// harLog records every connection of a run for -har: the upgrade request
+// and response and the messages in both directions, in the HAR 1.2 layout
+// browsers export, with the WebSocket frames under _webSocketMessages. All
+// methods are no-ops on a nil *harLog.
+type harLog struct {
+	mu      sync.Mutex
+	entries []*harEntry
+	secret  headerCmdFlag // headers to redact besides the usual credentials
+}
+
+type harFile struct {
+	Log harContent `json:"log"`
+}
+
+type harContent struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []any       `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string       `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         harRequest   `json:"request"`
+	Response        harResponse  `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         harTimings   `json:"timings"`
+	ResourceType    string       `json:"_resourceType"`
+	Messages        []harWSFrame `json:"_webSocketMessages"`
+	started         time.Time
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []any       `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []any       `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harBody     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harBody struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harWSFrame is one message: time in seconds since the epoch and the
+// WebSocket opcode (1 text, 2 binary; binary data is base64).
+type harWSFrame struct {
+	Type   string  `json:"type"` // "send" or "receive"
+	Time   float64 `json:"time"`
+	Opcode int     `json:"opcode"`
+	Data   string  `json:"data"`
+}
+
+// connected starts an entry for the connection c just opened to url.
+// Credentials in the request headers are redacted as with -dry-run.
+func (h *harLog) connected(url string, c *client.Client) {
+	if h == nil {
+		return
+	}
+	started := time.Now().Add(-c.HandshakeDuration())
+	e := &harEntry{
+		StartedDateTime: started.Format(time.RFC3339Nano),
+		Time:            millis(c.HandshakeDuration()),
+		Request: harRequest{
+			Method:      http.MethodGet,
+			URL:         url,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []any{},
+			Headers:     []harHeader{},
+			QueryString: []harHeader{},
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []any{},
+			Headers:     []harHeader{},
+			HeadersSize: -1,
+		},
+		Timings:      harTimings{Wait: millis(c.HandshakeDuration())},
+		ResourceType: "websocket",
+		Messages:     []harWSFrame{},
+		started:      started,
+	}
+	if resp := c.Response(); resp != nil {
+		e.Response.Status = resp.StatusCode
+		e.Response.StatusText = http.StatusText(resp.StatusCode)
+		e.Response.Headers = h.headers(resp.Header)
+		if req := resp.Request; req != nil {
+			e.Request.Headers = append([]harHeader{{Name: "Host", Value: req.Host}}, h.headers(req.Header)...)
+			for name, vals := range req.URL.Query() {
+				for _, v := range vals {
+					e.Request.QueryString = append(e.Request.QueryString, harHeader{Name: name, Value: v})
+				}
+			}
+		}
+	}
+	h.mu.Lock()
+	h.entries = append(h.entries, e)
+	h.mu.Unlock()
+}
+
+// message adds a message to the entry of the latest connection.
+func (h *harLog) message(dir string, msgType int, data []byte, at time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return
+	}
+	f := harWSFrame{Type: dir, Time: float64(at.UnixMicro()) / 1e6, Opcode: msgType, Data: string(data)}
+	if msgType == websocket.BinaryMessage {
+		f.Data = base64.StdEncoding.EncodeToString(data)
+	}
+	e := h.entries[len(h.entries)-1]
+	e.Messages = append(e.Messages, f)
+}
+
+// file returns the document to write. Received messages are recorded when
+// printed, which can be after a later send, so each entry's messages are
+// put in time order; its time then covers the connection up to the last.
+func (h *harLog) file() harFile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.entries {
+		sort.SliceStable(e.Messages, func(i, j int) bool { return e.Messages[i].Time < e.Messages[j].Time })
+		if n := len(e.Messages); n > 0 {
+			last := time.UnixMicro(int64(e.Messages[n-1].Time * 1e6))
+			e.Time = max(e.Time, millis(last.Sub(e.started)))
+		}
+	}
+	return harFile{Log: harContent{
+		Version: "1.2",
+		Creator: harCreator{Name: "postws", Version: buildInfo().Version},
+		Pages:   []any{},
+		Entries: h.entries,
+	}}
+}
+
+func (h *harLog) headers(header http.Header) []harHeader {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := []harHeader{}
+	for _, name := range names {
+		for _, v := range header[name] {
+			if h.secret.has(name) {
+				v = "[redacted]"
+			}
+			out = append(out, harHeader{Name: name, Value: redactHeader(name, v)})
+		}
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer is an httptest WebSocket server for the end-to-end tests. It
+// records the handshake headers and the messages the client sent, and runs
+// handle on every connection; a nil handle echoes every message back.
+type testServer struct {
+	*httptest.Server
+	url string // ws://127.0.0.1:port, for -url
+
+	mu       sync.Mutex
+	headers  []http.Header
+	received [][]byte
+}
+
+func newTestServer(t testing.TB, handle func(s *testServer, conn *websocket.Conn)) *testServer {
+	t.Helper()
+	if handle == nil {
+		handle = echo
+	}
+	s := &testServer{}
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.headers = append(s.headers, r.Header.Clone())
+		s.mu.Unlock()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(s, conn)
+	}))
+	t.Cleanup(s.Close)
+	s.url = "ws" + strings.TrimPrefix(s.URL, "http")
+	return s
+}
+
+// echo sends every message back until the client closes.
+func echo(s *testServer, conn *websocket.Conn) {
+	for {
+		kind, data, err := s.read(conn)
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(kind, data); err != nil {
+			return
+		}
+	}
+}
+
+// read reads one message and records it.
+func (s *testServer) read(conn *websocket.Conn) (int, []byte, error) {
+	kind, data, err := conn.ReadMessage()
+	if err == nil {
+		s.mu.Lock()
+		s.received = append(s.received, data)
+		s.mu.Unlock()
+	}
+	return kind, data, err
+}
+
+// drain reads, recording, until the client goes away, which also answers
+// its pings and close frame.
+func (s *testServer) drain(conn *websocket.Conn) {
+	for {
+		if _, _, err := s.read(conn); err != nil {
+			return
+		}
+	}
+}
+
+// closeNormally sends a 1000 close frame and waits for the client's
+// answer.
+func closeNormally(conn *websocket.Conn) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testServer) messages() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.received...)
+}
+
+func (s *testServer) handshakes() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
+
+// syncBuffer is a bytes.Buffer safe for the goroutines of a run that share
+// stderr.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// result is the outcome of runCommand.
+type result struct {
+	stdout, stderr string
+	err            error
+}
+
+// runCommand parses args as on the command line and runs the command
+// in-process against the real dialer, with a deadline so a hanging run
+// fails the test instead of blocking it.
+func runCommand(t *testing.T, args ...string) result {
+	t.Helper()
+	isolateEnv(t)
+	opts, err := parseFlags(args)
+	if err != nil {
+		t.Fatalf("parseFlags(%q): %v", args, err)
+	}
+	var stdout, stderr syncBuffer
+	a := newApp(opts, &stdout, &stderr)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = a.dispatch(ctx, opts)
+	if ctx.Err() != nil {
+		t.Fatalf("%q did not finish in time; stderr:\n%s", args, stderr.String())
+	}
+	return result{stdout: stdout.String(), stderr: stderr.String(), err: err}
+}
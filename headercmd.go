@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// headerCmd is one -H-cmd: a header whose value is the output of a command.
+type headerCmd struct {
+	name string
+	args []string
+}
+
+// headerCmdFlag collects repeated -H-cmd "Name: command" values.
+type headerCmdFlag []headerCmd
+
+func (h *headerCmdFlag) String() string {
+	names := make([]string, len(*h))
+	for i, c := range *h {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (h *headerCmdFlag) Set(v string) error {
+	name, command, ok := strings.Cut(v, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header command %q (want \"Name: command\")", v)
+	}
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return err
+	}
+	*h = append(*h, headerCmd{name: http.CanonicalHeaderKey(strings.TrimSpace(name)), args: args})
+	return nil
+}
+
+// has reports whether name is set by a -H-cmd, so its value is redacted
+// wherever headers are shown.
+func (h headerCmdFlag) has(name string) bool {
+	for _, c := range h {
+		if strings.EqualFold(c.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs every command and sets its header to the trimmed stdout. It
+// is called before every dial, so each handshake gets a fresh value, e.g.
+// a short-lived token. A failing command fails the attempt, with what it
+// wrote to stderr.
+func (h headerCmdFlag) apply(header http.Header) error {
+	for _, c := range h {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(c.args[0], c.args[1:]...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("-H-cmd %s: %v: %s", c.name, err, msg)
+			}
+			return fmt.Errorf("-H-cmd %s: %v", c.name, err)
+		}
+		value := strings.TrimSpace(stdout.String())
+		if value == "" {
+			return fmt.Errorf("-H-cmd %s: %s printed nothing", c.name, c.args[0])
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("-H-cmd %s: %s printed more than one line", c.name, c.args[0])
+		}
+		header.Set(c.name, value)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zsuzuki/postws/client"
+)
+
+// heartbeatMessage renders the -heartbeat-payload template for the seq-th
+// heartbeat sent at now.
+func heartbeatMessage(tmpl string, seq int, now time.Time) []byte {
+	return []byte(fillTemplate(tmpl, seq, now))
+}
+
+// heartbeatTemplate is the heartbeat to send: -heartbeat-send when set,
+// -heartbeat-payload otherwise.
+func heartbeatTemplate(opts options) string {
+	if opts.heartbeatSend != "" {
+		return opts.heartbeatSend
+	}
+	return opts.heartbeatPayload
+}
+
+// errHeartbeatLost ends a session dropped after -heartbeat-misses
+// unanswered heartbeats, unless -reconnect dials again.
+var errHeartbeatLost = errors.New("heartbeats unanswered")
+
+// heartbeatReplies counts the heartbeats of a run and, with
+// -heartbeat-expect, matches the server's replies: a heartbeat counts as
+// missed when no reply arrived before the next one is due, and
+// -heartbeat-misses misses in a row end the connection. Replies are kept
+// out of the output unless -heartbeat-show is set. A nil *heartbeatReplies
+// (no -heartbeat-interval) does nothing.
+type heartbeatReplies struct {
+	text  string         // exact reply, or
+	re    *regexp.Regexp // re:REGEX against the reply; both unset without -heartbeat-expect
+	limit int            // -heartbeat-misses
+	show  bool
+
+	mu       sync.Mutex
+	pending  bool // the last heartbeat of this connection is unanswered
+	inRow    int  // consecutive misses on this connection
+	lost     bool // this connection was given up
+	sent     int
+	answered int
+	missed   int
+}
+
+func newHeartbeatReplies(opts options) *heartbeatReplies {
+	if opts.heartbeatInterval <= 0 {
+		return nil
+	}
+	h := &heartbeatReplies{limit: opts.heartbeatMisses, show: opts.heartbeatShow, re: opts.heartbeatExpectRe}
+	if h.re == nil {
+		h.text = opts.heartbeatExpect
+	}
+	return h
+}
+
+func (h *heartbeatReplies) expecting() bool {
+	return h.text != "" || h.re != nil
+}
+
+// connected starts the miss count over for a new connection.
+func (h *heartbeatReplies) connected() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.pending, h.inRow, h.lost = false, 0, false
+	h.mu.Unlock()
+}
+
+// due is called when the next heartbeat is about to be sent and reports,
+// once the previous ones went unanswered -heartbeat-misses times in a row,
+// that the connection is to be given up instead. The new heartbeat awaits
+// its reply from here on, as the reply may beat Send's return.
+func (h *heartbeatReplies) due() (misses int, lost bool) {
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending {
+		h.inRow++
+		h.missed++
+		if h.inRow >= h.limit {
+			h.pending, h.lost = false, true
+			return h.inRow, true
+		}
+	}
+	h.pending = h.expecting()
+	return h.inRow, false
+}
+
+// beat records a heartbeat sent.
+func (h *heartbeatReplies) beat() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.sent++
+	h.mu.Unlock()
+}
+
+// reply reports whether msg answers a heartbeat, and so is not printed
+// unless -heartbeat-show is set.
+func (h *heartbeatReplies) reply(msg client.Message) (hide bool) {
+	if h == nil || !h.expecting() || msg.Type != websocket.TextMessage {
+		return false
+	}
+	if h.re != nil && !h.re.Match(msg.Data) || h.re == nil && string(msg.Data) != h.text {
+		return false
+	}
+	h.mu.Lock()
+	if h.pending {
+		h.answered++
+		h.pending = false
+	}
+	h.inRow = 0
+	h.mu.Unlock()
+	return !h.show
+}
+
+// gaveUp reports whether the current connection was dropped for missed
+// heartbeats.
+func (h *heartbeatReplies) gaveUp() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lost
+}
+
+// heartbeatSummary is the "heartbeats" object of -stats-file; answered and
+// missed are only counted with -heartbeat-expect.
+type heartbeatSummary struct {
+	Sent     int  `json:"sent"`
+	Answered *int `json:"answered,omitempty"`
+	Missed   *int `json:"missed,omitempty"`
+}
+
+func (h *heartbeatReplies) summary() *heartbeatSummary {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &heartbeatSummary{Sent: h.sent}
+	if h.expecting() {
+		answered, missed := h.answered, h.missed
+		s.Answered, s.Missed = &answered, &missed
+	}
+	return s
+}
+
+// report prints the reply counts at the end of a -heartbeat-expect run.
+func (h *heartbeatReplies) report(w io.Writer) {
+	if h == nil || !h.expecting() {
+		return
+	}
+	s := h.summary()
+	fmt.Fprintf(w, "heartbeats: %d sent, %d answered, %d missed\n", s.Sent, *s.Answered, *s.Missed)
+}
+
+// fillTemplate replaces {{now}} (RFC 3339), {{unix_ms}} and {{seq}} in tmpl.
+func fillTemplate(tmpl string, seq int, now time.Time) string {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl
+	}
+	return strings.NewReplacer(
+		"{{now}}", now.Format(time.RFC3339Nano),
+		"{{unix_ms}}", strconv.FormatInt(now.UnixMilli(), 10),
+		"{{seq}}", strconv.Itoa(seq),
+	).Replace(tmpl)
+}
+
+// startHeartbeat sends -heartbeat-payload (or -heartbeat-send) on c every
+// -heartbeat-interval until the returned stop function is called or the
+// connection ends. With -heartbeat-idle-only a heartbeat is only sent once
+// nothing else has been sent for a whole interval, so other traffic keeps
+// pushing it back. Heartbeats share the client's write lock with every
+// other send but bypass a.send, so -max-sends and the sent-message counters
+// ignore them. With -heartbeat-expect the connection is dropped, as a lost
+// network would be, after -heartbeat-misses unanswered heartbeats.
+func (a *app) startHeartbeat(c *client.Client, opts options) (stop func()) {
+	if opts.heartbeatInterval <= 0 {
+		return func() {}
+	}
+	a.heartbeats.connected()
+	tmpl := heartbeatTemplate(opts)
+	quit := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		timer := time.NewTimer(opts.heartbeatInterval)
+		defer timer.Stop()
+		seq := 0
+		for {
+			select {
+			case <-quit:
+				return
+			case <-c.Done():
+				return
+			case <-timer.C:
+			}
+			if c.Silenced() {
+				// -chaos silence: send nothing until it is over.
+				timer.Reset(opts.heartbeatInterval)
+				continue
+			}
+			if opts.heartbeatIdleOnly {
+				if idle := time.Since(a.lastSent()); idle < opts.heartbeatInterval {
+					timer.Reset(opts.heartbeatInterval - idle)
+					continue
+				}
+			}
+			if misses, lost := a.heartbeats.due(); lost {
+				fmt.Fprintf(a.stderr, "heartbeat: %d in a row unanswered (-heartbeat-misses); dropping the connection\n", misses)
+				a.events.emit("heartbeat_lost", map[string]any{"misses": misses})
+				c.Drop()
+				return
+			} else if misses > 0 && opts.verbose {
+				fmt.Fprintf(a.stderr, "heartbeat missed (%d in a row)\n", misses)
+			}
+			seq++
+			msg := heartbeatMessage(tmpl, seq, time.Now())
+			ctx, cancel := context.WithTimeout(context.Background(), opts.heartbeatInterval)
+			err := c.Send(ctx, msg)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(a.stderr, "heartbeat: %v\n", err)
+				return
+			}
+			a.heartbeats.beat()
+			a.events.emit("sent", map[string]any{"bytes": len(msg), "data": dataField(msg), "heartbeat": true})
+			if opts.verbose {
+				fmt.Fprintf(a.stderr, "heartbeat sent: %s\n", msg)
+			}
+			timer.Reset(opts.heartbeatInterval)
+		}
+	}()
+	return func() {
+		close(quit)
+		<-finished
+	}
+}
+
+// watchSendIdle cancels ctx, which closes the session gracefully like an
+// interrupt, once nothing has gone through a.send for -send-idle-timeout;
+// the connection's start counts as the first send. Heartbeats do not reset
+// the timer.
+func (a *app) watchSendIdle(ctx context.Context, opts options) (context.Context, func()) {
+	if opts.sendIdleTimeout <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	start := time.Now()
+	go func() {
+		timer := time.NewTimer(opts.sendIdleTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			last := a.lastSent()
+			if last.Before(start) {
+				last = start
+			}
+			if idle := time.Since(last); idle < opts.sendIdleTimeout {
+				timer.Reset(opts.sendIdleTimeout - idle)
+				continue
+			}
+			cancel(fmt.Errorf("nothing sent for %s (-send-idle-timeout)", opts.sendIdleTimeout))
+			return
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// errStillOpen ends a run whose connection outlived -max-open; main exits
+// with exitStillOpen for it, apart from an ordinary failure.
+var errStillOpen = errors.New("connection still open")
+
+const exitStillOpen = 5
+
+// watchMaxOpen cancels ctx, closing the session like -send-idle-timeout
+// does, once the connection has been open for -max-open, with an
+// errStillOpen cause for session to report.
+func watchMaxOpen(ctx context.Context, opts options) (context.Context, func()) {
+	if opts.maxOpen <= 0 {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(opts.maxOpen, func() {
+		cancel(fmt.Errorf("%w after %s (-max-open)", errStillOpen, opts.maxOpen))
+	})
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// lastSent is when a.send last wrote a message, or the zero time.
+func (a *app) lastSent() time.Time {
+	if ns := a.lastSend.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// signPayload returns the HMAC-SHA256 of payload keyed by secret, encoded as
+// hex or base64.
+func signPayload(secret, encoding string, payload []byte) (string, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+	switch encoding {
+	case "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("unsupported -hmac-encoding %q (use hex or base64)", encoding)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func hmacHex(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The signature must cover the bytes on the wire, which -transform
+// rewrites after the payload is built.
+func TestHMACSignsTransformedPayload(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-expect-count", "1",
+		"-hmac-secret", "s3cret", "-transform", "/seq={{seq}}", "a=1")
+	if r.err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	msgs := s.messages()
+	if len(msgs) != 1 || string(msgs[0]) != `{"a":"1","seq":1}` {
+		t.Fatalf("server received %q, want the transformed payload", msgs)
+	}
+	if got, want := s.handshakes()[0].Get("X-Signature"), hmacHex("s3cret", msgs[0]); got != want {
+		t.Errorf("X-Signature = %s, want %s (HMAC of the bytes sent)", got, want)
+	}
+}
+
+func TestHMACDryRunShowsSignedBytes(t *testing.T) {
+	r := runCommand(t, "send", "-url", "ws://127.0.0.1:1", "-path", "/ws", "-dry-run",
+		"-hmac-secret", "s3cret", "-transform", "/seq={{seq}}", "a=1")
+	if r.err != nil {
+		t.Fatalf("dry run: %v", r.err)
+	}
+	wire := `{"a":"1","seq":1}`
+	if !strings.Contains(r.stdout, "payload 1: "+wire+"\n") {
+		t.Errorf("dry run does not print the transformed payload:\n%s", r.stdout)
+	}
+	if !strings.Contains(r.stdout, "X-Signature: "+hmacHex("s3cret", []byte(wire))) {
+		t.Errorf("dry run signature is not that of the transformed payload:\n%s", r.stdout)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestListenCancelDuringReceive cancels the run, as Ctrl-C does, while the
+// server is streaming: the client must close with 1000 "interrupted" and
+// still print what arrives during the close.
+func TestListenCancelDuringReceive(t *testing.T) {
+	closed := make(chan *websocket.CloseError, 1)
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		go func() {
+			for {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"tick":true}`)); err != nil {
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+		_, _, err := s.read(conn)
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			closed <- ce
+		}
+	})
+
+	isolateEnv(t)
+	opts, err := parseFlags([]string{"listen", "-url", s.url, "-path", "/ws", "-read-timeout", "0", "-format", "raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr syncBuffer
+	a := newApp(opts, &stdout, &stderr)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.dispatch(ctx, opts) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stdout.String(), "tick") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no message printed; stderr:\n%s", stderr.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("listen: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listen did not return after cancel; stderr:\n%s", stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "interrupted; closing connection") {
+		t.Errorf("stderr does not report the interrupt:\n%s", stderr.String())
+	}
+	select {
+	case ce := <-closed:
+		if ce.Code != websocket.CloseNormalClosure || ce.Text != "interrupted" {
+			t.Errorf("server saw close %d %q, want 1000 interrupted", ce.Code, ce.Text)
+		}
+	case <-time.After(time.Second):
+		t.Error("server saw no close frame")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupPath walks a decoded JSON document along a dot-separated path.
+// Numeric segments index into arrays, e.g. "items.0.id". A path starting
+// with "/" is a JSON Pointer (RFC 6901) instead, e.g. "/items/0/id".
+func lookupPath(doc any, path string) (any, bool) {
+	cur := doc
+	keys := strings.Split(path, ".")
+	if strings.HasPrefix(path, "/") {
+		keys = pointerTokens(path)
+	}
+	for _, key := range keys {
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// valueString renders a decoded JSON value for comparison with a CLI string:
+// strings as-is, everything else in its compact JSON form.
+func valueString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// pointerTokens splits a JSON Pointer into its unescaped reference tokens.
+func pointerTokens(ptr string) []string {
+	if ptr == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(ptr, "/"), "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// setPointer stores value at the JSON Pointer ptr in doc and returns the
+// updated document. Missing objects along the way are created; in arrays a
+// token is an existing index or "-" to append.
+func setPointer(doc any, ptr string, value any) (any, error) {
+	if ptr == "" {
+		return value, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer %q (must start with /)", ptr)
+	}
+	return setTokens(doc, pointerTokens(ptr), value, ptr)
+}
+
+func setTokens(node any, tokens []string, value any, ptr string) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	key := tokens[0]
+	switch n := node.(type) {
+	case nil:
+		child, err := setTokens(nil, tokens[1:], value, ptr)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{key: child}, nil
+	case map[string]any:
+		child, err := setTokens(n[key], tokens[1:], value, ptr)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = child
+		return n, nil
+	case []any:
+		if key == "-" {
+			child, err := setTokens(nil, tokens[1:], value, ptr)
+			if err != nil {
+				return nil, err
+			}
+			return append(n, child), nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(n) {
+			return nil, fmt.Errorf("%s: no array element %q", ptr, key)
+		}
+		child, err := setTokens(n[i], tokens[1:], value, ptr)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	default:
+		return nil, fmt.Errorf("%s: cannot set %q inside a %s", ptr, key, jsonKind(node))
+	}
+}
+
+// jsonKind names the JSON type of a decoded value for error messages.
+func jsonKind(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	}
+	return "value"
+}
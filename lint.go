@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// reservedHeaders are set by the WebSocket handshake itself; gorilla
+// refuses them as extra headers.
+var reservedHeaders = []string{"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions"}
+
+// lint checks an invocation without connecting and returns every problem
+// found. parseErr holds what parseFlags collected, joined when there is more
+// than one problem.
+func lint(opts options, parseErr error) []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		p := fmt.Sprintf(format, args...)
+		if !slices.Contains(problems, p) { // a check parseFlags already failed
+			problems = append(problems, p)
+		}
+	}
+	if joined, ok := parseErr.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			add("%v", err)
+		}
+	} else if parseErr != nil {
+		add("%v", parseErr)
+	}
+
+	if opts.baseURL != "" {
+		u, err := client.BuildURL(opts.baseURL, opts.path, opts.port)
+		switch {
+		case err != nil:
+			add("-url: %v", err)
+		case opts.insecureTLS && !strings.HasPrefix(u, "wss://"):
+			add("-insecure-skip-verify is only valid with wss:// URLs")
+		}
+	}
+
+	for _, h := range opts.headers {
+		name, value, _ := strings.Cut(h, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case !isToken(name):
+			add("-H %q: %q is not a valid header name", h, name)
+		case strings.ContainsFunc(value, func(r rune) bool { return r < ' ' && r != '\t' || r == 0x7f }):
+			add("-H %q: the value contains control characters", h)
+		}
+		for _, r := range reservedHeaders {
+			if http.CanonicalHeaderKey(name) == r {
+				add("-H %q: %s is set by the handshake and cannot be overridden", h, r)
+			}
+		}
+	}
+
+	for _, path := range opts.dataFiles {
+		if _, err := readDataFile(path); err != nil {
+			add("%v", err)
+		}
+	}
+	var sends [][]byte
+	if opts.scenario != "" {
+		steps, err := loadScenario(opts.scenario)
+		if err != nil {
+			add("-scenario: %v", err)
+		}
+		sends = stepTexts(steps)
+	}
+	if parseErr == nil && opts.command == "send" && opts.scenario == "" && !opts.stdinLines && opts.dataURL == "" {
+		// Name=Value data is only known once parseFlags has finished.
+		payload, err := buildPayload(opts, "")
+		if err != nil {
+			add("payload: %v", err)
+		}
+		sends = append(sends, payload)
+	}
+	captures := newCaptureSet(opts.captures, 0)
+	for _, text := range sends {
+		if err := captures.checkRefs(text); err != nil {
+			add("%v", err)
+			break
+		}
+	}
+	if opts.heartbeatInterval > 0 && opts.heartbeatSend == "" && !json.Valid(heartbeatMessage(opts.heartbeatPayload, 1, time.Now())) {
+		add("-heartbeat-payload is not valid JSON: %s", opts.heartbeatPayload)
+	}
+	for _, c := range []struct{ flag, spec string }{{"wait-for", opts.waitFor}, {"filter", opts.filterSpec}} {
+		if c.spec == "" {
+			continue
+		}
+		if _, err := parseCondition(c.flag, c.spec); err != nil {
+			add("%v", err)
+		}
+	}
+	for _, code := range opts.reconnectCodes {
+		if !receivableCloseCode(code) {
+			add("-reconnect-on-codes: %d is not a close code a connection can end with", code)
+		}
+	}
+	for _, out := range []struct{ flag, path string }{{"events-json", opts.eventsJSON}, {"stats-file", opts.statsFile}, {"har", opts.har}, {"output", opts.outputPath}, {"capture-file", opts.captureFile}} {
+		if out.path == "" || out.path == "stderr" && out.flag == "events-json" {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Dir(out.path)); err != nil || !fi.IsDir() {
+			add("-%s %s: directory %s does not exist", out.flag, out.path, filepath.Dir(out.path))
+		}
+	}
+	return problems
+}
+
+// receivableCloseCode reports whether a session can end with code: one
+// defined by RFC 6455 (1005, 1006 and 1015 being reported locally), an
+// IANA-registered one, or one of the 3000-4999 application ranges.
+func receivableCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1005 && code <= 1015:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// reportLint prints the -lint outcome and returns the exit code.
+func reportLint(w io.Writer, problems []string) int {
+	for _, p := range problems {
+		fmt.Fprintf(w, "lint: %s\n", p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "lint: %d problem(s)\n", len(problems))
+		return 1
+	}
+	fmt.Fprintln(w, "lint: ok")
+	return 0
+}
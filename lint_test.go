@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLintReportsEveryProblem checks that -lint keeps going past the first
+// validation error, including a flag value the flag package rejects.
+func TestLintReportsEveryProblem(t *testing.T) {
+	isolateEnv(t)
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "validation",
+			args: []string{"send", "-lint", "-url", "ws://127.0.0.1:18080", "-path", "/", "-dns-server", "nope", "-ws-key", "abc", "-send-at", "bad", "a=1"},
+			want: []string{"-dns-server", "-ws-key", "-send-at"},
+		},
+		{
+			name: "malformed header",
+			args: []string{"send", "-url", "ws://127.0.0.1:18080", "-H", "bad", "-path", "/", "-lint", "-send-at", "bad", "a=1"},
+			want: []string{"-H", "-send-at"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseFlags(tc.args)
+			if !opts.lint {
+				t.Fatal("-lint was not parsed")
+			}
+			problems := lint(opts, err)
+			if len(problems) != len(tc.want) {
+				t.Fatalf("got %d problems, want %d: %q", len(problems), len(tc.want), problems)
+			}
+			for i, p := range problems {
+				if !strings.Contains(p, tc.want[i]) {
+					t.Errorf("problem %d = %q, want it to mention %s", i, p, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParseFlagsFirstProblem checks that a run without -lint still fails
+// with the first problem alone.
+func TestParseFlagsFirstProblem(t *testing.T) {
+	isolateEnv(t)
+	_, err := parseFlags([]string{"send", "-url", "ws://127.0.0.1:18080", "-path", "/", "-dns-server", "nope", "-send-at", "bad", "a=1"})
+	if err == nil || !strings.Contains(err.Error(), "-dns-server") || strings.Contains(err.Error(), "-send-at") {
+		t.Fatalf("err = %v, want only the -dns-server problem", err)
+	}
+}
@@ -1,181 +1,154 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"net"
-	"net/url"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
-type options struct {
-	baseURL     string
-	path        string
-	port        int
-	dialTimeout time.Duration
-	readTimeout time.Duration
-	data        map[string]string
-	insecureTLS bool
-}
-
 func main() {
-	opts, err := parseFlags()
+	opts, err := parseFlags(os.Args[1:])
+	if opts.lint {
+		os.Exit(reportLint(os.Stderr, lint(opts, err)))
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "argument error: %v\n", err)
-		flag.Usage()
+		opts.usage()
 		os.Exit(2)
 	}
 
-	if err := run(opts); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func parseFlags() (options, error) {
-	var opts options
-
-	flag.StringVar(&opts.baseURL, "url", "", "WebSocket base URL (e.g. ws://localhost:8080)")
-	flag.StringVar(&opts.path, "path", "", "WebSocket path (e.g. /ws)")
-	flag.IntVar(&opts.port, "port", 0, "Port to override in the WebSocket URL (optional)")
-	flag.DurationVar(&opts.dialTimeout, "dial-timeout", 10*time.Second, "How long to wait when establishing the connection")
-	flag.DurationVar(&opts.readTimeout, "read-timeout", 10*time.Second, "How long to wait for responses after sending (0 waits indefinitely)")
-	flag.BoolVar(&opts.insecureTLS, "insecure-skip-verify", false, "Skip TLS certificate verification (for wss://; testing only)")
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -url ws://host -path /ws [-port 8080] [-insecure-skip-verify] Name=Value [More=Data]\n", os.Args[0])
-		flag.PrintDefaults()
+	if opts.showVersion {
+		printVersion(os.Stdout)
+		return
 	}
 
-	flag.Parse()
-
-	if opts.baseURL == "" {
-		return opts, fmt.Errorf("-url is required")
-	}
-	if opts.path == "" {
-		return opts, fmt.Errorf("-path is required")
+	if opts.listProfiles {
+		if err := listProfiles(os.Stdout, opts.configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	opts.data = make(map[string]string)
-	for _, arg := range flag.Args() {
-		if !strings.Contains(arg, "=") {
-			return opts, fmt.Errorf("invalid data %q (want Name=Value)", arg)
-		}
-		parts := strings.SplitN(arg, "=", 2)
-		if strings.TrimSpace(parts[0]) == "" {
-			return opts, fmt.Errorf("missing name in %q", arg)
+	if opts.verbose {
+		for _, name := range opts.fromEnv {
+			fmt.Fprintf(os.Stderr, "-%s set from %s\n", name, envName(name))
 		}
-		opts.data[parts[0]] = parts[1]
 	}
 
-	return opts, nil
-}
-
-func run(opts options) error {
-	fullURL, err := buildURL(opts.baseURL, opts.path, opts.port)
-	if err != nil {
-		return err
-	}
-	if opts.insecureTLS && !strings.HasPrefix(fullURL, "wss://") {
-		return fmt.Errorf("-insecure-skip-verify is only valid with wss:// URLs")
+	if opts.trace {
+		fmt.Fprintf(os.Stderr, "traceparent: %s (trace id %s)\n", opts.traceparent, traceID(opts.traceparent))
 	}
 
-	payload, err := json.Marshal(opts.data)
-	if err != nil {
-		return fmt.Errorf("marshal payload: %w", err)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// After the first signal, restore the default handling so a second
+		// Ctrl-C kills the process even if shutdown hangs.
+		<-ctx.Done()
+		stop()
+	}()
 
-	dialer := websocket.Dialer{
-		HandshakeTimeout: opts.dialTimeout,
-	}
-	if strings.HasPrefix(fullURL, "wss://") {
-		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.insecureTLS} //nolint:gosec // optional override for testing
+	started := time.Now()
+	a := newApp(opts, os.Stdout, os.Stderr)
+	if err = a.prepare(ctx, &opts); err == nil {
+		if opts.noAutoPong && !opts.dryRun {
+			fmt.Fprintln(os.Stderr, "warning: -no-auto-pong leaves the server's pings unanswered; most servers close such a connection after their ping timeout")
+		}
+		err = a.dispatch(ctx, opts)
 	}
+	os.Exit(a.finish(opts, started, err))
+}
 
-	conn, resp, err := dialer.Dial(fullURL, nil)
-	if err != nil {
-		return fmt.Errorf("dial %s: %w", fullURL, err)
+// prepare opens the -events-json, -output and -tee files and fetches the
+// -data-url payload. Its error ends the run like any other, so -stats-file
+// and -har are still written.
+func (a *app) prepare(ctx context.Context, opts *options) error {
+	if err := a.openEvents(*opts); err != nil {
+		return err
 	}
-	defer conn.Close()
-
-	if resp != nil {
-		fmt.Fprintf(os.Stderr, "connected: %s\n", resp.Status)
+	if err := a.openOutput(*opts); err != nil {
+		return err
 	}
-
-	if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
-		return fmt.Errorf("send message: %w", err)
+	if err := a.openTee(*opts); err != nil {
+		return err
 	}
-	fmt.Printf("sent: %s\n", payload)
-
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				// The read loop exits on normal close or any read error.
-				fmt.Fprintf(os.Stderr, "read finished: %v\n", err)
-				return
-			}
-			printMessage(msg)
-		}
-	}()
-
-	if opts.readTimeout > 0 {
-		select {
-		case <-done:
-		case <-time.After(opts.readTimeout):
-			fmt.Fprintf(os.Stderr, "no more messages within %s; closing connection\n", opts.readTimeout)
-			_ = conn.WriteControl(
-				websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "timeout"),
-				time.Now().Add(time.Second),
-			)
-			<-done
+	if opts.dataURL != "" {
+		body, err := fetchDataURL(ctx, *opts)
+		if err != nil {
+			return err
 		}
-	} else {
-		<-done
+		opts.dataURLBody = body
 	}
-
 	return nil
 }
 
-func buildURL(rawURL, path string, port int) (string, error) {
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return "", fmt.Errorf("parse url: %w", err)
+// finish reports on the run that ended with err, closes the output files,
+// writes -stats-file and -har and returns the exit code.
+func (a *app) finish(opts options, started time.Time, err error) int {
+	a.reportSendRate(opts)
+	a.reportReadRate(opts)
+	a.out.backpressure.report(a.stderr)
+	a.heartbeats.report(a.stderr)
+	if cerr := a.output.close(); cerr != nil && err == nil {
+		err = fmt.Errorf("-output: %w", cerr)
 	}
-	if u.Scheme == "" {
-		return "", fmt.Errorf("url must include scheme, e.g. ws://host or wss://host")
+	if cerr := a.out.tee.close(); cerr != nil && err == nil {
+		err = fmt.Errorf("-tee: %w", cerr)
 	}
-	if u.Scheme != "ws" && u.Scheme != "wss" {
-		return "", fmt.Errorf("unsupported scheme %q (use ws:// or wss://)", u.Scheme)
-	}
-	if u.Host == "" {
-		return "", fmt.Errorf("url must include host")
+	if err != nil {
+		a.events.emit("error", map[string]any{"error": err.Error()})
+	}
+	a.events.close()
+	code := 0
+	switch {
+	case errors.Is(err, errLoadShed):
+		code = exitLoadShed
+	case errors.Is(err, errNoResponse):
+		code = exitNoResponse
+	case errors.Is(err, errStillOpen):
+		code = exitStillOpen
+	case err != nil:
+		code = 1
+	}
+	if opts.statsFile != "" {
+		if serr := writeJSONFile(opts.statsFile, a.summary(opts, started, err, code)); serr != nil {
+			fmt.Fprintf(a.stderr, "error: -stats-file: %v\n", serr)
+			code = 1
+		}
 	}
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
+	if opts.har != "" {
+		if herr := writeJSONFile(opts.har, a.har.file()); herr != nil {
+			fmt.Fprintf(a.stderr, "error: -har: %v\n", herr)
+			code = 1
+		}
 	}
-	u.Path = path
-	if port > 0 {
-		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	if err != nil && !errors.Is(err, errCheckFailed) {
+		fmt.Fprintf(a.stderr, "error: %v\n", err)
 	}
-	return u.String(), nil
+	return code
 }
 
-func printMessage(msg []byte) {
-	var formatted bytes.Buffer
-	if err := json.Indent(&formatted, msg, "", "  "); err == nil {
-		fmt.Printf("recv:\n%s\n", formatted.String())
-		return
+// dispatch runs the subcommand opts selects.
+func (a *app) dispatch(ctx context.Context, opts options) error {
+	switch {
+	case opts.check:
+		return a.check(ctx, opts)
+	case opts.command == "ping":
+		return a.ping(ctx, opts)
+	case opts.command == "bench":
+		return a.bench(ctx, opts)
+	case opts.command == "serve":
+		return a.serve(ctx, opts)
+	case opts.command == "throughput":
+		return a.throughput(ctx, opts)
+	case opts.command == "tap":
+		return a.tap(ctx, opts)
+	default:
+		return a.run(ctx, opts)
 	}
-	fmt.Printf("recv: %s\n", msg)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSetupFailureWritesStatsAndHAR checks that a run failing before it
+// connects still ends through finish, with -stats-file and -har written.
+func TestSetupFailureWritesStatsAndHAR(t *testing.T) {
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"output", []string{"-output", "/nonexistent/out.txt", "a=1"}, "-output"},
+		{"tee", []string{"-tee", "/nonexistent/tee.ndjson", "a=1"}, "-tee"},
+		{"events-json", []string{"-events-json", "/nonexistent/events.jsonl", "a=1"}, "-events-json"},
+		{"data-url", []string{"-data-url", gone.URL}, "-data-url"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			isolateEnv(t)
+			dir := t.TempDir()
+			stats, har := filepath.Join(dir, "stats.json"), filepath.Join(dir, "run.har")
+			args := append([]string{"send", "-url", "ws://127.0.0.1:1", "-path", "/ws", "-stats-file", stats, "-har", har}, tc.args...)
+			opts, err := parseFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout, stderr syncBuffer
+			a := newApp(opts, &stdout, &stderr)
+			err = a.prepare(context.Background(), &opts)
+			if err == nil {
+				t.Fatal("prepare succeeded")
+			}
+			if code := a.finish(opts, time.Now(), err); code != 1 {
+				t.Errorf("exit code = %d, want 1", code)
+			}
+			if !strings.Contains(stderr.String(), "error: "+tc.want) {
+				t.Errorf("stderr does not report the %s error:\n%s", tc.want, stderr.String())
+			}
+
+			b, err := os.ReadFile(stats)
+			if err != nil {
+				t.Fatalf("-stats-file not written: %v", err)
+			}
+			var sum runSummary
+			if err := json.Unmarshal(b, &sum); err != nil {
+				t.Fatal(err)
+			}
+			if sum.ExitCode != 1 || !strings.Contains(sum.Error, tc.want) {
+				t.Errorf("stats: exit code %d, error %q; want 1 and the %s error", sum.ExitCode, sum.Error, tc.want)
+			}
+			if _, err := os.Stat(har); err != nil {
+				t.Errorf("-har not written: %v", err)
+			}
+		})
+	}
+}
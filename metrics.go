@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// metricDocs lists the metrics served by -metrics-listen. The names are
+// part of the interface; add to them, do not rename them.
+var metricDocs = []struct{ name, kind, help string }{
+	{"postws_messages_sent_total", "counter", "Messages sent"},
+	{"postws_messages_received_total", "counter", "Messages received"},
+	{"postws_bytes_sent_total", "counter", "Payload bytes sent"},
+	{"postws_bytes_received_total", "counter", "Payload bytes received"},
+	{"postws_reconnects_total", "counter", "Reconnect attempts"},
+	{"postws_errors_total", "counter", "Errors by class: dial, send, closed (abnormal close), timeout"},
+	{"postws_message_size_bytes", "histogram", "Size of received messages"},
+	{"postws_response_latency_seconds", "histogram", "Time from a send to the next received message"},
+}
+
+var (
+	sizeBuckets    = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576}
+	latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// metrics holds the -metrics-listen counters. All methods are no-ops on a
+// nil *metrics, so callers need not check whether metrics are enabled.
+type metrics struct {
+	mu            sync.Mutex
+	sentMsgs      int
+	sentBytes     int
+	recvMsgs      int
+	recvBytes     int
+	reconnects    int
+	errors        map[string]int
+	size          histogram
+	latency       histogram
+	awaitingReply time.Time // time of the last send not yet answered
+
+	// samples keeps the latencies since the last -stats-interval
+	// checkpoint, for percentiles the histogram cannot give; nil otherwise.
+	samples []time.Duration
+	// all keeps every latency of the run for -stats-file; nil otherwise.
+	all []time.Duration
+
+	// recvSizes and sentSizes are the payload size distributions of the
+	// run, and winRecv and winSent those since the last -stats-interval
+	// checkpoint; nil unless -size-stats, -stats-file or -stats-interval
+	// is set.
+	recvSizes, sentSizes *sizeDist
+	winRecv, winSent     *sizeDist
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []int // per bucket, not cumulative
+	sum     float64
+	count   int
+}
+
+func newHistogram(buckets []float64) histogram {
+	return histogram{buckets: buckets, counts: make([]int, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		errors:  make(map[string]int),
+		size:    newHistogram(sizeBuckets),
+		latency: newHistogram(latencyBuckets),
+	}
+}
+
+func (m *metrics) sent(n int, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sentMsgs++
+	m.sentBytes += n
+	m.sentSizes.observe(n)
+	m.winSent.observe(n)
+	m.awaitingReply = at
+}
+
+func (m *metrics) received(msg client.Message) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recvMsgs++
+	m.recvBytes += len(msg.Data)
+	m.size.observe(float64(len(msg.Data)))
+	m.recvSizes.observe(len(msg.Data))
+	m.winRecv.observe(len(msg.Data))
+	if !m.awaitingReply.IsZero() {
+		m.observeLatency(msg.Time.Sub(m.awaitingReply))
+		m.awaitingReply = time.Time{}
+	}
+}
+
+// response records a request/response latency measured by the caller, for
+// bench where many connections send concurrently.
+func (m *metrics) response(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observeLatency(d)
+}
+
+// observeLatency records d; m.mu must be held.
+func (m *metrics) observeLatency(d time.Duration) {
+	m.latency.observe(d.Seconds())
+	if m.samples != nil {
+		m.samples = append(m.samples, d)
+	}
+	if m.all != nil {
+		m.all = append(m.all, d)
+	}
+}
+
+// receivedBytes counts a message without the latency pairing of received.
+func (m *metrics) receivedBytes(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recvMsgs++
+	m.recvBytes += n
+	m.size.observe(float64(n))
+	m.recvSizes.observe(n)
+	m.winRecv.observe(n)
+}
+
+// sizes summarizes the payload sizes of the whole run.
+func (m *metrics) sizes() sizeSummaries {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sizeSummaries{Received: m.recvSizes.summary(), Sent: m.sentSizes.summary()}
+}
+
+func (m *metrics) reconnect() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.reconnects++
+	m.mu.Unlock()
+}
+
+func (m *metrics) fail(class string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.errors[class]++
+	m.mu.Unlock()
+}
+
+// write renders the metrics in the Prometheus text exposition format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := map[string]int{
+		"postws_messages_sent_total":     m.sentMsgs,
+		"postws_messages_received_total": m.recvMsgs,
+		"postws_bytes_sent_total":        m.sentBytes,
+		"postws_bytes_received_total":    m.recvBytes,
+		"postws_reconnects_total":        m.reconnects,
+	}
+	for _, d := range metricDocs {
+		fmt.Fprintf(w, "# HELP %s %s.\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+		switch d.name {
+		case "postws_errors_total":
+			for _, class := range []string{"dial", "send", "closed", "timeout"} {
+				fmt.Fprintf(w, "%s{class=%q} %d\n", d.name, class, m.errors[class])
+			}
+		case "postws_message_size_bytes":
+			m.size.write(w, d.name)
+		case "postws_response_latency_seconds":
+			m.latency.write(w, d.name)
+		default:
+			fmt.Fprintf(w, "%s %d\n", d.name, values[d.name])
+		}
+	}
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	cum := 0
+	for i, le := range h.buckets {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// serveMetrics starts the -metrics-listen endpoint and returns the function
+// that shuts it down. The port is bound before returning so a collision is
+// reported before any connection is made.
+func serveMetrics(addr string, m *metrics, errw io.Writer) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("-metrics-listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.write(w)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(errw, "metrics: %v\n", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
+
+// printMetricDocs lists the metric names for -help.
+func printMetricDocs(w io.Writer) {
+	fmt.Fprintln(w, "\nMetrics served on /metrics by -metrics-listen:")
+	for _, d := range metricDocs {
+		fmt.Fprintf(w, "  %-34s %-9s %s\n", d.name, d.kind, d.help)
+	}
+}
+
+// startMetrics enables metrics collection and the endpoint when
+// -metrics-listen is set; the returned stop function is always safe to call.
+func (a *app) startMetrics(opts options) (func(), error) {
+	if opts.metricsListen == "" && opts.statsInterval == 0 && opts.statsFile == "" && !opts.sizeStats {
+		return func() {}, nil
+	}
+	m := newMetrics()
+	if opts.statsFile != "" {
+		m.all = []time.Duration{}
+	}
+	buckets := []int64(opts.sizeBuckets)
+	if len(buckets) == 0 {
+		buckets = defaultSizeBuckets
+	}
+	if opts.sizeStats || opts.statsFile != "" || opts.statsInterval > 0 {
+		m.recvSizes, m.sentSizes = newSizeDist(buckets), newSizeDist(buckets)
+	}
+	if opts.statsInterval > 0 {
+		m.winRecv, m.winSent = newSizeDist(buckets), newSizeDist(buckets)
+	}
+	stop := func() {}
+	if opts.metricsListen != "" {
+		var err error
+		if stop, err = serveMetrics(opts.metricsListen, m, a.stderr); err != nil {
+			return nil, err
+		}
+	}
+	a.metrics = m
+	a.out.metrics = m
+	if opts.statsInterval > 0 {
+		m.samples = []time.Duration{}
+		stopCheckpoints := a.startCheckpoints(m, opts.statsInterval)
+		stopServer := stop
+		stop = func() {
+			stopCheckpoints()
+			stopServer()
+		}
+	}
+	if opts.sizeStats {
+		stopMetrics := stop
+		stop = func() {
+			stopMetrics()
+			m.sizes().print(a.stderr)
+		}
+	}
+	return stop, nil
+}
+
+// errMaxSends is returned by send once -max-sends messages have been sent;
+// callers stop sending and only keep receiving.
+var errMaxSends = errors.New("-max-sends reached")
+
+// send writes payload, counts it and notes the time for
+// -heartbeat-idle-only. It is the one place every payload goes through, so
+// it also enforces -max-sends across all sending paths.
+func (a *app) send(ctx context.Context, c *client.Client, payload []byte) error {
+	if a.maxSends > 0 && a.sends.Add(1) > a.maxSends {
+		a.capReported.Do(func() {
+			fmt.Fprintf(a.stderr, "-max-sends %d reached; only receiving from now on\n", a.maxSends)
+		})
+		return errMaxSends
+	}
+	at := time.Now()
+	var err error
+	if pm := a.repeats.prepared(a.sendType, payload); pm != nil {
+		err = c.SendPrepared(ctx, pm)
+	} else {
+		err = c.SendMessage(ctx, a.sendType, payload)
+	}
+	if err != nil {
+		a.metrics.fail("send")
+		a.events.emit("error", map[string]any{"error": err.Error(), "phase": "send"})
+		return err
+	}
+	a.metrics.sent(len(payload), at)
+	a.lastSend.Store(at.UnixNano())
+	a.har.message("send", a.sendType, payload, at)
+	a.hashes.sent(payload)
+	a.events.emit("sent", map[string]any{"type": frameType(a.sendType), "bytes": len(payload), "data": dataField(payload)})
+	return nil
+}
+
+// printSent writes the "sent:" line of payload, naming the frame type when
+// -message-type made it binary.
+func (a *app) printSent(w io.Writer, payload []byte) {
+	if a.sendType == websocket.BinaryMessage {
+		fmt.Fprintf(w, "sent (binary): %s\n", payload)
+		return
+	}
+	fmt.Fprintf(w, "sent: %s\n", payload)
+}
+
+// frameType names a message type for events and output.
+func frameType(msgType int) string {
+	if msgType == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// lifetimeBuckets are the upper bounds of the -monitor lifetime histogram;
+// the last bucket takes everything longer.
+var lifetimeBuckets = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour}
+
+// histogramWidth is the length of the longest lifetime histogram bar.
+const histogramWidth = 40
+
+// monitorReport is the bookkeeping of a -monitor run.
+type monitorReport struct {
+	start      time.Time
+	up         time.Duration   // total time spent connected
+	lifetimes  []time.Duration // one per connection, including the last one
+	drops      int             // connections ended by the server or the network
+	unexpected int             // drops with a close code other than 1000
+	dialFails  int
+}
+
+// monitor holds a connection open, pinging it every -monitor-ping-interval,
+// and logs every disconnect before reconnecting. It stops on Ctrl-C or after
+// -max-duration and prints a report; unexpected drops make it fail.
+func (a *app) monitor(ctx context.Context, opts options, j job) error {
+	if opts.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.maxDuration)
+		defer cancel()
+	}
+	r := &monitorReport{start: time.Now()}
+	backoff := newBackoff(opts.reconnectDelay, opts.reconnectMaxDelay, opts.jitter)
+	for ctx.Err() == nil {
+		var err error
+		if j.first, err = a.connPayload(opts, j.payload); err != nil {
+			return err
+		}
+		c, err := a.connect(ctx, opts, j.signed())
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			a.metrics.fail("dial")
+			r.dialFails++
+			delay := backoff.next()
+			fmt.Fprintf(a.stderr, "%s dial failed: %v; retrying in %s\n", time.Now().Format(time.RFC3339), err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		backoff.reset()
+		lived, code, cause := a.monitorSession(ctx, c, opts, j)
+		r.up += lived
+		r.lifetimes = append(r.lifetimes, lived)
+		if ctx.Err() != nil && cause == nil {
+			break
+		}
+		r.drops++
+		if code != websocket.CloseNormalClosure {
+			r.unexpected++
+		}
+		if cause == nil {
+			cause = c.Err()
+		}
+		delay := backoff.next()
+		fmt.Fprintf(a.stderr, "%s disconnected after %s: code %d: %v; reconnecting in %s\n", time.Now().Format(time.RFC3339), lived.Round(time.Millisecond), code, cause, delay)
+		a.metrics.reconnect()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	r.print(a.stderr, time.Now())
+	if r.unexpected > 0 {
+		return fmt.Errorf("%d unexpected disconnect(s) during -monitor", r.unexpected)
+	}
+	return nil
+}
+
+// monitorSession runs one monitored connection until it ends and returns how
+// long it lived, its close code and, when the monitor itself gave up on the
+// connection (a missed pong), why.
+func (a *app) monitorSession(ctx context.Context, c *client.Client, opts options, j job) (time.Duration, int, error) {
+	connected := time.Now()
+	fmt.Fprintf(a.stderr, "%s connected\n", connected.Format(time.RFC3339))
+	defer a.startHeartbeat(c, opts)()
+
+	if j.payload != nil {
+		switch err := a.send(ctx, c, j.signed()); {
+		case errors.Is(err, errMaxSends):
+			// Keep monitoring without sending.
+		case err != nil:
+			a.closeAndDrain(c, "")
+			return time.Since(connected), closeCode(c.Err()), fmt.Errorf("send message: %w", err)
+		default:
+			a.printSent(a.stdout, j.signed())
+		}
+	}
+
+	pingFailed := make(chan error, 1)
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		ticker := time.NewTicker(opts.monitorPing)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopPing:
+				return
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, opts.monitorPing)
+			_, err := c.Ping(pingCtx)
+			cancel()
+			if err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+				select {
+				case pingFailed <- err:
+				default:
+				}
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.events.emit("closed", map[string]any{"code": closeCode(c.Err()), "error": errorString(c.Err())})
+				return time.Since(connected), closeCode(c.Err()), nil
+			}
+			a.out.handle(msg)
+		case err := <-pingFailed:
+			lived := time.Since(connected)
+			_ = c.Close(websocket.CloseGoingAway, "ping timeout")
+			for range c.Receive() {
+			}
+			return lived, websocket.CloseAbnormalClosure, fmt.Errorf("no pong: %w", err)
+		case <-ctx.Done():
+			lived := time.Since(connected)
+			a.closeAndDrain(c, "monitor finished")
+			return lived, websocket.CloseNormalClosure, nil
+		}
+	}
+}
+
+func (r *monitorReport) print(w io.Writer, now time.Time) {
+	total := now.Sub(r.start)
+	uptime := 0.0
+	if total > 0 {
+		uptime = 100 * float64(r.up) / float64(total)
+	}
+	fmt.Fprintf(w, "monitor: %s, %d connection(s), %d drop(s) (%d unexpected), %d failed dial(s), uptime %.2f%%\n",
+		total.Round(time.Second), len(r.lifetimes), r.drops, r.unexpected, r.dialFails, uptime)
+	if len(r.lifetimes) == 0 {
+		return
+	}
+	counts := make([]int, len(lifetimeBuckets)+1)
+	most := 0
+	for _, d := range r.lifetimes {
+		i := 0
+		for i < len(lifetimeBuckets) && d >= lifetimeBuckets[i] {
+			i++
+		}
+		counts[i]++
+		most = max(most, counts[i])
+	}
+	fmt.Fprintln(w, "connection lifetimes:")
+	for i, n := range counts {
+		var label string
+		if i < len(lifetimeBuckets) {
+			label = "< " + shortDuration(lifetimeBuckets[i])
+		} else {
+			label = ">= " + shortDuration(lifetimeBuckets[i-1])
+		}
+		line := fmt.Sprintf("  %-6s %4d %s", label, n, strings.Repeat("#", (n*histogramWidth+most-1)/most))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+// shortDuration drops the zero units Duration.String adds, e.g. "1h" rather
+// than "1h0m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputFile is the -output destination of the printed messages. With
+// compression the gzip stream is flushed every -output-flush-interval, so
+// the file can be read (e.g. with zcat) while the run is live, and close
+// finishes the stream so the archive is complete. Status lines such as
+// "sent:" stay on stdout.
+type outputFile struct {
+	mu sync.Mutex
+	f  *os.File
+	gz *gzip.Writer // nil unless compressing
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openOutput sets up -output, compressing when the path ends in .gz or
+// -output-compress is set.
+func (a *app) openOutput(opts options) error {
+	if opts.outputPath == "" {
+		return nil
+	}
+	f, err := os.Create(opts.outputPath)
+	if err != nil {
+		return fmt.Errorf("-output: %w", err)
+	}
+	o := &outputFile{f: f}
+	if opts.outputCompress || strings.HasSuffix(opts.outputPath, ".gz") {
+		o.gz = gzip.NewWriter(f)
+		if opts.outputFlush > 0 {
+			o.stop, o.done = make(chan struct{}), make(chan struct{})
+			go o.flushEvery(opts.outputFlush)
+		}
+	}
+	a.output = o
+	a.out.w = o
+	return nil
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.gz != nil {
+		return o.gz.Write(p)
+	}
+	return o.f.Write(p)
+}
+
+func (o *outputFile) flushEvery(interval time.Duration) {
+	defer close(o.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			_ = o.gz.Flush()
+			o.mu.Unlock()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// close completes the gzip stream, if any, and closes the file. It is a
+// no-op on a nil *outputFile.
+func (o *outputFile) close() error {
+	if o == nil {
+		return nil
+	}
+	if o.stop != nil {
+		close(o.stop)
+		<-o.done
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var err error
+	if o.gz != nil {
+		err = o.gz.Close()
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// defaultTermWidth is used for -truncate when stdout is not a terminal.
+const defaultTermWidth = 80
+
+// printer renders received messages. Its zero value plus w and errw prints
+// everything in the default pretty format.
+type printer struct {
+	w    io.Writer
+	errw io.Writer
+
+	truncate        int                // column limit for long string values; 0 disables truncation
+	format          string             // -format; "" and "pretty" are the default format
+	sortKeys        bool               // re-encode JSON with sorted object keys in the pretty and ndjson formats
+	expand          *jsonExpander      // -expand-json-strings in the pretty format
+	delim           string             // written after each message in the raw and ndjson formats
+	binaryDir       string             // save binary messages here instead of printing them
+	binarySaved     int                // number of binary files written so far
+	window          *timeWindow        // drop messages outside -since/-until
+	filter          *waitCondition     // drop messages not matching -filter
+	pipe            *pipeSink          // send messages to a -pipe command instead of printing them
+	demux           *demuxer           // split messages by -demux-field
+	exec            *execRunner        // run the -exec command for every shown message
+	forward         *forwarder         // POST every shown message to -forward-url
+	shown           int                // messages that passed the window and filter
+	received        int                // every message handled, before any filtering
+	recvBytes       int64              // payload bytes of those messages
+	spent           bool               // -max-recv-bytes reached; later messages (drained while closing) are dropped
+	stats           *sessionStats      // -stats counters, fed before any filtering
+	metrics         *metrics           // -metrics-listen counters, likewise
+	events          *eventLog          // -events-json "received" events, likewise
+	strict          *strictChecker     // -strict message checks, likewise
+	captures        *captureSet        // -capture values, likewise
+	har             *harLog            // -har capture, likewise
+	fields          *fieldExpectations // -expect-field assertions, likewise
+	backpressure    *backpressure      // -backpressure-warn lag between receipt and output
+	heartbeats      *heartbeatReplies  // -heartbeat-expect replies, kept out of the output
+	tee             *teeFile           // -tee copy of every shown message as ndjson
+	decode          *fieldDecoder      // -decode-field values unwrapped before filtering and output
+	decoded         []string           // paths decode unwrapped in the message being printed
+	lastOnly        bool               // hold each message instead of printing it; flushLast prints the final one
+	first           bool               // print only the first shown message; later ones (e.g. while draining) are dropped
+	showCompression bool               // note on errw whether each shown message arrived compressed
+	template        *template.Template // -output-template, used instead of format
+	last            client.Message     // the held message
+	lastIndex       int
+	lastDecoded     []string
+	held            bool
+}
+
+// handle routes a received message to its output.
+func (p *printer) handle(msg client.Message) {
+	if p.spent || p.heartbeats.reply(msg) {
+		return
+	}
+	p.received++
+	p.recvBytes += int64(len(msg.Data))
+	defer p.backpressure.observe(msg, p.received)
+	if p.stats != nil {
+		p.stats.observe(msg)
+	}
+	p.metrics.received(msg)
+	if p.events != nil {
+		kind := "text"
+		if msg.Type == websocket.BinaryMessage {
+			kind = "binary"
+		}
+		fields := map[string]any{"type": kind, "bytes": len(msg.Data)}
+		if kind == "text" {
+			fields["data"] = dataField(msg.Data)
+		}
+		p.events.emit("received", fields)
+	}
+	p.strict.message(msg)
+	p.captures.observe(msg)
+	p.har.message("receive", msg.Type, msg.Data, msg.Time)
+	p.fields.observe(msg, p.received)
+	if msg.Type == websocket.TextMessage {
+		msg.Data, p.decoded = p.decode.apply(msg.Data)
+	}
+	if p.window != nil && !p.window.contains(msg.Data) {
+		return
+	}
+	if p.filter != nil && !p.filter.match(msg.Data) {
+		return
+	}
+	if p.first && p.shown > 0 {
+		return
+	}
+	p.shown++
+	if p.tee != nil {
+		p.tee.write(append(ndjsonLine(msg.Data), '\n'))
+	}
+	if p.showCompression {
+		if msg.Compressed {
+			fmt.Fprintf(p.errw, "compression: compressed, %d bytes on the wire for %d\n", msg.WireSize, len(msg.Data))
+		} else {
+			fmt.Fprintf(p.errw, "compression: uncompressed, %d bytes\n", len(msg.Data))
+		}
+	}
+	if p.exec != nil {
+		p.exec.submit(p.shown, msg)
+	}
+	if p.forward != nil {
+		p.forward.submit(msg)
+	}
+	if msg.Type == websocket.BinaryMessage && p.binaryDir != "" {
+		path, err := p.saveBinary(msg.Data)
+		if err != nil {
+			fmt.Fprintf(p.errw, "save binary message: %v\n", err)
+			return
+		}
+		fmt.Fprintf(p.w, "recv: binary (%d bytes) saved to %s\n", len(msg.Data), path)
+		return
+	}
+	if p.demux != nil {
+		channel := p.demux.channel(msg.Data)
+		if p.demux.dir != "" {
+			if err := p.demux.write(channel, msg.Data); err != nil {
+				fmt.Fprintf(p.errw, "demux: %v\n", err)
+			}
+			return
+		}
+		fmt.Fprintf(p.w, "[%s] ", channel)
+	}
+	if p.pipe != nil {
+		p.pipe.write(msg.Data)
+		return
+	}
+	if p.lastOnly {
+		p.last, p.lastIndex, p.lastDecoded, p.held = msg, p.shown, p.decoded, true
+		return
+	}
+	p.printMessage(msg, p.shown)
+}
+
+// flushLast prints the message held by -last-only, if any.
+func (p *printer) flushLast() {
+	if !p.held {
+		return
+	}
+	p.decoded = p.lastDecoded
+	p.printMessage(p.last, p.lastIndex)
+	p.held = false
+}
+
+func (p *printer) saveBinary(data []byte) (string, error) {
+	p.binarySaved++
+	path := filepath.Join(p.binaryDir, fmt.Sprintf("msg-%06d.bin", p.binarySaved))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (p *printer) printMessage(m client.Message, index int) {
+	msg := m.Data
+	if p.template != nil {
+		out, err := renderTemplate(p.template, m, index)
+		if err != nil {
+			fmt.Fprintf(p.errw, "-output-template: message %d: %v; printing it raw\n", index, err)
+			out = msg
+		}
+		fmt.Fprintf(p.w, "%s%s", out, p.delim)
+		return
+	}
+	switch p.format {
+	case "raw":
+		fmt.Fprintf(p.w, "%s%s", msg, p.delim)
+		return
+	}
+	if p.sortKeys {
+		msg = sortedKeys(msg)
+	}
+	switch p.format {
+	case "ndjson":
+		fmt.Fprintf(p.w, "%s%s", ndjsonLine(msg), p.delim)
+		return
+	}
+	var formatted bytes.Buffer
+	err := json.Indent(&formatted, msg, "", "  ")
+	if out, ok := p.expand.indent(msg, "", "  "); ok {
+		formatted.Reset()
+		formatted.Write(out)
+	}
+	if err == nil {
+		out := formatted.String()
+		if p.truncate > 0 {
+			lines := strings.Split(out, "\n")
+			for i, line := range lines {
+				lines[i] = truncateLine(line, p.truncate)
+			}
+			out = strings.Join(lines, "\n")
+		}
+		if len(p.decoded) > 0 {
+			fmt.Fprintf(p.w, "recv (decoded %s):\n%s\n", strings.Join(p.decoded, ", "), out)
+			return
+		}
+		fmt.Fprintf(p.w, "recv:\n%s\n", out)
+		return
+	}
+	line := "recv: " + string(msg)
+	if p.truncate > 0 && !strings.Contains(line, "\n") && utf8.RuneCountInString(line) > p.truncate {
+		line = string([]rune(line)[:p.truncate-1]) + "…"
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// ndjsonLine compacts msg to one JSON value, quoting messages that are not
+// JSON so every line stays one value.
+func ndjsonLine(msg []byte) []byte {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, msg); err != nil {
+		quoted, _ := json.Marshal(string(msg))
+		return quoted
+	}
+	return compact.Bytes()
+}
+
+// sortedKeys re-encodes a JSON message with the keys of every object in
+// sorted order, keeping numbers as written and <, > and & unescaped.
+// Anything that is not a single JSON document is returned unchanged.
+func sortedKeys(msg []byte) []byte {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	if dec.Decode(&doc) != nil || dec.More() {
+		return msg
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if enc.Encode(doc) != nil {
+		return msg
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
+
+// messageDelim is what the raw and ndjson formats write after each message.
+func messageDelim(opts options) string {
+	switch {
+	case opts.noNewline:
+		return ""
+	case opts.nullDelimited:
+		return "\x00"
+	}
+	return "\n"
+}
+
+// truncateLine shortens an indented JSON line whose value is a long string so
+// it fits in width columns, ending the string with an ellipsis. Lines that do
+// not end in a string value are returned unchanged.
+func truncateLine(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	body, suffix := line, `"`
+	switch {
+	case strings.HasSuffix(body, `",`):
+		body, suffix = body[:len(body)-2], `",`
+	case strings.HasSuffix(body, `"`):
+		body = body[:len(body)-1]
+	default:
+		return line
+	}
+
+	start := stringValueStart(body)
+	if start < 0 {
+		return line
+	}
+	keep := width - utf8.RuneCountInString(suffix) - 1
+	runes := []rune(body)
+	minKeep := utf8.RuneCountInString(body[:start])
+	if keep < minKeep {
+		keep = minKeep
+	}
+	if keep >= len(runes) {
+		return line
+	}
+	cut := string(runes[:keep])
+	// Do not end inside an escape sequence such as \" or é.
+	if i := strings.LastIndexByte(cut, '\\'); i >= start && len(cut)-i <= 6 {
+		backslashes := 0
+		for j := i; j >= start && cut[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 1 {
+			cut = cut[:i]
+		}
+	}
+	return cut + "…" + suffix
+}
+
+// stringValueStart returns the byte offset just after the opening quote of
+// the string value on an indented JSON line (either `"key": "value` or an
+// array element `"value`), or -1 if the line's value is not a string.
+func stringValueStart(line string) int {
+	i := len(line) - len(strings.TrimLeft(line, " "))
+	if i >= len(line) || line[i] != '"' {
+		return -1
+	}
+	end := scanString(line, i)
+	if end < 0 {
+		// The first string runs to the end of the line: it is the value.
+		return i + 1
+	}
+	rest := line[end:]
+	if !strings.HasPrefix(rest, `: "`) {
+		return -1
+	}
+	return end + len(`: "`)
+}
+
+// scanString returns the offset just past the closing quote of the JSON
+// string starting at line[i], or -1 if it is not closed on this line.
+func scanString(line string, i int) int {
+	for j := i + 1; j < len(line); j++ {
+		switch line[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+	return -1
+}
+
+// truncateFlag implements -truncate: bare it uses the terminal width, with a
+// value it uses that many columns.
+type truncateFlag struct {
+	width *int
+}
+
+func (t truncateFlag) String() string {
+	if t.width == nil || *t.width == 0 {
+		return ""
+	}
+	return strconv.Itoa(*t.width)
+}
+
+func (t truncateFlag) IsBoolFlag() bool { return true }
+
+func (t truncateFlag) Set(v string) error {
+	switch v {
+	case "true":
+		*t.width = autoWidth()
+		return nil
+	case "false":
+		*t.width = 0
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("want a column count")
+	}
+	if n > 0 && n < 10 {
+		return fmt.Errorf("width %d is too small", n)
+	}
+	*t.width = n
+	return nil
+}
+
+func autoWidth() int {
+	if w, ok := terminalWidth(os.Stdout); ok {
+		return w
+	}
+	return defaultTermWidth
+}
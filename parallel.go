@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connPlaceholder in the payload is replaced by the connection index with
+// -connections.
+const connPlaceholder = "{{conn}}"
+
+// parallelResult collects what the -connections workers observed.
+type parallelResult struct {
+	mu         sync.Mutex
+	succeeded  int
+	failures   []error
+	handshakes []time.Duration
+	latencies  []time.Duration // from the send to the first reply, per connection
+}
+
+func (r *parallelResult) fail(err error) {
+	r.mu.Lock()
+	r.failures = append(r.failures, err)
+	r.mu.Unlock()
+}
+
+// parallel runs the send/receive flow of j on -connections connections at
+// once, each dialing on its own and spread over -ramp-up. Output lines are
+// prefixed with the connection index.
+func (a *app) parallel(ctx context.Context, opts options, j job) error {
+	var mu sync.Mutex // one printer serves every worker
+	out := &prefixWriter{w: a.out.w}
+	a.out.w = out
+	emit := func(id int, fn func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.prefix = "[" + strconv.Itoa(id) + "] "
+		fn()
+		out.prefix = ""
+	}
+
+	var res parallelResult
+	var wg sync.WaitGroup
+	for i := 0; i < opts.connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if delay := rampDelay(opts.ramp, id, opts.connections); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+			payload := j.payload
+			if payload != nil {
+				payload = bytes.ReplaceAll(payload, []byte(connPlaceholder), []byte(strconv.Itoa(id)))
+			}
+			if _, err := a.parallelWorker(ctx, opts, id, payload, &res, emit); err != nil {
+				res.fail(fmt.Errorf("conn %d: %w", id, err))
+				return
+			}
+			res.mu.Lock()
+			res.succeeded++
+			res.mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	res.print(a.stderr, opts.connections)
+	if len(res.failures) > 0 {
+		return fmt.Errorf("%d of %d connections failed", len(res.failures), opts.connections)
+	}
+	return nil
+}
+
+// parallelWorker is one connection of a -connections run: it sends payload
+// and reads until -read-timeout or -expect-count, returning the number of
+// messages received. emit serializes the output.
+func (a *app) parallelWorker(ctx context.Context, opts options, id int, payload []byte, res *parallelResult, emit func(int, func())) (int, error) {
+	payload, err := a.connPayload(opts, payload)
+	if err != nil {
+		return 0, err
+	}
+	c, err := a.connect(ctx, opts, payload)
+	if err != nil {
+		a.metrics.fail("dial")
+		return 0, err
+	}
+	res.mu.Lock()
+	res.handshakes = append(res.handshakes, c.HandshakeDuration())
+	res.mu.Unlock()
+	received := 0
+	var sentAt time.Time
+	drain := func(reason string) {
+		_ = c.Close(websocket.CloseNormalClosure, reason)
+		for msg := range c.Receive() {
+			emit(id, func() { a.out.handle(msg) })
+			received++
+		}
+	}
+
+	if payload != nil {
+		switch err := a.send(ctx, c, payload); {
+		case errors.Is(err, errMaxSends):
+		case err != nil:
+			drain("")
+			return received, fmt.Errorf("send message: %w", err)
+		default:
+			sentAt = time.Now()
+			emit(id, func() { a.printSent(a.out.w, payload) })
+		}
+	}
+
+	var timeout <-chan time.Time
+	if opts.readTimeout > 0 {
+		timeout = time.After(opts.readTimeout)
+	}
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				if code := closeCode(c.Err()); code != websocket.CloseNormalClosure {
+					a.metrics.fail("closed")
+					return received, fmt.Errorf("connection closed: %w", c.Err())
+				}
+				return received, nil
+			}
+			emit(id, func() { a.out.handle(msg) })
+			received++
+			if !sentAt.IsZero() && msg.Time.After(sentAt) {
+				res.mu.Lock()
+				res.latencies = append(res.latencies, msg.Time.Sub(sentAt))
+				res.mu.Unlock()
+				sentAt = time.Time{}
+			}
+			if opts.expectCount > 0 && received >= opts.expectCount {
+				drain("expected messages received")
+				return received, nil
+			}
+		case <-timeout:
+			drain("timeout")
+			return received, nil
+		case <-ctx.Done():
+			drain("interrupted")
+			return received, nil
+		}
+	}
+}
+
+// print reports the outcome of every connection and the spread of their
+// connect times and, when a payload was sent, of the time to its reply.
+func (r *parallelResult) print(w io.Writer, n int) {
+	fmt.Fprintf(w, "connections: %d succeeded, %d failed of %d\n", r.succeeded, len(r.failures), n)
+	printSpread(w, "connect:    ", r.handshakes)
+	printSpread(w, "latency:    ", r.latencies)
+	for _, err := range r.failures {
+		fmt.Fprintf(w, "failed: %v\n", err)
+	}
+}
+
+// printSpread writes the p50/p90/p99/max line of ds, sorting it; nothing
+// when ds is empty.
+func printSpread(w io.Writer, label string, ds []time.Duration) {
+	if len(ds) == 0 {
+		return
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	fmt.Fprintf(w, "%s p50 %s, p90 %s, p99 %s, max %s\n", label,
+		percentile(ds, 50).Round(time.Microsecond),
+		percentile(ds, 90).Round(time.Microsecond),
+		percentile(ds, 99).Round(time.Microsecond),
+		ds[len(ds)-1].Round(time.Microsecond))
+}
+
+// percentile returns the p-th percentile (nearest rank) of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// prefixWriter starts every line written through it with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mid    bool // the last write did not end a line
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var out []byte
+	for len(b) > 0 {
+		if !p.mid {
+			out = append(out, p.prefix...)
+		}
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+		out = append(out, line...)
+		p.mid = line[len(line)-1] != '\n'
+		b = b[len(line):]
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// buildPayload assembles the message to send: the -data-url body, or the
+// -data-file documents merged with the Name=Value pairs on top, or just
+// either, with the -set
+// values, the correlation id (if corrID is not empty) and the -trace-field
+// traceparent stored at their paths.
+func buildPayload(opts options, corrID string) ([]byte, error) {
+	var doc any
+	switch {
+	case opts.dataURL != "":
+		// Sent as fetched unless a value has to be stored in it.
+		if len(opts.sets) == 0 && corrID == "" && opts.traceField == "" {
+			return opts.dataURLBody, nil
+		}
+		dec := json.NewDecoder(bytes.NewReader(opts.dataURLBody))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("-data-url %s: %w", opts.dataURL, err)
+		}
+	case len(opts.dataFiles) == 1 && len(opts.data) == 0:
+		// A single file is sent as is, so it need not be an object.
+		path := opts.dataFiles[0]
+		raw, err := readDataFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(opts.sets) == 0 && corrID == "" && opts.traceField == "" {
+			return raw, nil
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("-data-file %s: %w", path, err)
+		}
+	case len(opts.dataFiles) > 0:
+		obj, err := mergeDataFiles(opts)
+		if err != nil {
+			return nil, err
+		}
+		doc = obj
+	default:
+		obj := make(map[string]any, len(opts.data))
+		for k, v := range opts.data {
+			obj[k] = v
+		}
+		doc = obj
+	}
+
+	var err error
+	for _, set := range opts.sets {
+		ptr, value, _ := strings.Cut(set, "=")
+		if doc, err = setPointer(doc, ptr, setValue(value)); err != nil {
+			return nil, fmt.Errorf("-set: %w", err)
+		}
+	}
+	if corrID != "" {
+		if doc, err = setField(doc, opts.correlationField, corrID); err != nil {
+			return nil, fmt.Errorf("-correlation-field: %w", err)
+		}
+	}
+	if opts.traceField != "" {
+		if doc, err = setField(doc, opts.traceField, opts.traceparent); err != nil {
+			return nil, fmt.Errorf("-trace-field: %w", err)
+		}
+	}
+
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+	return payload, nil
+}
+
+// mergeDataFiles layers the -data-file objects in order, later files
+// overriding earlier keys, and puts the Name=Value pairs on top. Nested
+// objects are replaced whole unless -deep-merge merges them key by key;
+// arrays and other values are always replaced.
+func mergeDataFiles(opts options) (map[string]any, error) {
+	merged := make(map[string]any)
+	for _, path := range opts.dataFiles {
+		raw, err := readDataFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var obj map[string]any
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil || obj == nil {
+			return nil, fmt.Errorf("-data-file %s: only JSON objects can be merged", path)
+		}
+		mergeObject(merged, obj, opts.deepMerge)
+	}
+	for k, v := range opts.data {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// mergeObject copies src into dst, recursing into objects present in both
+// when deep is set.
+func mergeObject(dst, src map[string]any, deep bool) {
+	for k, v := range src {
+		if deep {
+			sub, dstOK := dst[k].(map[string]any)
+			srcSub, srcOK := v.(map[string]any)
+			if dstOK && srcOK {
+				mergeObject(sub, srcSub, true)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// splitFields renders every Name=Value pair as its own one-key object, in
+// command-line order, for -split-fields.
+func splitFields(opts options) ([][]byte, error) {
+	parts := make([][]byte, 0, len(opts.dataOrder))
+	for _, name := range opts.dataOrder {
+		part, err := json.Marshal(map[string]string{name: opts.data[name]})
+		if err != nil {
+			return nil, fmt.Errorf("marshal payload: %w", err)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// dataFileFlag collects repeated -data-file paths, merged in order.
+type dataFileFlag []string
+
+func (d *dataFileFlag) String() string {
+	return strings.Join(*d, ", ")
+}
+
+func (d *dataFileFlag) Set(v string) error {
+	*d = append(*d, v)
+	return nil
+}
+
+// setField stores value under field: a JSON Pointer when it starts with
+// "/", otherwise a top-level key of the payload object.
+func setField(doc any, field string, value any) (any, error) {
+	if strings.HasPrefix(field, "/") {
+		return setPointer(doc, field, value)
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("payload is not an object; use a JSON Pointer such as /meta/id")
+	}
+	obj[field] = value
+	return obj, nil
+}
+
+// setValue interprets a -set value as JSON when it parses as such (42,
+// true, {"a":1}, "quoted"), and as a plain string otherwise.
+func setValue(s string) any {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		return v
+	}
+	return s
+}
+
+// setFlag collects repeated -set POINTER=VALUE flags.
+type setFlag []string
+
+func (s *setFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *setFlag) Set(v string) error {
+	ptr, _, ok := strings.Cut(v, "=")
+	if !ok || !strings.HasPrefix(ptr, "/") {
+		return fmt.Errorf("invalid -set %q (want /json/pointer=value)", v)
+	}
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// payloadHash returns the hex SHA-256 of payload, the -payload-hash-header
+// value.
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadHashes follows the hash of the messages actually sent for
+// -payload-hash-header. The header is set at every (re)dial from the payload
+// about to be sent, but cannot change while the connection is open, so a
+// send whose bytes hash differently (a -transform counter, a -stdin-lines
+// line, a -watch-file edit, ...) has its hash printed on stderr instead.
+// A nil *payloadHashes does nothing.
+type payloadHashes struct {
+	header string
+	errw   io.Writer
+
+	mu     sync.Mutex
+	last   string
+	inDial bool // a handshake header was sent
+}
+
+func newPayloadHashes(header string, errw io.Writer) *payloadHashes {
+	if header == "" {
+		return nil
+	}
+	return &payloadHashes{header: header, errw: errw}
+}
+
+// dialed records the hash put in the handshake header.
+func (h *payloadHashes) dialed(hash string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.last, h.inDial = hash, true
+	h.mu.Unlock()
+}
+
+// sent prints the hash of payload unless it is the one last reported.
+func (h *payloadHashes) sent(payload []byte) {
+	if h == nil {
+		return
+	}
+	hash := payloadHash(payload)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hash == h.last {
+		return
+	}
+	h.last = hash
+	if h.inDial {
+		fmt.Fprintf(h.errw, "payload hash: sha256 %s (differs from the %s handshake header)\n", hash, h.header)
+		return
+	}
+	fmt.Fprintf(h.errw, "payload hash: sha256 %s\n", hash)
+}
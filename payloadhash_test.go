@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPayloadHashCoversTransformedPayload(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-expect-count", "1",
+		"-payload-hash-header", "X-Payload-Hash", "-transform", "/seq={{seq}}", "a=1")
+	if r.err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	msgs := s.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(msgs))
+	}
+	sum := sha256.Sum256(msgs[0])
+	if got, want := s.handshakes()[0].Get("X-Payload-Hash"), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("X-Payload-Hash = %s, want %s (hash of %s)", got, want, msgs[0])
+	}
+	if strings.Contains(r.stderr, "payload hash:") {
+		t.Errorf("the hash of the first send was reported as differing:\n%s", r.stderr)
+	}
+}
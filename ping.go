@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxPingPayload is the most application data a control frame can carry.
+const maxPingPayload = 125
+
+// parsePingPayload reads -ping-payload: hex with a hex: prefix, otherwise
+// the text itself.
+func parsePingPayload(spec string) ([]byte, error) {
+	data := []byte(spec)
+	if h, ok := strings.CutPrefix(spec, "hex:"); ok {
+		var err error
+		if data, err = hex.DecodeString(h); err != nil {
+			return nil, fmt.Errorf("invalid -ping-payload %q: %v", spec, err)
+		}
+	}
+	if len(data) > maxPingPayload {
+		return nil, fmt.Errorf("-ping-payload is %d bytes; a control frame carries at most %d", len(data), maxPingPayload)
+	}
+	return data, nil
+}
+
+// ping measures control-frame round-trip time like ping(8): one line per
+// pong and a min/avg/max summary. It fails when no pong came back at all.
+func (a *app) ping(ctx context.Context, opts options) error {
+	if opts.dryRun {
+		return a.dryRun(opts, nil, nil)
+	}
+	c, err := a.connect(ctx, opts, nil)
+	if err != nil {
+		return err
+	}
+	defer a.closeAndDrain(c, "")
+	if resp := c.Response(); resp != nil {
+		fmt.Fprintf(a.stderr, "connected: %s\n", resp.Status)
+	}
+	if len(opts.pingPayload) > 0 {
+		shown := fmt.Sprintf("%q", opts.pingPayload)
+		if !utf8.Valid(opts.pingPayload) {
+			shown = "hex:" + hex.EncodeToString(opts.pingPayload)
+		}
+		fmt.Fprintf(a.stderr, "ping payload (%d bytes): %s\n", len(opts.pingPayload), shown)
+	}
+
+	// Pongs are processed by the read loop, so keep consuming messages.
+	go func() {
+		for msg := range c.Receive() {
+			a.out.handle(msg)
+		}
+	}()
+
+	var sent, received int
+	var minRTT, maxRTT, total time.Duration
+	for seq := 1; opts.pingCount == 0 || seq <= opts.pingCount; seq++ {
+		if seq > 1 {
+			select {
+			case <-time.After(opts.pingInterval):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		sent++
+		pingCtx := ctx
+		var cancel context.CancelFunc = func() {}
+		if opts.readTimeout > 0 {
+			pingCtx, cancel = context.WithTimeout(ctx, opts.readTimeout)
+		}
+		a.events.emit("ping", map[string]any{"seq": seq})
+		rtt, err := c.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			a.events.emit("error", map[string]any{"error": err.Error(), "phase": "ping", "seq": seq})
+			fmt.Fprintf(a.stdout, "seq=%d no pong: %v\n", seq, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		a.events.emit("pong", map[string]any{"seq": seq, "rtt_ms": float64(rtt.Microseconds()) / 1000})
+		received++
+		total += rtt
+		if received == 1 || rtt < minRTT {
+			minRTT = rtt
+		}
+		if rtt > maxRTT {
+			maxRTT = rtt
+		}
+		fmt.Fprintf(a.stdout, "pong seq=%d time=%s\n", seq, rtt.Round(time.Microsecond))
+	}
+
+	fmt.Fprintf(a.stdout, "%d pings sent, %d pongs received", sent, received)
+	if received > 0 {
+		avg := total / time.Duration(received)
+		fmt.Fprintf(a.stdout, ", rtt min/avg/max = %s/%s/%s", minRTT.Round(time.Microsecond), avg.Round(time.Microsecond), maxRTT.Round(time.Microsecond))
+	}
+	fmt.Fprintln(a.stdout)
+	if received == 0 {
+		return fmt.Errorf("no pongs received")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPingCountsPongs(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) { s.drain(conn) })
+	r := runCommand(t, "ping", "-url", s.url, "-path", "/ws", "-count", "3", "-interval", "10ms")
+	if r.err != nil {
+		t.Fatalf("ping: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	for _, want := range []string{"pong seq=1 ", "pong seq=3 ", "3 pings sent, 3 pongs received"} {
+		if !strings.Contains(r.stdout, want) {
+			t.Errorf("stdout lacks %q:\n%s", want, r.stdout)
+		}
+	}
+}
+
+func TestPingFailsWithoutPongs(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		conn.SetPingHandler(func(string) error { return nil }) // never answer
+		s.drain(conn)
+	})
+	r := runCommand(t, "ping", "-url", s.url, "-path", "/ws", "-count", "1", "-read-timeout", "100ms")
+	if r.err == nil || !strings.Contains(r.err.Error(), "no pongs") {
+		t.Fatalf("err = %v, want no pongs received", r.err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// pipeSink streams received messages, one per line, to the stdin of an
+// external command started with -pipe.
+type pipeSink struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	errw   io.Writer
+	broken bool
+}
+
+func startPipe(command string, stdout, stderr io.Writer) (*pipeSink, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("-pipe: %w", err)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("-pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("-pipe: %w", err)
+	}
+	return &pipeSink{cmd: cmd, stdin: stdin, errw: stderr}, nil
+}
+
+// write sends one message. JSON is compacted so each message stays on a
+// single line. After the command stops reading, further messages are
+// dropped with a single warning.
+func (p *pipeSink) write(data []byte) {
+	if p.broken {
+		return
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, data); err != nil {
+		line.Reset()
+		line.Write(data)
+	}
+	line.WriteByte('\n')
+	if _, err := p.stdin.Write(line.Bytes()); err != nil {
+		p.broken = true
+		fmt.Fprintf(p.errw, "pipe: %s stopped reading: %v\n", p.cmd.Path, err)
+	}
+}
+
+// close ends the command's input and waits for it to exit.
+func (p *pipeSink) close() {
+	_ = p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		fmt.Fprintf(p.errw, "pipe: %s: %v\n", p.cmd.Path, err)
+	}
+}
+
+// splitCommand splits a command line into arguments, honouring single and
+// double quotes and backslash escapes, without invoking a shell.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
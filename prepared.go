@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// repeatCache spots a payload that is sent again unchanged, as in bench or
+// -watch with a static payload, and hands out a prepared message for it
+// from the second send on. A differing payload (templates, ids, {{conn}})
+// simply replaces the entry, so those sends fall back to plain writes.
+type repeatCache struct {
+	last atomic.Pointer[repeatEntry]
+}
+
+type repeatEntry struct {
+	data []byte
+	msg  *client.PreparedMessage // nil until the payload was seen twice
+}
+
+// prepared returns the prepared form of payload, as a msgType frame, when it
+// repeats the last one, and nil otherwise. Racing callers at worst prepare
+// twice.
+func (r *repeatCache) prepared(msgType int, payload []byte) *client.PreparedMessage {
+	e := r.last.Load()
+	if e == nil || !bytes.Equal(e.data, payload) {
+		r.last.Store(&repeatEntry{data: bytes.Clone(payload)})
+		return nil
+	}
+	if e.msg != nil {
+		return e.msg
+	}
+	msg, err := client.Prepare(msgType, e.data)
+	if err != nil {
+		return nil
+	}
+	r.last.CompareAndSwap(e, &repeatEntry{data: e.data, msg: msg})
+	return msg
+}
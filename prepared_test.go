@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/zsuzuki/postws/client"
+)
+
+func TestRepeatCache(t *testing.T) {
+	var r repeatCache
+	if r.prepared(websocket.TextMessage, []byte("a")) != nil {
+		t.Fatal("first send of a payload was prepared")
+	}
+	pm := r.prepared(websocket.TextMessage, []byte("a"))
+	if pm == nil || pm.Len() != 1 {
+		t.Fatalf("repeated payload: prepared = %v, want a message of 1 byte", pm)
+	}
+	if again := r.prepared(websocket.TextMessage, []byte("a")); again != pm {
+		t.Error("third send prepared the payload again")
+	}
+	if r.prepared(websocket.TextMessage, []byte("b")) != nil {
+		t.Error("a different payload was prepared")
+	}
+}
+
+// BenchmarkAppSend sends through app.send, which switches to a prepared
+// message once a payload repeats, against a payload that changes every time
+// and so is always written plainly.
+func BenchmarkAppSend(b *testing.B) {
+	s := newTestServer(b, func(_ *testServer, conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	isolateEnv(b)
+	opts, err := parseFlags([]string{"send", "-url", s.url, "-path", "/ws", "a=1"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		payload func(i int) []byte
+	}{
+		{"repeated", func(int) []byte { return []byte(`{"type":"tick","value":"12345"}`) }},
+		{"changing", func(i int) []byte { return []byte(`{"type":"tick","value":"` + strconv.Itoa(10000+i%90000) + `"}`) }},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			a := newApp(opts, io.Discard, io.Discard)
+			ctx := context.Background()
+			c, err := client.Connect(ctx, client.Options{URL: s.url + "/ws"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Drop()
+			payloads := make([][]byte, 1024)
+			for i := range payloads {
+				payloads[i] = tc.payload(i)
+			}
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				if err := a.send(ctx, c, payloads[i%len(payloads)]); err != nil {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	}
+}
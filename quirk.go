@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// handshakeQuirks names the -handshake-quirk values.
+var handshakeQuirks = []string{"no-version", "origin-header", "lenient-connection"}
+
+// quirkFlag collects repeated -handshake-quirk names.
+type quirkFlag struct {
+	names  []string
+	quirks client.HandshakeQuirks
+}
+
+func (q *quirkFlag) String() string {
+	return strings.Join(q.names, ",")
+}
+
+func (q *quirkFlag) Set(v string) error {
+	for _, name := range strings.Split(v, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "no-version":
+			q.quirks.OmitVersion = true
+		case "origin-header":
+			q.quirks.OriginHeader = true
+		case "lenient-connection":
+			q.quirks.LenientConnection = true
+		default:
+			return fmt.Errorf("unknown handshake quirk %q (want %s)", name, strings.Join(handshakeQuirks, ", "))
+		}
+		q.names = append(q.names, name)
+	}
+	return nil
+}
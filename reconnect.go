@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeCode extracts the close code from the error that ended the read
+// loop: the code of the server's close frame, or 1006 (abnormal closure)
+// when the connection dropped without one.
+func closeCode(err error) int {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return websocket.CloseAbnormalClosure
+}
+
+// backoff doubles the reconnect delay after each consecutive failure, up to
+// max. With jitter it applies "full jitter": each delay is drawn uniformly
+// from [0, d] where d is the doubled value, so clients that failed together
+// spread their retries instead of returning in lockstep.
+type backoff struct {
+	base, max, cur time.Duration
+	jitter         *jitterSource // nil disables jitter
+}
+
+func newBackoff(base, max time.Duration, jitter *jitterSource) *backoff {
+	return &backoff{base: base, max: max, jitter: jitter}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.base
+	} else {
+		b.cur *= 2
+	}
+	if b.max > 0 && b.cur > b.max {
+		b.cur = b.max
+	}
+	if b.jitter != nil {
+		return b.jitter.upTo(b.cur)
+	}
+	return b.cur
+}
+
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+// jitterSource is the random source of -retry-jitter, shared by every
+// backoff of a run. -seed makes it reproducible.
+type jitterSource struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newJitterSource(seed int64) *jitterSource {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &jitterSource{rng: rand.New(rand.NewPCG(uint64(seed), 0))}
+}
+
+// upTo returns a random duration in [0, d].
+func (j *jitterSource) upTo(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return time.Duration(j.rng.Int64N(int64(d) + 1))
+}
+
+// codeList is a comma-separated list of close codes, e.g. "1006,1011".
+type codeList []int
+
+func (l *codeList) String() string {
+	parts := make([]string, len(*l))
+	for i, c := range *l {
+		parts[i] = strconv.Itoa(c)
+	}
+	return strings.Join(parts, ",")
+}
+
+// errLoadShed ends a run whose -retry-after-attempts ran out; main exits
+// with exitLoadShed for it, so load shedding is told apart from failures.
+var errLoadShed = errors.New("server is shedding load")
+
+const exitLoadShed = 3
+
+func (l codeList) has(code int) bool {
+	return slices.Contains(l, code)
+}
+
+func (l *codeList) Set(v string) error {
+	*l = nil
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 1000 || code > 4999 {
+			return fmt.Errorf("invalid close code %q (want 1000-4999)", part)
+		}
+		*l = append(*l, code)
+	}
+	return nil
+}
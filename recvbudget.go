@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+// budgetReason is the close reason once -max-recv-bytes is spent.
+const budgetReason = "-max-recv-bytes reached"
+
+// budgetSpent reports whether the payload bytes received so far reach
+// -max-recv-bytes. The message that crosses the budget is still printed in
+// full; the caller then closes the connection and the run ends cleanly.
+func (a *app) budgetSpent() bool {
+	if a.maxRecvBytes <= 0 || a.out.recvBytes < a.maxRecvBytes {
+		return false
+	}
+	if !a.out.spent {
+		a.out.spent = true
+		a.stopReason = "max-recv-bytes"
+		fmt.Fprintf(a.stderr, "received %d bytes, the -max-recv-bytes budget of %s; closing connection\n", a.out.recvBytes, formatSize(a.maxRecvBytes))
+	}
+	return true
+}
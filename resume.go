@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// resumeReport counts how the -resume-payload reconnects went.
+type resumeReport struct {
+	fallbacks         int // reconnects that sent the normal payload instead
+	succeeded, failed int
+}
+
+// record notes the outcome of a resumed session: it succeeded when the
+// server answered before the session ended.
+func (r *resumeReport) record(ok bool) {
+	if ok {
+		r.succeeded++
+	} else {
+		r.failed++
+	}
+}
+
+func (r *resumeReport) print(w io.Writer) {
+	if r.succeeded+r.failed+r.fallbacks == 0 {
+		return
+	}
+	fmt.Fprintf(w, "resume: %d succeeded, %d failed; %d reconnect(s) sent the normal payload\n", r.succeeded, r.failed, r.fallbacks)
+}
+
+// resumeJob returns the job for reconnect attempt n: j with -resume-payload
+// in place of the payload once every capture it refers to is populated,
+// and j itself otherwise.
+func (a *app) resumeJob(j job, opts options, n int, r *resumeReport) job {
+	_, missing := a.captures.expand([]byte(opts.resumePayload))
+	if len(missing) > 0 {
+		r.fallbacks++
+		fmt.Fprintf(a.stderr, "reconnect %d: -capture %s not captured yet; sending the normal payload\n", n, strings.Join(missing, ", "))
+		return j
+	}
+	fmt.Fprintf(a.stderr, "reconnect %d: sending -resume-payload\n", n)
+	return job{payload: []byte(opts.resumePayload), wait: j.wait, resumed: true}
+}
@@ -0,0 +1,988 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// app runs the subcommands. Output goes to stdout/stderr and connections
+// are opened through dial, so the whole flow can be driven in-process with
+// buffers and a dialer of the caller's choosing.
+type app struct {
+	stdin  io.Reader // -stdin-lines input
+	stdout io.Writer
+	stderr io.Writer
+	dial   func(ctx context.Context, opts client.Options) (*client.Client, error)
+	out    *printer
+
+	metrics    *metrics          // nil unless -metrics-listen is set
+	events     *eventLog         // nil unless -events-json is set
+	lastSend   atomic.Int64      // unix nanoseconds of the last a.send
+	lastClose  atomic.Int64      // close code of the last connection to end, for -stats-file
+	repeats    repeatCache       // prepared frame for a payload sent again unchanged
+	sendType   int               // frame type of a.send, from -message-type
+	hashes     *payloadHashes    // nil unless -payload-hash-header is set
+	heartbeats *heartbeatReplies // nil unless -heartbeat-interval is set
+
+	strict    *strictChecker // nil unless -strict is set
+	captures  *captureSet    // nil unless -capture is set
+	transform *transformer   // nil unless -transform is set
+	har       *harLog        // nil unless -har is set
+	output    *outputFile    // nil unless -output is set
+	verbose   bool
+
+	maxRecvBytes int64        // -max-recv-bytes; 0 is unlimited
+	stopReason   string       // why the run stopped early, for -stats-file
+	maxSends     int64        // -max-sends; 0 is unlimited
+	sends        atomic.Int64 // a.send calls so far, successful or not
+	capReported  sync.Once
+}
+
+// newApp returns an app wired to the given streams and the real dialer,
+// with message output configured from opts.
+func newApp(opts options, stdout, stderr io.Writer) *app {
+	var strict *strictChecker
+	if opts.strict {
+		strict = &strictChecker{errw: stderr, wantType: websocket.TextMessage}
+		if opts.expectType == "binary" {
+			strict.wantType = websocket.BinaryMessage
+		}
+	}
+	var expander *jsonExpander
+	if opts.outputPath == "" {
+		// The -output file keeps embedded JSON strings as they arrived.
+		expander = newJSONExpander(&opts.expandStrings, opts.sortKeys)
+	}
+	captures := newCaptureSet(opts.captures, opts.captureTimeout)
+	captures.toFile(opts.captureFile, stderr)
+	var har *harLog
+	if opts.har != "" {
+		har = &harLog{secret: opts.headerCmds}
+	}
+	sendType := websocket.TextMessage
+	if opts.messageType == "binary" {
+		sendType = websocket.BinaryMessage
+	}
+	out := sanitizeWriterFor(stdout, opts.noSanitize)
+	heartbeats := newHeartbeatReplies(opts)
+	if opts.first {
+		// Only the message itself goes to stdout.
+		stdout = stderr
+	}
+	return &app{
+		strict:       strict,
+		captures:     captures,
+		transform:    newTransformer(opts.transforms),
+		har:          har,
+		verbose:      opts.verbose,
+		maxRecvBytes: opts.maxRecvBytes,
+		stdin:        os.Stdin,
+		stdout:       stdout,
+		stderr:       stderr,
+		dial:         client.Connect,
+		maxSends:     int64(opts.maxSends),
+		sendType:     sendType,
+		hashes:       newPayloadHashes(opts.hashHeader, stderr),
+		heartbeats:   heartbeats,
+		out: &printer{
+			w:               out,
+			errw:            stderr,
+			truncate:        opts.truncate,
+			format:          opts.format,
+			sortKeys:        opts.sortKeys,
+			expand:          expander,
+			delim:           messageDelim(opts),
+			binaryDir:       opts.binaryDir,
+			window:          opts.window,
+			filter:          opts.filter,
+			lastOnly:        opts.lastOnly,
+			first:           opts.first,
+			template:        opts.template,
+			fields:          newFieldExpectations(opts.expectFields),
+			showCompression: opts.showCompression,
+			backpressure:    newBackpressure(opts.backpressureWarn, stderr),
+			decode:          newFieldDecoder(opts.decodeFields, stderr),
+			heartbeats:      heartbeats,
+			strict:          strict,
+			captures:        captures,
+			har:             har,
+		},
+	}
+}
+
+// job is the prepared work of a send or listen run, shared by every
+// (re)connection.
+type job struct {
+	payload []byte // nil for listen
+	steps   []scenarioStep
+	wait    *waitCondition
+	corrID  string
+	parts   [][]byte // -split-fields messages, sent instead of payload
+	resumed bool     // payload is the -resume-payload of a reconnect
+
+	// first is payload as the session's first send puts it on the wire,
+	// with captures and -transform applied before the dial so the
+	// handshake signs those bytes; nil leaves that to the send.
+	first []byte
+}
+
+// signed is the payload the handshake headers sign.
+func (j job) signed() []byte {
+	if j.first != nil {
+		return j.first
+	}
+	return j.payload
+}
+
+// firstPayload settles the bytes of the first send of a session: the
+// payload with the -capture values known so far and -transform applied.
+// It returns nil for sessions that do not start by sending the payload,
+// and when a capture is still missing, which the send then waits for;
+// that is an error when the handshake has to sign the payload.
+func (a *app) firstPayload(opts options, j job) ([]byte, error) {
+	if j.payload == nil || j.steps != nil || j.parts != nil || opts.stdinLines {
+		return nil, nil
+	}
+	payload, missing := a.captures.expand(j.payload)
+	if len(missing) > 0 {
+		if opts.hmacSecret != "" || opts.hashHeader != "" {
+			return nil, fmt.Errorf("-capture %s is only known after the handshake, which signs the payload (-hmac-secret, -payload-hash-header)", missing[0])
+		}
+		return nil, nil
+	}
+	return a.transform.apply(payload)
+}
+
+// connPayload is firstPayload for the runs that send the payload once per
+// connection without going through session (-connections, -churn, -chaos,
+// -monitor, -diff): a -capture value still missing is left as is, since
+// they do not wait for one, but -transform always applies.
+func (a *app) connPayload(opts options, payload []byte) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	first, err := a.firstPayload(opts, job{payload: payload})
+	if first != nil || err != nil {
+		return first, err
+	}
+	return a.transform.apply(payload)
+}
+
+func (a *app) run(ctx context.Context, opts options) (err error) {
+	var j job
+	if opts.correlationField != "" {
+		j.corrID = newCorrelationID()
+	}
+
+	payload, err := buildPayload(opts, j.corrID)
+	if err != nil {
+		return err
+	}
+	if opts.command != "listen" && !opts.stdinLines && (opts.inputFIFO == "" || len(opts.data) > 0 || len(opts.dataFiles) > 0 || opts.dataURL != "") {
+		j.payload = payload
+	}
+	if opts.splitFields {
+		if j.parts, err = splitFields(opts); err != nil {
+			return err
+		}
+	}
+
+	defer a.out.flushLast()
+	if opts.first {
+		defer func() {
+			if err == nil && a.out.shown == 0 {
+				err = fmt.Errorf("-first: no message arrived")
+			}
+		}()
+	}
+	if opts.expectRange.set {
+		defer func() {
+			if cerr := a.checkCount(opts); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if a.out.fields != nil {
+		defer func() {
+			if ferr := a.out.fields.report(a.stderr); err == nil {
+				err = ferr
+			}
+		}()
+	}
+	if a.strict != nil {
+		defer func() {
+			if serr := a.strict.err(); err == nil {
+				err = serr
+			}
+		}()
+	}
+
+	if opts.scenario != "" {
+		if j.steps, err = loadScenario(opts.scenario); err != nil {
+			return err
+		}
+	}
+
+	for _, text := range append(append([][]byte{j.payload, []byte(opts.resumePayload)}, j.parts...), stepTexts(j.steps)...) {
+		if err := a.captures.checkRefs(text); err != nil {
+			return err
+		}
+	}
+
+	if opts.waitFor != "" {
+		if j.wait, err = parseCondition("wait-for", opts.waitFor); err != nil {
+			return err
+		}
+	}
+
+	if opts.dryRun {
+		if j.first, err = a.firstPayload(opts, j); err != nil {
+			return err
+		}
+		payloads := stepTexts(j.steps)
+		if j.steps == nil && j.payload != nil {
+			payloads = [][]byte{j.signed()}
+		}
+		if j.parts != nil {
+			payloads = j.parts
+		}
+		return a.dryRun(opts, j.signed(), payloads)
+	}
+
+	if opts.pipe != "" {
+		pipe, err := startPipe(opts.pipe, a.stdout, a.stderr)
+		if err != nil {
+			return err
+		}
+		a.out.pipe = pipe
+		defer pipe.close()
+	}
+	if opts.demuxField != "" {
+		d, err := newDemuxer(opts.demuxField, opts.demuxDir)
+		if err != nil {
+			return err
+		}
+		a.out.demux = d
+		defer d.close()
+	}
+	if a.out.binaryDir != "" {
+		if err := os.MkdirAll(a.out.binaryDir, 0o755); err != nil {
+			return fmt.Errorf("-binary-dir: %w", err)
+		}
+	}
+	stopMetrics, err := a.startMetrics(opts)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+	if opts.stats || opts.statsFile != "" {
+		a.out.stats = &sessionStats{}
+		if opts.stats {
+			defer a.out.stats.print(a.stderr)
+		}
+	}
+	if opts.forwardURL != "" {
+		fw := startForwarder(opts, a.stderr)
+		a.out.forward = fw
+		defer func() {
+			if cerr := fw.close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if opts.exec != "" {
+		// With -exec-fail-fast the first failing run cancels ctx, which
+		// ends the session like an interrupt.
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		ex, xerr := startExec(opts, a.stdout, a.stderr, cancel)
+		if xerr != nil {
+			return xerr
+		}
+		a.out.exec = ex
+		defer func() {
+			if cerr := ex.close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	if opts.watch > 0 && opts.watchRedial {
+		return a.watchRedial(ctx, opts, j)
+	}
+	if opts.monitor {
+		return a.monitor(ctx, opts, j)
+	}
+	if opts.chaos {
+		return a.chaos(ctx, opts, j)
+	}
+	if opts.churn > 0 {
+		return a.churn(ctx, opts, j)
+	}
+	if opts.connections > 1 {
+		return a.parallel(ctx, opts, j)
+	}
+	if len(opts.targets) > 0 {
+		return a.fanOut(ctx, opts, j)
+	}
+	if opts.diffSpec != "" {
+		return a.diff(ctx, opts, j)
+	}
+	if opts.inputFIFO != "" {
+		if err := checkFIFO(opts.inputFIFO); err != nil {
+			return err
+		}
+		if opts.maxDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeoutCause(ctx, opts.maxDuration, fmt.Errorf("-max-duration %s reached", opts.maxDuration))
+			defer cancel()
+		}
+	}
+
+	// The delay grows with consecutive failures and starts over once a
+	// session gets connected again; dial failures are only retried once a
+	// first connection has been made.
+	backoff := newBackoff(opts.reconnectDelay, opts.reconnectMaxDelay, opts.jitter)
+	attempts := 0
+	shed, shedCode := 0, 0 // -retry-after-attempts used so far, and the code behind them
+	var resumes *resumeReport
+	if opts.resumePayload != "" {
+		resumes = &resumeReport{}
+		defer resumes.print(a.stderr)
+	}
+	for {
+		sj := j
+		if attempts > 0 && resumes != nil {
+			sj = a.resumeJob(j, opts, attempts, resumes)
+		}
+		before := a.out.received
+		res, err := a.session(ctx, opts, sj)
+		if sj.resumed {
+			resumes.record(err == nil && res.connected && (res.done || a.out.received > before))
+		}
+		var dialErr *dialError
+		retry := err == nil && opts.shouldReconnect(res) ||
+			attempts > 0 && errors.As(err, &dialErr)
+		if !retry && ctx.Err() == nil && opts.retryAfterAttempts > 0 &&
+			(err == nil && res.connected && !res.done && !res.interrupted && opts.retryAfterCodes.has(res.closeCode) ||
+				shed > 0 && errors.As(err, &dialErr)) {
+			// The server asked to try again later; this is retried on its
+			// own budget, with or without -reconnect.
+			if err == nil {
+				shedCode = res.closeCode
+			}
+			shed++
+			if shed > opts.retryAfterAttempts {
+				return fmt.Errorf("%w: still closed with %d after %d retries", errLoadShed, shedCode, opts.retryAfterAttempts)
+			}
+			a.metrics.reconnect()
+			delay := backoff.next()
+			if err != nil {
+				fmt.Fprintf(a.stderr, "%v; retrying in %s (retry %d/%d)\n", err, delay, shed, opts.retryAfterAttempts)
+			} else {
+				fmt.Fprintf(a.stderr, "server closed with %d (-retry-after-codes); retrying in %s (retry %d/%d)\n", res.closeCode, delay, shed, opts.retryAfterAttempts)
+			}
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if !retry || ctx.Err() != nil {
+			return err
+		}
+		shed = 0
+		if res.connected {
+			backoff.reset()
+		}
+		attempts++
+		if opts.reconnectMax > 0 && attempts > opts.reconnectMax {
+			if err != nil {
+				return fmt.Errorf("giving up after %d reconnect attempts: %w", opts.reconnectMax, err)
+			}
+			return fmt.Errorf("giving up after %d reconnect attempts", opts.reconnectMax)
+		}
+		a.metrics.reconnect()
+		delay := backoff.next()
+		if err != nil {
+			fmt.Fprintf(a.stderr, "%v; reconnecting in %s (attempt %d)\n", err, delay, attempts)
+		} else {
+			fmt.Fprintf(a.stderr, "connection closed (code %d); reconnecting in %s (attempt %d)\n", res.closeCode, delay, attempts)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dialError marks a failure to establish the connection, which reconnect
+// retries, as opposed to a failure during the session.
+type dialError struct{ err error }
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// session runs one connection: dial, optional greeting wait, the scenario or
+// payload, and the receive phase.
+func (a *app) session(ctx context.Context, opts options, j job) (res receiveResult, err error) {
+	if j.first, err = a.firstPayload(opts, j); err != nil {
+		return receiveResult{}, err
+	}
+	c, err := a.connect(ctx, opts, j.signed())
+	if err != nil {
+		a.metrics.fail("dial")
+		a.strict.handshake(err)
+		a.printHandshakeBody(err)
+		return receiveResult{}, &dialError{err}
+	}
+	// Every path below ends with closeAndDrain; this only covers early
+	// returns, and Close is a no-op after the first call.
+	defer c.Close(websocket.CloseNormalClosure, "")
+	openCtx, stopOpen := watchMaxOpen(ctx, opts)
+	defer func() {
+		if cause := context.Cause(openCtx); err == nil && errors.Is(cause, errStillOpen) {
+			err = cause
+		}
+		if err == nil && a.heartbeats.gaveUp() && !opts.shouldReconnect(res) {
+			err = fmt.Errorf("%w: %d in a row (-heartbeat-misses)", errHeartbeatLost, opts.heartbeatMisses)
+		}
+		stopOpen()
+	}()
+	ctx = openCtx
+
+	if resp := c.Response(); resp != nil {
+		fmt.Fprintf(a.stderr, "connected: %s\n", resp.Status)
+	}
+	if a.out.stats != nil {
+		a.out.stats.connected(c)
+	}
+	a.captures.connected()
+	defer a.startHeartbeat(c, opts)()
+	ctx, stopIdle := a.watchSendIdle(ctx, opts)
+	defer stopIdle()
+
+	if j.wait != nil {
+		if err := a.awaitMessage(ctx, c, j.wait, opts); err != nil {
+			return receiveResult{connected: true}, err
+		}
+	}
+	if !opts.sendAt.IsZero() {
+		if res, ok := a.holdUntil(ctx, c, opts.sendAt); !ok {
+			return res, nil
+		}
+	}
+
+	if j.steps != nil {
+		err := a.runScenario(ctx, c, j.steps, opts.readTimeout)
+		a.closeAndDrain(c, "")
+		return receiveResult{connected: true, done: true}, err
+	}
+
+	if opts.watchFile {
+		return a.watchFile(ctx, c, opts, j)
+	}
+	if opts.stdinLines {
+		return a.stdinLines(ctx, c, opts)
+	}
+	if opts.inputFIFO != "" {
+		return a.inputFIFO(ctx, c, opts, j)
+	}
+	if opts.watch > 0 && !opts.watchRedial {
+		return a.watchConn(ctx, c, opts, j)
+	}
+	return a.exchange(ctx, c, opts, j, false)
+}
+
+// exchange sends the payload, if any, and receives the responses. With
+// keepOpen the connection is left open when the receive phase completes.
+func (a *app) exchange(ctx context.Context, c *client.Client, opts options, j job, keepOpen bool) (receiveResult, error) {
+	plan := receivePlan{timeout: opts.readTimeout, first: opts.firstMsgTimeout, keepOpen: keepOpen}
+	if !keepOpen {
+		plan.quiet = opts.quietPeriod
+	}
+	if j.corrID != "" {
+		plan.done = correlationMatcher(opts.correlationField, j.corrID)
+		plan.reason = "response received"
+	} else if opts.first {
+		// Only a message the printer showed counts, not one -filter hid.
+		plan.done = func(client.Message) bool { return a.out.shown > 0 }
+		plan.reason = "expected messages received"
+	} else if opts.expectCount > 0 {
+		n := 0
+		plan.done = func(client.Message) bool {
+			n++
+			return n >= opts.expectCount
+		}
+		plan.reason = "expected messages received"
+	} else if r := opts.expectRange; r.set && r.max >= 0 {
+		// The outcome is settled once the count passes the maximum.
+		plan.done = func(client.Message) bool { return a.out.received > r.max }
+		plan.reason = "more messages than expected received"
+	}
+	satisfied := false // plan.done fired between -split-fields parts
+	messages := j.parts
+	if messages == nil && j.payload != nil {
+		messages = [][]byte{j.payload}
+	}
+send:
+	for i, msg := range messages {
+		if i > 0 && opts.messageInterval > 0 {
+			// Keep printing (and so answering pings) while spacing the parts.
+			next := time.After(opts.messageInterval)
+		wait:
+			for {
+				select {
+				case <-next:
+					break wait
+				case in, ok := <-c.Receive():
+					if !ok {
+						a.readFinished(c)
+						return receiveResult{connected: true, closeCode: closeCode(c.Err())}, nil
+					}
+					a.out.handle(in)
+					if plan.done != nil && plan.done(in) {
+						satisfied = true
+					}
+				case <-ctx.Done():
+					break send
+				}
+			}
+		}
+		if i == 0 && j.first != nil {
+			msg = j.first
+		} else {
+			var err error
+			if msg, err = a.withCaptures(ctx, c, msg); err != nil {
+				if ctx.Err() != nil {
+					break send
+				}
+				return receiveResult{connected: true}, err
+			}
+			if msg, err = a.transform.apply(msg); err != nil {
+				return receiveResult{connected: true}, err
+			}
+		}
+		sentAt := time.Now()
+		switch err := a.send(ctx, c, msg); {
+		case errors.Is(err, errMaxSends):
+			// Only receive from now on.
+			break send
+		case err != nil:
+			return receiveResult{connected: true}, fmt.Errorf("send message: %w", err)
+		default:
+			if i == 0 && a.out.stats != nil {
+				a.out.stats.sentPayload(sentAt)
+			}
+			a.printSent(a.stdout, msg)
+		}
+	}
+	if satisfied {
+		if !keepOpen {
+			a.closeAndDrain(c, plan.reason)
+		}
+		return receiveResult{connected: true, done: true}, nil
+	}
+
+	res := a.receive(ctx, c, plan)
+	if res.noResponse {
+		a.metrics.fail("timeout")
+		return res, fmt.Errorf("%w within -first-message-timeout %s", errNoResponse, opts.firstMsgTimeout)
+	}
+	if j.corrID != "" && !res.done && !res.interrupted && !opts.shouldReconnect(res) {
+		a.metrics.fail("timeout")
+		return res, fmt.Errorf("no response with %s=%s arrived", opts.correlationField, j.corrID)
+	}
+	return res, nil
+}
+
+// shouldReconnect reports whether a session that ended with res should be
+// re-established: only when the server side ended it, and with
+// -reconnect-on-codes only for the listed close codes.
+func (o options) shouldReconnect(res receiveResult) bool {
+	if !o.reconnect || !res.connected || res.done || res.timedOut || res.interrupted {
+		return false
+	}
+	if len(o.reconnectCodes) == 0 {
+		return true
+	}
+	for _, code := range o.reconnectCodes {
+		if code == res.closeCode {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitMessage prints incoming messages until one matches the -wait-for
+// condition, failing if it does not arrive within -wait-timeout.
+func (a *app) awaitMessage(ctx context.Context, c *client.Client, wait *waitCondition, opts options) error {
+	waitCtx, cancel := context.WithTimeout(ctx, opts.waitTimeout)
+	defer cancel()
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				return fmt.Errorf("connection closed before a message matching -wait-for %q arrived", opts.waitFor)
+			}
+			a.out.handle(msg)
+			if wait.match(msg.Data) {
+				return nil
+			}
+		case <-waitCtx.Done():
+			a.closeAndDrain(c, "wait timeout")
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("greeting never arrived: no message matching -wait-for %q within %s", opts.waitFor, opts.waitTimeout)
+		}
+	}
+}
+
+// holdUntil prints incoming messages until the -send-at time. It reports
+// false, with the result to return, when the connection ended or ctx was
+// cancelled first. A time already past is only warned about.
+func (a *app) holdUntil(ctx context.Context, c *client.Client, at time.Time) (receiveResult, bool) {
+	wait := time.Until(at)
+	if wait <= 0 {
+		fmt.Fprintf(a.stderr, "warning: -send-at %s is %s in the past; sending now\n", at.Format(time.RFC3339), (-wait).Round(time.Second))
+		return receiveResult{}, true
+	}
+	fmt.Fprintf(a.stderr, "holding the send until %s (%s)\n", at.Format(time.RFC3339), wait.Round(time.Second))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return receiveResult{}, true
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				return receiveResult{connected: true, closeCode: closeCode(c.Err())}, false
+			}
+			a.out.handle(msg)
+		case <-ctx.Done():
+			return a.receive(ctx, c, receivePlan{}), false
+		}
+	}
+}
+
+// receivePlan says when the receive phase is over, besides the server
+// closing the connection.
+type receivePlan struct {
+	timeout time.Duration             // overall limit; 0 waits indefinitely
+	first   time.Duration             // -first-message-timeout; timeout then starts at the first message
+	done    func(client.Message) bool // reports that msg completed the exchange
+	reason  string                    // close reason sent when done fires
+	quiet   time.Duration             // -quiet-period; the stream is complete after this long without a message
+
+	// keepOpen leaves the connection open when done fires or the timeout
+	// elapses, so another exchange can follow.
+	keepOpen bool
+}
+
+// errNoResponse ends a run whose -first-message-timeout elapsed with nothing
+// received; main exits with exitNoResponse for it.
+var errNoResponse = errors.New("no response")
+
+const exitNoResponse = 4
+
+// receiveResult reports how the receive phase ended.
+type receiveResult struct {
+	connected   bool
+	done        bool // plan.done accepted a message
+	timedOut    bool
+	noResponse  bool // plan.first elapsed before any message arrived
+	interrupted bool
+	closeCode   int // close code when the server ended the connection
+}
+
+// receive prints messages until the server closes the connection, the plan
+// is satisfied, its timeout elapses or ctx is cancelled, closing gracefully
+// in all but the first case.
+func (a *app) receive(ctx context.Context, c *client.Client, plan receivePlan) receiveResult {
+	var timer *time.Timer
+	var expired <-chan time.Time
+	arm := func(d time.Duration) { // d == 0 disarms
+		if timer != nil {
+			timer.Stop()
+		}
+		expired = nil
+		if d > 0 {
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+	}
+	defer arm(0)
+	waiting := plan.first > 0 // for the first message
+	if waiting {
+		arm(plan.first)
+	} else {
+		arm(plan.timeout)
+	}
+
+	// The quiet period runs from the start, or with plan.first from the
+	// first message, and starts over with every message.
+	var quiet *time.Timer
+	var quieted <-chan time.Time
+	restartQuiet := func() {
+		if plan.quiet <= 0 {
+			return
+		}
+		if quiet == nil {
+			quiet = time.NewTimer(plan.quiet)
+		} else {
+			quiet.Reset(plan.quiet)
+		}
+		quieted = quiet.C
+	}
+	defer func() {
+		if quiet != nil {
+			quiet.Stop()
+		}
+	}()
+	if !waiting {
+		restartQuiet()
+	}
+	count := 0
+
+	res := receiveResult{connected: true}
+	for {
+		select {
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				res.closeCode = closeCode(c.Err())
+				if res.closeCode != websocket.CloseNormalClosure {
+					a.metrics.fail("closed")
+				}
+				return res
+			}
+			if waiting {
+				// The stream has started; -read-timeout runs from here.
+				waiting = false
+				arm(plan.timeout)
+			}
+			restartQuiet()
+			count++
+			a.out.handle(msg)
+			if a.budgetSpent() {
+				res.done = true
+				a.closeAndDrain(c, budgetReason)
+				return res
+			}
+			if plan.done != nil && plan.done(msg) {
+				res.done = true
+				if !plan.keepOpen {
+					a.closeAndDrain(c, plan.reason)
+				}
+				return res
+			}
+		case <-quieted:
+			// Not an error: a stream without a terminator ends like this.
+			res.timedOut = true
+			a.stopReason = "quiet-period"
+			fmt.Fprintf(a.stderr, "stream complete: no message for %s (-quiet-period), %d message(s) received; closing connection\n", plan.quiet, count)
+			a.closeAndDrain(c, "quiet period")
+			return res
+		case <-ctx.Done():
+			res.interrupted = true
+			if cause := context.Cause(ctx); cause != context.Canceled {
+				fmt.Fprintf(a.stderr, "%v; closing connection\n", cause)
+			} else {
+				fmt.Fprintln(a.stderr, "interrupted; closing connection")
+			}
+			a.closeAndDrain(c, "interrupted")
+			return res
+		case <-expired:
+			if waiting {
+				res.timedOut, res.noResponse = true, true
+				fmt.Fprintf(a.stderr, "no response within %s (-first-message-timeout); closing connection\n", plan.first)
+				a.closeAndDrain(c, "timeout")
+			} else {
+				res.timedOut = true
+				if plan.keepOpen {
+					fmt.Fprintf(a.stderr, "no more messages within %s\n", plan.timeout)
+					return res
+				}
+				fmt.Fprintf(a.stderr, "no more messages within %s; closing connection\n", plan.timeout)
+				a.closeAndDrain(c, "timeout")
+			}
+			return res
+		}
+	}
+}
+
+// clientOptions builds the dial settings from opts: the URL and the shared
+// connection, TLS and auth flags. payload is the message about to be sent,
+// if any, for headers that sign it.
+func clientOptions(opts options, payload []byte) (client.Options, error) {
+	fullURL, err := client.BuildURL(opts.baseURL, opts.path, opts.port)
+	if err != nil {
+		return client.Options{}, err
+	}
+	if opts.insecureTLS && !strings.HasPrefix(fullURL, "wss://") {
+		return client.Options{}, fmt.Errorf("-insecure-skip-verify is only valid with wss:// URLs")
+	}
+
+	header := opts.headers.header()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", userAgent())
+	}
+	if err := opts.headerCmds.apply(header); err != nil {
+		return client.Options{}, err
+	}
+	if opts.traceparent != "" {
+		header.Set("traceparent", opts.traceparent)
+	}
+	if opts.hmacSecret != "" && payload != nil {
+		sig, err := signPayload(opts.hmacSecret, opts.hmacEncode, payload)
+		if err != nil {
+			return client.Options{}, err
+		}
+		header.Set(opts.hmacHeader, sig)
+	}
+	if opts.hashHeader != "" && payload != nil {
+		header.Set(opts.hashHeader, payloadHash(payload))
+	}
+
+	return client.Options{
+		URL:                fullURL,
+		Header:             header,
+		DialTimeout:        opts.dialTimeout,
+		InsecureSkipVerify: opts.insecureTLS,
+		DNSServer:          opts.dnsServer,
+		ChallengeKey:       opts.wsKey,
+		Extensions:         opts.extensions,
+		Quirks:             opts.quirks.quirks,
+		Compression:        opts.compress,
+		SendLimit:          opts.sendLimit,
+		RecvLimit:          opts.recvLimit,
+		ReadDelay:          opts.readDelay.min,
+		ReadDelayMax:       opts.readDelay.max,
+		MaxMessageSize:     opts.maxMessageSize,
+		TrackCompression:   opts.showCompression,
+		NoAutoPong:         opts.noAutoPong,
+		PingPayload:        opts.pingPayload,
+	}, nil
+}
+
+// connect dials with clientOptions and enforces the handshake budget.
+func (a *app) connect(ctx context.Context, opts options, payload []byte) (*client.Client, error) {
+	copts, err := clientOptions(opts, payload)
+	if err != nil {
+		return nil, err
+	}
+	if opts.showPing {
+		note := ""
+		if opts.noAutoPong {
+			note = "; not answered"
+		}
+		copts.OnPing = func(data []byte) {
+			fmt.Fprintf(a.stderr, "%s ping received (%d bytes): %q%s\n", time.Now().Format(time.RFC3339Nano), len(data), data, note)
+		}
+	}
+	if payload != nil {
+		a.hashes.dialed(copts.Header.Get(opts.hashHeader))
+	}
+	a.events.emit("connecting", map[string]any{"url": copts.URL})
+	c, err := a.dial(ctx, copts)
+	if err != nil {
+		a.events.emit("error", map[string]any{"error": err.Error(), "phase": "dial"})
+		if opts.debugErrors {
+			a.printErrorChain(err)
+		}
+		return nil, err
+	}
+	fields := map[string]any{
+		"url":          copts.URL,
+		"handshake_ms": float64(c.HandshakeDuration().Microseconds()) / 1000,
+		"version":      buildInfo().Version,
+	}
+	if resp := c.Response(); resp != nil {
+		fields["status"] = resp.StatusCode
+	}
+	a.events.emit("connected", fields)
+	a.har.connected(copts.URL, c)
+	if opts.verbose {
+		fmt.Fprintf(a.stderr, "handshake took %s\n", c.HandshakeDuration().Round(time.Microsecond))
+		if len(opts.extensions) > 0 {
+			negotiated := "none"
+			if resp := c.Response(); resp != nil && resp.Header.Get("Sec-WebSocket-Extensions") != "" {
+				negotiated = strings.Join(resp.Header.Values("Sec-WebSocket-Extensions"), ", ")
+			}
+			fmt.Fprintf(a.stderr, "extensions offered: %s; negotiated: %s\n", opts.extensions.String(), negotiated)
+		}
+	}
+	if opts.maxHandshake > 0 && c.HandshakeDuration() > opts.maxHandshake {
+		_ = c.Close(websocket.CloseNormalClosure, "handshake too slow")
+		for range c.Receive() {
+		}
+		return nil, fmt.Errorf("handshake took %s, over the -max-handshake-latency budget of %s", c.HandshakeDuration().Round(time.Microsecond), opts.maxHandshake)
+	}
+	return c, nil
+}
+
+// printHandshakeBody shows the response body of a refused upgrade, which
+// usually explains the refusal.
+func (a *app) printHandshakeBody(err error) {
+	var he *client.HandshakeError
+	if !errors.As(err, &he) || len(bytes.TrimSpace(he.Body)) == 0 {
+		return
+	}
+	fmt.Fprintf(a.stderr, "handshake response body:\n%s\n", bytes.TrimRight(he.Body, "\n"))
+}
+
+// closeAndDrain closes the connection normally and prints what is still
+// delivered until the read loop has finished.
+func (a *app) closeAndDrain(c *client.Client, reason string) {
+	a.events.emit("closing", map[string]any{"code": websocket.CloseNormalClosure, "reason": reason})
+	_ = c.Close(websocket.CloseNormalClosure, reason)
+	a.drain(c)
+}
+
+// drain prints whatever is still delivered while the connection closes.
+func (a *app) drain(c *client.Client) {
+	for msg := range c.Receive() {
+		a.out.handle(msg)
+	}
+	a.readFinished(c)
+}
+
+// readFinished reports the end of the read loop.
+func (a *app) readFinished(c *client.Client) {
+	switch {
+	case closeCode(c.Err()) != websocket.CloseNormalClosure:
+		fmt.Fprintf(a.stderr, "read finished: %v\n", c.Err())
+	case a.verbose:
+		// A clean close is expected; only -verbose mentions it.
+		fmt.Fprintln(a.stderr, "read finished: connection closed normally (1000)")
+	}
+	a.strict.closed(c.Err())
+	a.lastClose.Store(int64(closeCode(c.Err())))
+	reportBacklog(a.stderr, c)
+	if n, compressed, wire, ok := c.CompressionStats(); ok {
+		fmt.Fprintf(a.stderr, "compression: %d of %d message(s) arrived compressed, %d payload bytes on the wire\n", compressed, n, wire)
+	}
+	a.events.emit("closed", map[string]any{"code": closeCode(c.Err()), "error": errorString(c.Err())})
+}
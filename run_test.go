@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSendPrintsEchoedResponse(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-expect-count", "1", "msg=hello")
+	if r.err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	if got := s.messages(); len(got) != 1 || string(got[0]) != `{"msg":"hello"}` {
+		t.Errorf("server received %q, want one {\"msg\":\"hello\"}", got)
+	}
+	if !strings.Contains(r.stdout, "recv:\n{\n  \"msg\": \"hello\"\n}\n") {
+		t.Errorf("stdout does not show the pretty response:\n%s", r.stdout)
+	}
+}
+
+func TestSendFormatNDJSON(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-expect-count", "1", "-format", "ndjson", "a=1", "b=two")
+	if r.err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	if !strings.Contains(r.stdout, `{"a":"1","b":"two"}`+"\n") {
+		t.Errorf("stdout has no ndjson line:\n%s", r.stdout)
+	}
+}
+
+func TestSendHandshakeHeaders(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-expect-count", "1", "-H", "X-Test: yes", "msg=hi")
+	if r.err != nil {
+		t.Fatalf("send: %v", r.err)
+	}
+	if h := s.handshakes(); len(h) != 1 || h[0].Get("X-Test") != "yes" {
+		t.Errorf("handshake headers = %v, want X-Test: yes", h)
+	}
+}
+
+func TestSendFirstMessageTimeout(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) { s.drain(conn) })
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-first-message-timeout", "100ms", "msg=hi")
+	if !errors.Is(r.err, errNoResponse) {
+		t.Fatalf("err = %v, want errNoResponse", r.err)
+	}
+}
+
+func TestSendDialFailure(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.Close()
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "msg=hi")
+	var dialErr *dialError
+	if !errors.As(r.err, &dialErr) {
+		t.Fatalf("err = %v, want a dial error", r.err)
+	}
+}
+
+func TestSendDryRunSendsNothing(t *testing.T) {
+	s := newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", s.url, "-path", "/ws", "-dry-run", "msg=hi")
+	if r.err != nil {
+		t.Fatalf("dry run: %v", r.err)
+	}
+	if !strings.Contains(r.stdout, `payload 1: {"msg":"hi"}`) {
+		t.Errorf("stdout does not show the payload:\n%s", r.stdout)
+	}
+	if n := len(s.handshakes()); n != 0 {
+		t.Errorf("dry run made %d connection(s)", n)
+	}
+}
+
+func TestListenStreamUntilServerCloses(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		for _, m := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+				return
+			}
+		}
+		closeNormally(conn)
+	})
+	r := runCommand(t, "listen", "-url", s.url, "-path", "/ws", "-format", "ndjson")
+	if r.err != nil {
+		t.Fatalf("listen: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	if want := "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n"; r.stdout != want {
+		t.Errorf("stdout = %q, want %q", r.stdout, want)
+	}
+	if n := len(s.messages()); n != 0 {
+		t.Errorf("listen sent %d message(s)", n)
+	}
+}
+
+func TestListenReadTimeout(t *testing.T) {
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) { s.drain(conn) })
+	start := time.Now()
+	r := runCommand(t, "listen", "-url", s.url, "-path", "/ws", "-read-timeout", "150ms")
+	if r.err != nil {
+		t.Fatalf("listen: %v", r.err)
+	}
+	if !strings.Contains(r.stderr, "no more messages within 150ms") {
+		t.Errorf("stderr does not report the timeout:\n%s", r.stderr)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("listen took %s", d)
+	}
+}
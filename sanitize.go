@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// sanitizeWriter escapes terminal control characters in printed messages so
+// a payload carrying escape sequences cannot retitle, recolor or move the
+// cursor of the terminal: C0 controls other than newline and tab, DEL and
+// 8-bit C1 bytes become \xNN, and C1 code points in UTF-8 become \uNNNN.
+// An ANSI sequence thus shows up as a literal \x1b[31m, and one cut short by
+// -truncate is just as harmless. It is only used while stdout is a terminal
+// (see -no-sanitize).
+type sanitizeWriter struct {
+	w io.Writer
+}
+
+func (s sanitizeWriter) Write(p []byte) (int, error) {
+	if _, err := s.w.Write(sanitizeControl(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sanitizeWriterFor wraps w when it is a terminal and sanitizing is on.
+func sanitizeWriterFor(w io.Writer, noSanitize bool) io.Writer {
+	if noSanitize || !isTerminal(w) {
+		return w
+	}
+	return sanitizeWriter{w}
+}
+
+// isTerminal reports whether w is a character device such as a terminal,
+// rather than a file or pipe.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func sanitizeControl(p []byte) []byte {
+	if !needsSanitizing(p) {
+		return p
+	}
+	var b bytes.Buffer
+	b.Grow(len(p) + 16)
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			if p[0] >= 0x80 && p[0] <= 0x9f {
+				fmt.Fprintf(&b, `\x%02x`, p[0])
+			} else {
+				b.WriteByte(p[0])
+			}
+		case r < 0x20 && r != '\n' && r != '\t', r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case r >= 0x80 && r <= 0x9f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.Write(p[:size])
+		}
+		p = p[size:]
+	}
+	return b.Bytes()
+}
+
+// needsSanitizing reports whether p holds anything but printable ASCII,
+// newlines and tabs, so plain output is written without copying.
+func needsSanitizing(p []byte) bool {
+	for _, c := range p {
+		if c < 0x20 && c != '\n' && c != '\t' || c >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSanitizeControl(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"plain", `{"a":"b"}`, `{"a":"b"}`},
+		{"newline and tab", "a\n\tb", "a\n\tb"},
+		{"utf-8", "héllo 世界", "héllo 世界"},
+		{"BEL", "ding\a", `ding\x07`},
+		{"CSI color", "\x1b[31mred\x1b[0m", `\x1b[31mred\x1b[0m`},
+		{"CSI cursor", "\x1b[2J\x1b[H", `\x1b[2J\x1b[H`},
+		{"OSC title with BEL", "\x1b]0;owned\a", `\x1b]0;owned\x07`},
+		{"OSC title with ST", "\x1b]2;owned\x1b\\", `\x1b]2;owned\x1b\`},
+		{"DEL", "a\x7fb", `a\x7fb`},
+		{"carriage return", "over\rwrite", `over\x0dwrite`},
+		{"8-bit CSI byte", "\x9b31m", `\x9b31m`},
+		{"C1 CSI code point", "\u009b31m", `\u009b31m`},
+		{"C1 OSC code point", "\u009d0;owned\u009c", `\u009d0;owned\u009c`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(sanitizeControl([]byte(tc.in))); got != tc.want {
+				t.Errorf("sanitizeControl(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSanitizeSplitEscape writes an escape sequence cut across two
+// messages: each half is escaped on its own, so no raw control byte
+// reaches the terminal to combine with the next write.
+func TestSanitizeSplitEscape(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		first, rest string
+		want        string
+	}{
+		{"CSI after ESC", "red\x1b", "[31m", `red\x1b[31m`},
+		{"OSC after ESC", "\x1b", "]0;owned\a", `\x1b]0;owned\x07`},
+		{"UTF-8 C1 between lead and continuation byte", "a\xc2", "\x9b31m", "a\xc2" + `\x9b31m`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := sanitizeWriter{&out}
+			for _, msg := range []string{tc.first, tc.rest} {
+				if n, err := w.Write([]byte(msg)); err != nil || n != len(msg) {
+					t.Fatalf("Write(%q) = %d, %v", msg, n, err)
+				}
+			}
+			if got := out.String(); got != tc.want {
+				t.Errorf("output = %q, want %q", got, tc.want)
+			}
+			for _, c := range []byte{0x1b, 0x07, 0x9b} {
+				if bytes.IndexByte(out.Bytes(), c) >= 0 {
+					t.Errorf("output %q still holds a raw %#x", out.Bytes(), c)
+				}
+			}
+		})
+	}
+}
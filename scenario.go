@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// scenarioStep is one line of a -scenario file: either a message to send
+// (">") or a substring expected in the next received message ("<").
+type scenarioStep struct {
+	line int
+	send bool
+	text string
+}
+
+// loadScenario parses a scenario file. Blank lines and lines starting with
+// "#" are ignored.
+func loadScenario(path string) ([]scenarioStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open scenario: %w", err)
+	}
+	defer f.Close()
+
+	var steps []scenarioStep
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		switch line[0] {
+		case '>':
+			steps = append(steps, scenarioStep{line: lineNo, send: true, text: strings.TrimSpace(line[1:])})
+		case '<':
+			steps = append(steps, scenarioStep{line: lineNo, text: strings.TrimSpace(line[1:])})
+		default:
+			return nil, fmt.Errorf("%s:%d: line must start with '>' (send) or '<' (expect)", path, lineNo)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%s: scenario has no steps", path)
+	}
+	return steps, nil
+}
+
+// stepTexts returns the messages the scenario sends.
+func stepTexts(steps []scenarioStep) [][]byte {
+	var texts [][]byte
+	for _, step := range steps {
+		if step.send {
+			texts = append(texts, []byte(step.text))
+		}
+	}
+	return texts
+}
+
+// runScenario executes the steps in order. Each expectation waits up to
+// timeout (0 waits indefinitely) for the next message; cancelling ctx stops
+// the scenario.
+func (a *app) runScenario(ctx context.Context, c *client.Client, steps []scenarioStep, timeout time.Duration) error {
+	for i, step := range steps {
+		if step.send {
+			text, err := a.withCaptures(ctx, c, []byte(step.text))
+			if err != nil {
+				return fmt.Errorf("step %d (line %d): %w", i+1, step.line, err)
+			}
+			if text, err = a.transform.apply(text); err != nil {
+				return fmt.Errorf("step %d (line %d): %w", i+1, step.line, err)
+			}
+			err = a.send(ctx, c, text)
+			if errors.Is(err, errMaxSends) {
+				fmt.Fprintf(a.stderr, "stopping the scenario before step %d (line %d)\n", i+1, step.line)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("step %d (line %d): send: %w", i+1, step.line, err)
+			}
+			a.printSent(a.stdout, text)
+			continue
+		}
+
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err := a.expectStep(stepCtx, c, i, step, timeout)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+	fmt.Fprintf(a.stderr, "scenario passed: %d steps\n", len(steps))
+	return nil
+}
+
+func (a *app) expectStep(ctx context.Context, c *client.Client, i int, step scenarioStep, timeout time.Duration) error {
+	select {
+	case msg, ok := <-c.Receive():
+		if !ok {
+			return fmt.Errorf("step %d (line %d): connection closed while expecting %q: %v", i+1, step.line, step.text, c.Err())
+		}
+		a.out.handle(msg)
+		if !bytes.Contains(msg.Data, []byte(step.text)) {
+			return fmt.Errorf("step %d (line %d): unexpected message\n  - expected substring: %s\n  + received:           %s", i+1, step.line, step.text, msg.Data)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("step %d (line %d): no message within %s (expected %q)", i+1, step.line, timeout, step.text)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultStreamMessage is the -stream-message template; {seq} and {time}
+// are replaced for every message.
+const defaultStreamMessage = `{"seq":{seq},"time":"{time}"}`
+
+// server is the test server behind the serve subcommand.
+type server struct {
+	a        *app
+	opts     options
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]bool
+	seq   int
+}
+
+// serve runs a WebSocket server that echoes, greets, streams and closes on
+// request, until ctx is cancelled.
+func (a *app) serve(ctx context.Context, opts options) error {
+	s := &server{
+		a:    a,
+		opts: opts,
+		upgrader: websocket.Upgrader{
+			// A local test fixture; accept any origin.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		conns: make(map[*websocket.Conn]bool),
+	}
+	ln, err := net.Listen("tcp", opts.listenAddr)
+	if err != nil {
+		return fmt.Errorf("-listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(opts.path, s.handle)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	fmt.Fprintf(a.stderr, "listening on ws://%s%s\n", ln.Addr(), opts.path)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	fmt.Fprintln(a.stderr, "shutting down")
+	s.closeAll()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		fmt.Fprintf(s.a.stderr, "upgrade from %s: %v\n", r.RemoteAddr, err)
+		return
+	}
+	s.mu.Lock()
+	s.seq++
+	id := s.seq
+	s.conns[conn] = true
+	s.mu.Unlock()
+	fmt.Fprintf(s.a.stderr, "conn %d: opened from %s\n", id, r.RemoteAddr)
+
+	var writeMu sync.Mutex
+	write := func(msgType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		return conn.WriteMessage(msgType, data)
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	if s.opts.serveGreeting != "" {
+		if err := write(websocket.TextMessage, []byte(s.opts.serveGreeting)); err != nil {
+			return
+		}
+	}
+	if s.opts.serveStream > 0 {
+		go s.stream(write, done)
+	}
+
+	received := 0
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			fmt.Fprintf(s.a.stderr, "conn %d: closed after %d messages: %v\n", id, received, err)
+			return
+		}
+		received++
+		if s.opts.verbose {
+			fmt.Fprintf(s.a.stderr, "conn %d: recv %s\n", id, data)
+		}
+		if s.opts.serveEcho {
+			if err := write(msgType, data); err != nil {
+				return
+			}
+		}
+		if s.opts.serveCloseAfter > 0 && received >= s.opts.serveCloseAfter {
+			if s.opts.serveCloseCode == websocket.CloseAbnormalClosure {
+				// 1006 never goes on the wire: drop the connection instead.
+				fmt.Fprintf(s.a.stderr, "conn %d: dropping after %d messages\n", id, received)
+				return
+			}
+			writeMu.Lock()
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(s.opts.serveCloseCode, s.opts.serveCloseReason),
+				time.Now().Add(time.Second))
+			writeMu.Unlock()
+			fmt.Fprintf(s.a.stderr, "conn %d: closing with %d after %d messages\n", id, s.opts.serveCloseCode, received)
+			// Wait for the client's close frame, briefly.
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// stream sends a -stream-message every -stream-interval until done closes.
+func (s *server) stream(write func(int, []byte) error, done <-chan struct{}) {
+	msgType := websocket.TextMessage
+	if s.opts.serveBinary {
+		msgType = websocket.BinaryMessage
+	}
+	ticker := time.NewTicker(s.opts.serveStream)
+	defer ticker.Stop()
+	for seq := 1; ; seq++ {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			msg := strings.NewReplacer(
+				"{seq}", strconv.Itoa(seq),
+				"{time}", now.UTC().Format(time.RFC3339Nano),
+			).Replace(s.opts.serveStreamMsg)
+			if err := write(msgType, []byte(msg)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeAll tells every open connection that the server is going away.
+func (s *server) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultSizeBuckets are the -size-buckets boundaries: ranges 0-1k, 1k-16k,
+// 16k-256k and 256k+, each including its lower bound.
+var defaultSizeBuckets = []int64{1 << 10, 16 << 10, 256 << 10}
+
+// sizeBucketsFlag is -size-buckets: ascending, comma-separated sizes such
+// as 1k,16k,256k.
+type sizeBucketsFlag []int64
+
+func (f *sizeBucketsFlag) String() string {
+	parts := make([]string, len(*f))
+	for i, b := range *f {
+		parts[i] = formatSize(b)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *sizeBucketsFlag) Set(v string) error {
+	var buckets []int64
+	for _, part := range strings.Split(v, ",") {
+		n, err := parseSize(part)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid size %q (want ascending sizes such as 1k,16k,256k)", part)
+		}
+		if len(buckets) > 0 && n <= buckets[len(buckets)-1] {
+			return fmt.Errorf("sizes must be ascending, got %s after %s", formatSize(n), formatSize(buckets[len(buckets)-1]))
+		}
+		buckets = append(buckets, n)
+	}
+	*f = buckets
+	return nil
+}
+
+// sizeDist is the distribution of payload sizes in one direction. It keeps
+// every size for the median and p95. All methods are no-ops on a nil
+// *sizeDist; the caller serializes access.
+type sizeDist struct {
+	buckets []int64
+	counts  []int // len(buckets)+1: below each boundary, then the rest
+	sizes   []int
+	total   int64
+}
+
+func newSizeDist(buckets []int64) *sizeDist {
+	return &sizeDist{buckets: buckets, counts: make([]int, len(buckets)+1)}
+}
+
+func (d *sizeDist) observe(n int) {
+	if d == nil {
+		return
+	}
+	d.sizes = append(d.sizes, n)
+	d.total += int64(n)
+	d.counts[sort.Search(len(d.buckets), func(i int) bool { return int64(n) < d.buckets[i] })]++
+}
+
+// sizeSummary is a sizeDist as reported in -stats-file, -stats-interval
+// checkpoints and the -size-stats summary.
+type sizeSummary struct {
+	Count   int          `json:"count"`
+	Bytes   int64        `json:"bytes"`
+	Min     int          `json:"min"`
+	Median  int          `json:"median"`
+	P95     int          `json:"p95"`
+	Max     int          `json:"max"`
+	Buckets []sizeBucket `json:"buckets"`
+}
+
+type sizeBucket struct {
+	Range string `json:"range"` // e.g. "0-1k", "1k-16k", "256k+"
+	Count int    `json:"count"`
+}
+
+// sizeSummaries holds both directions; a direction without messages is
+// left out.
+type sizeSummaries struct {
+	Received *sizeSummary `json:"received,omitempty"`
+	Sent     *sizeSummary `json:"sent,omitempty"`
+}
+
+func (d *sizeDist) summary() *sizeSummary {
+	if d == nil || len(d.sizes) == 0 {
+		return nil
+	}
+	sorted := append([]int(nil), d.sizes...)
+	sort.Ints(sorted)
+	at := func(p int) int {
+		rank := max((p*len(sorted)+99)/100, 1)
+		return sorted[rank-1]
+	}
+	s := &sizeSummary{
+		Count:  len(sorted),
+		Bytes:  d.total,
+		Min:    sorted[0],
+		Median: at(50),
+		P95:    at(95),
+		Max:    sorted[len(sorted)-1],
+	}
+	for i, n := range d.counts {
+		var label string
+		switch {
+		case i == 0:
+			label = "0-" + formatSize(d.buckets[0])
+		case i == len(d.buckets):
+			label = formatSize(d.buckets[i-1]) + "+"
+		default:
+			label = formatSize(d.buckets[i-1]) + "-" + formatSize(d.buckets[i])
+		}
+		s.Buckets = append(s.Buckets, sizeBucket{Range: label, Count: n})
+	}
+	return s
+}
+
+// print writes the -size-stats summary of both directions.
+func (s sizeSummaries) print(w io.Writer) {
+	for _, dir := range []struct {
+		name string
+		sum  *sizeSummary
+	}{{"received", s.Received}, {"sent", s.Sent}} {
+		if dir.sum == nil {
+			fmt.Fprintf(w, "sizes %s: none\n", dir.name)
+			continue
+		}
+		d := dir.sum
+		fmt.Fprintf(w, "sizes %s: %d message(s), %d bytes; min %d, median %d, p95 %d, max %d\n",
+			dir.name, d.Count, d.Bytes, d.Min, d.Median, d.P95, d.Max)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		for _, b := range d.Buckets {
+			fmt.Fprintf(tw, "  %s\t%d\t%.1f%%\t\n", b.Range, b.Count, 100*float64(b.Count)/float64(d.Count))
+		}
+		tw.Flush()
+	}
+}
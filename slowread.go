@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// readDelayFlag is -read-delay: a fixed duration, or MIN-MAX for a random
+// delay drawn for every message.
+type readDelayFlag struct{ min, max time.Duration }
+
+func (f *readDelayFlag) String() string {
+	if f == nil || f.min == 0 && f.max == 0 {
+		return ""
+	}
+	if f.max > f.min {
+		return f.min.String() + "-" + f.max.String()
+	}
+	return f.min.String()
+}
+
+func (f *readDelayFlag) Set(v string) error {
+	lo, hi, isRange := strings.Cut(v, "-")
+	from, err := time.ParseDuration(lo)
+	if err != nil || from < 0 {
+		return fmt.Errorf("invalid delay %q (want a duration or MIN-MAX)", v)
+	}
+	to := from
+	if isRange {
+		if to, err = time.ParseDuration(hi); err != nil || to < from {
+			return fmt.Errorf("invalid delay range %q (want MIN-MAX with MIN <= MAX)", v)
+		}
+	}
+	f.min, f.max = from, to
+	return nil
+}
+
+// reportBacklog prints, for a connection read with -read-delay or
+// -read-rate, how far the reader fell behind the server.
+func reportBacklog(w io.Writer, c *client.Client) {
+	b, ok := c.Backlog()
+	if !ok {
+		return
+	}
+	fmt.Fprintf(w, "slow reader: %d read(s), %s spent in -read-delay", b.Reads, b.Delayed.Round(time.Millisecond))
+	if b.Measured {
+		fmt.Fprintf(w, "; data already queued before %d read(s), receive queue high-water mark %d bytes", b.Waiting, b.MaxQueued)
+	}
+	fmt.Fprintln(w)
+}
+
+// reportReadRate prints how much -read-rate held the reads back.
+func (a *app) reportReadRate(opts options) {
+	if opts.recvLimit == nil {
+		return
+	}
+	read, held := opts.recvLimit.Sent()
+	fmt.Fprintf(a.stderr, "read rate: %d bytes read, reads held back for %s (limit %s/s)\n",
+		read, held.Round(time.Millisecond), formatSize(opts.readRate))
+}
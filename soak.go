@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// counters is a copy of the metrics counters at one point in time.
+type counters struct {
+	sentMsgs, sentBytes, recvMsgs, recvBytes, reconnects int
+	errors                                               map[string]int
+}
+
+// checkpoint copies the counters and takes the latency samples collected
+// since the previous call.
+func (m *metrics) checkpoint() (counters, []time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := counters{
+		sentMsgs:   m.sentMsgs,
+		sentBytes:  m.sentBytes,
+		recvMsgs:   m.recvMsgs,
+		recvBytes:  m.recvBytes,
+		reconnects: m.reconnects,
+		errors:     make(map[string]int, len(m.errors)),
+	}
+	for k, v := range m.errors {
+		c.errors[k] = v
+	}
+	samples := m.samples
+	m.samples = []time.Duration{}
+	return c, samples
+}
+
+// windowSizes summarizes the payload sizes since the previous call and
+// starts a new window.
+func (m *metrics) windowSizes() sizeSummaries {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := sizeSummaries{Received: m.winRecv.summary(), Sent: m.winSent.summary()}
+	if m.winRecv != nil {
+		m.winRecv, m.winSent = newSizeDist(m.winRecv.buckets), newSizeDist(m.winSent.buckets)
+	}
+	return s
+}
+
+// window is what happened during one -stats-interval.
+type window struct {
+	n          int // checkpoint number, from 1
+	start, end time.Time
+	cur, prev  counters
+	latencies  []time.Duration  // sorted
+	sizes      sizeSummaries    // payload sizes during the window
+	mem        runtime.MemStats // at the end of the window
+}
+
+func (w window) errors() int {
+	n := 0
+	for class, v := range w.cur.errors {
+		n += v - w.prev.errors[class]
+	}
+	return n
+}
+
+func (w window) p99() time.Duration {
+	if len(w.latencies) == 0 {
+		return 0
+	}
+	return percentile(w.latencies, 99)
+}
+
+// worse reports whether w degraded more than other: more errors, then a
+// higher p99 latency.
+func (w window) worse(other window) bool {
+	if a, b := w.errors(), other.errors(); a != b {
+		return a > b
+	}
+	return w.p99() > other.p99()
+}
+
+// fields renders the window as checkpoint event fields. Counts are for the
+// window only; mem_sys_bytes and heap_bytes are the process's memory, as the
+// Go runtime reports it, at the end of the window.
+func (w window) fields() map[string]any {
+	errs := map[string]int{}
+	for _, class := range []string{"dial", "send", "closed", "timeout"} {
+		errs[class] = w.cur.errors[class] - w.prev.errors[class]
+	}
+	f := map[string]any{
+		"checkpoint":     w.n,
+		"window_start":   w.start.Format(time.RFC3339Nano),
+		"window_sec":     w.end.Sub(w.start).Seconds(),
+		"sent":           w.cur.sentMsgs - w.prev.sentMsgs,
+		"received":       w.cur.recvMsgs - w.prev.recvMsgs,
+		"bytes_sent":     w.cur.sentBytes - w.prev.sentBytes,
+		"bytes_received": w.cur.recvBytes - w.prev.recvBytes,
+		"reconnects":     w.cur.reconnects - w.prev.reconnects,
+		"errors":         errs,
+		"mem_sys_bytes":  w.mem.Sys,
+		"heap_bytes":     w.mem.HeapAlloc,
+	}
+	if w.sizes.Received != nil || w.sizes.Sent != nil {
+		f["sizes"] = w.sizes
+	}
+	if n := len(w.latencies); n > 0 {
+		ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+		f["latency_ms"] = map[string]any{
+			"count": n,
+			"p50":   ms(percentile(w.latencies, 50)),
+			"p90":   ms(percentile(w.latencies, 90)),
+			"p99":   ms(percentile(w.latencies, 99)),
+			"max":   ms(w.latencies[n-1]),
+		}
+	}
+	return f
+}
+
+// startCheckpoints emits a "checkpoint" event for every -stats-interval
+// window, to -events-json when it is set and to stderr otherwise. The
+// returned function emits the last, partial window and a "worst_window"
+// event repeating the most degraded one.
+func (a *app) startCheckpoints(m *metrics, interval time.Duration) func() {
+	sink := a.events
+	if sink == nil {
+		sink = &eventLog{enc: json.NewEncoder(a.stderr)}
+	}
+	prev, _ := m.checkpoint()
+	start := time.Now()
+	var n int
+	var worst *window
+	emit := func(end time.Time) {
+		cur, samples := m.checkpoint()
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		n++
+		w := &window{n: n, start: start, end: end, cur: cur, prev: prev, latencies: samples, sizes: m.windowSizes()}
+		runtime.ReadMemStats(&w.mem)
+		sink.emit("checkpoint", w.fields())
+		if worst == nil || w.worse(*worst) {
+			worst = w
+		}
+		prev, start = cur, end
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				emit(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		emit(time.Now())
+		sink.emit("worst_window", worst.fields())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// sessionStats collects the -stats figures. The latency figures describe
+// the first connection only: time to first byte and to the first complete
+// message are measured from the send of the payload, or from the handshake
+// for listen.
+type sessionStats struct {
+	mu        sync.Mutex
+	handshake time.Duration
+	ref       time.Time // reference point for the first-message latencies
+	sent      bool      // ref is the payload send rather than the handshake
+	firstByte time.Duration
+	firstMsg  time.Duration
+	seen      bool // firstByte and firstMsg are set
+	messages  int
+	bytes     int
+}
+
+// connected records the first connection's handshake.
+func (s *sessionStats) connected(c *client.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ref.IsZero() {
+		s.handshake = c.HandshakeDuration()
+		s.ref = time.Now()
+	}
+}
+
+// sentPayload moves the reference point to the first payload send, unless
+// a response has already been measured.
+func (s *sessionStats) sentPayload(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sent && !s.seen {
+		s.ref, s.sent = at, true
+	}
+}
+
+func (s *sessionStats) observe(msg client.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	s.bytes += len(msg.Data)
+	if !s.seen && !s.ref.IsZero() && !msg.FirstByte.Before(s.ref) {
+		s.firstByte = msg.FirstByte.Sub(s.ref)
+		s.firstMsg = msg.Time.Sub(s.ref)
+		s.seen = true
+	}
+}
+
+func (s *sessionStats) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := "handshake"
+	if s.sent {
+		from = "send"
+	}
+	fmt.Fprintln(w, "stats:")
+	fmt.Fprintf(w, "  handshake:      %s\n", s.handshake.Round(time.Microsecond))
+	if s.seen {
+		fmt.Fprintf(w, "  first byte:     %s after %s\n", s.firstByte.Round(time.Microsecond), from)
+		fmt.Fprintf(w, "  first message:  %s after %s\n", s.firstMsg.Round(time.Microsecond), from)
+	} else {
+		fmt.Fprintf(w, "  first byte:     none after %s\n", from)
+	}
+	fmt.Fprintf(w, "  messages:       %d (%d bytes)\n", s.messages, s.bytes)
+}
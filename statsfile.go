@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// runSummary is the -stats-file document. Fields the run did not measure
+// are left out rather than written as zero.
+type runSummary struct {
+	Command    string            `json:"command"`
+	URL        string            `json:"url,omitempty"`
+	Started    time.Time         `json:"started"`
+	DurationMS float64           `json:"duration_ms"`
+	ExitCode   int               `json:"exit_code"`
+	Error      string            `json:"error,omitempty"`
+	Timings    *timingSummary    `json:"timings,omitempty"`
+	Messages   *countSummary     `json:"messages,omitempty"`
+	Bytes      *countSummary     `json:"bytes,omitempty"`
+	Latency    *latencyStats     `json:"latency_ms,omitempty"`
+	Sizes      *sizeSummaries    `json:"sizes,omitempty"`
+	Heartbeats *heartbeatSummary `json:"heartbeats,omitempty"`
+	Reconnects *int              `json:"reconnects,omitempty"` // send and listen only
+	Errors     map[string]int    `json:"errors"`
+	CloseCode  int               `json:"close_code,omitempty"`
+	StopReason string            `json:"stop_reason,omitempty"` // e.g. "max-recv-bytes"
+}
+
+type timingSummary struct {
+	HandshakeMS    float64  `json:"handshake_ms"`
+	FirstByteMS    *float64 `json:"first_byte_ms,omitempty"`
+	FirstMessageMS *float64 `json:"first_message_ms,omitempty"`
+	From           string   `json:"from"` // "send" or "handshake"
+}
+
+type countSummary struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+}
+
+type latencyStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func millis(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+// summary gathers what the run measured into a -stats-file document.
+func (a *app) summary(opts options, started time.Time, err error, exitCode int) runSummary {
+	s := runSummary{
+		Command:    opts.command,
+		Started:    started,
+		DurationMS: millis(time.Since(started)),
+		ExitCode:   exitCode,
+		Errors:     map[string]int{},
+		CloseCode:  int(a.lastClose.Load()),
+		StopReason: a.stopReason,
+		Heartbeats: a.heartbeats.summary(),
+	}
+	if u, uerr := client.BuildURL(opts.baseURL, opts.path, opts.port); uerr == nil {
+		s.URL = u
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if st := a.out.stats; st != nil {
+		st.mu.Lock()
+		if !st.ref.IsZero() {
+			t := &timingSummary{HandshakeMS: millis(st.handshake), From: "handshake"}
+			if st.sent {
+				t.From = "send"
+			}
+			if st.seen {
+				fb, fm := millis(st.firstByte), millis(st.firstMsg)
+				t.FirstByteMS, t.FirstMessageMS = &fb, &fm
+			}
+			s.Timings = t
+		}
+		st.mu.Unlock()
+	}
+	if m := a.metrics; m != nil {
+		m.mu.Lock()
+		s.Messages = &countSummary{Sent: m.sentMsgs, Received: m.recvMsgs}
+		s.Bytes = &countSummary{Sent: m.sentBytes, Received: m.recvBytes}
+		if opts.command == "send" || opts.command == "listen" {
+			n := m.reconnects
+			s.Reconnects = &n
+		}
+		for k, v := range m.errors {
+			s.Errors[k] = v
+		}
+		if n := len(m.all); n > 0 {
+			sorted := append([]time.Duration(nil), m.all...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			s.Latency = &latencyStats{
+				Count: n,
+				P50:   millis(percentile(sorted, 50)),
+				P90:   millis(percentile(sorted, 90)),
+				P99:   millis(percentile(sorted, 99)),
+				Max:   millis(sorted[n-1]),
+			}
+		}
+		m.mu.Unlock()
+		if sizes := m.sizes(); sizes.Received != nil || sizes.Sent != nil {
+			s.Sizes = &sizes
+		}
+	}
+	return s
+}
+
+// writeJSONFile writes v to path atomically, as used for -stats-file and
+// -har: the document goes to a temporary file in the same directory, which
+// is then renamed over path, so readers never see a partial file.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// writeFileAtomic writes data to path through a temporary file that is
+// renamed over it.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
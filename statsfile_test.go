@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestStatsFileCountsLoadShedRetries sheds the first two connections with
+// 1013: the -retry-after-attempts redials are reconnects in the summary.
+func TestStatsFileCountsLoadShedRetries(t *testing.T) {
+	var conns atomic.Int32
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		if conns.Add(1) > 2 {
+			echo(s, conn)
+			return
+		}
+		if _, _, err := s.read(conn); err != nil {
+			return
+		}
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "busy"), time.Now().Add(time.Second))
+		_, _, _ = conn.ReadMessage()
+	})
+	isolateEnv(t)
+	opts, err := parseFlags([]string{"send", "-url", s.url, "-path", "/ws", "-reconnect-delay", "10ms", "-expect-count", "1",
+		"-stats-file", filepath.Join(t.TempDir(), "stats.json"), "a=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr syncBuffer
+	a := newApp(opts, &stdout, &stderr)
+	if err := a.dispatch(context.Background(), opts); err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", err, stderr.String())
+	}
+	sum := a.summary(opts, time.Now(), nil, 0)
+	if sum.Reconnects == nil || *sum.Reconnects != 2 {
+		t.Errorf("reconnects = %v, want 2", sum.Reconnects)
+	}
+	if sum.Messages == nil || sum.Messages.Sent != 3 || sum.Messages.Received != 1 {
+		t.Errorf("messages = %+v, want 3 sent and 1 received", sum.Messages)
+	}
+}
+
+// TestStatsFileLeavesOutUntracked checks that counters nothing measured
+// are missing from the document rather than written as zero.
+func TestStatsFileLeavesOutUntracked(t *testing.T) {
+	isolateEnv(t)
+	for _, tc := range []struct {
+		args    []string
+		metrics bool
+		absent  []string
+	}{
+		{[]string{"ping", "-url", "ws://127.0.0.1:1", "-path", "/"}, false, []string{`"messages"`, `"bytes"`, `"reconnects"`}},
+		{[]string{"bench", "-url", "ws://127.0.0.1:1", "-path", "/", "a=1"}, true, []string{`"reconnects"`}},
+	} {
+		opts, err := parseFlags(tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := newApp(opts, &syncBuffer{}, &syncBuffer{})
+		if tc.metrics {
+			a.metrics = newMetrics()
+		}
+		b, err := json.Marshal(a.summary(opts, time.Now(), nil, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range tc.absent {
+			if strings.Contains(string(b), key) {
+				t.Errorf("%s summary has %s: %s", opts.command, key, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// inflightWindow tracks lines sent but not answered yet for -max-inflight.
+// With -correlation-field a response answers the line whose id it carries;
+// otherwise every received message answers the oldest outstanding line, as
+// with an echo or strict request/response server.
+type inflightWindow struct {
+	max   int
+	field string   // -correlation-field, or "" for FIFO matching
+	ids   []string // outstanding lines in send order ("" when untracked)
+	high  int      // most lines outstanding at once
+	sent  int
+	acked int
+}
+
+func (w *inflightWindow) full() bool {
+	return w != nil && w.max > 0 && len(w.ids) >= w.max
+}
+
+func (w *inflightWindow) add(id string) {
+	if w == nil {
+		return
+	}
+	w.ids = append(w.ids, id)
+	w.sent++
+	w.high = max(w.high, len(w.ids))
+}
+
+// ack removes the line msg answers, if any.
+func (w *inflightWindow) ack(msg client.Message) {
+	if w == nil || len(w.ids) == 0 {
+		return
+	}
+	i := 0
+	if w.field != "" {
+		var doc any
+		if err := json.Unmarshal(msg.Data, &doc); err != nil {
+			return
+		}
+		v, ok := lookupPath(doc, w.field)
+		if !ok {
+			return
+		}
+		for i = 0; i < len(w.ids) && w.ids[i] != valueString(v); i++ {
+		}
+		if i == len(w.ids) {
+			return
+		}
+	}
+	w.ids = append(w.ids[:i], w.ids[i+1:]...)
+	w.acked++
+}
+
+// unackedPoll is how often a -max-inflight-bytes pause checks whether the
+// send queue has drained; that happens without any message arriving.
+const unackedPoll = 5 * time.Millisecond
+
+// unackedCap pauses stdin for -max-inflight-bytes while the socket holds
+// that many bytes the server has not acknowledged. It needs no responses,
+// so it also holds back a producer feeding a server that never answers.
+type unackedCap struct {
+	max    int
+	high   int // most unacknowledged bytes seen
+	pauses int
+}
+
+// newUnackedCap returns nil unless -max-inflight-bytes is set and the
+// platform can measure the send queue of c.
+func newUnackedCap(a *app, c *client.Client, max int64) *unackedCap {
+	if max <= 0 {
+		return nil
+	}
+	if _, ok := c.Unacked(); !ok {
+		fmt.Fprintln(a.stderr, "-max-inflight-bytes: unacknowledged bytes cannot be measured on this platform; not capping")
+		return nil
+	}
+	return &unackedCap{max: int(max)}
+}
+
+func (u *unackedCap) full(c *client.Client) bool {
+	if u == nil {
+		return false
+	}
+	n, _ := c.Unacked()
+	u.high = max(u.high, n)
+	return n >= u.max
+}
+
+func (u *unackedCap) report(a *app) {
+	if u == nil {
+		return
+	}
+	fmt.Fprintf(a.stderr, "unacknowledged: high-water mark %d of -max-inflight-bytes %d; stdin paused %d times\n", u.high, u.max, u.pauses)
+}
+
+func (w *inflightWindow) report(a *app) {
+	if w == nil || w.max == 0 {
+		return
+	}
+	fmt.Fprintf(a.stderr, "in-flight: high-water mark %d of -max-inflight %d; %d lines sent, %d answered\n", w.high, w.max, w.sent, w.acked)
+}
+
+// stdinLines sends every line read from stdin as a text message while
+// printing what arrives, and once stdin is exhausted waits -read-timeout
+// for the remaining responses. With -max-inflight, stdin is not read on
+// while that many lines are unanswered, so a fast producer cannot outrun a
+// slow server; -max-inflight-bytes does the same by the bytes the server's
+// TCP stack has not acknowledged.
+func (a *app) stdinLines(ctx context.Context, c *client.Client, opts options) (receiveResult, error) {
+	lines := make(chan []byte)
+	stop := make(chan struct{})
+	defer close(stop)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(a.stdin)
+		sc.Buffer(nil, 16<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			select {
+			case lines <- bytes.Clone(line):
+			case <-stop:
+				return
+			}
+		}
+		scanErr <- sc.Err()
+	}()
+
+	var window *inflightWindow
+	if opts.maxInflight > 0 {
+		window = &inflightWindow{max: opts.maxInflight, field: opts.correlationField}
+	}
+	defer window.report(a)
+	unacked := newUnackedCap(a, c, opts.maxInflightBytes)
+	defer unacked.report(a)
+
+	paused := false
+	for lines != nil {
+		next := lines
+		var recheck <-chan time.Time
+		switch {
+		case window.full():
+			next = nil // let the responses catch up first
+		case unacked.full(c):
+			next, recheck = nil, time.After(unackedPoll)
+			if !paused {
+				unacked.pauses++
+			}
+		}
+		paused = recheck != nil
+		select {
+		case <-recheck:
+		case line, ok := <-next:
+			if !ok {
+				lines = nil
+				break
+			}
+			payload, id := line, ""
+			if opts.correlationField != "" {
+				var err error
+				id = newCorrelationID()
+				if payload, err = withCorrelationID(line, opts.correlationField, id); err != nil {
+					fmt.Fprintf(a.stderr, "stdin: %v; sending the line as is\n", err)
+					payload, id = line, ""
+				}
+			}
+			if out, err := a.transform.apply(payload); err != nil {
+				fmt.Fprintf(a.stderr, "stdin: %v; sending the line as is\n", err)
+			} else {
+				payload = out
+			}
+			switch err := a.send(ctx, c, payload); {
+			case errors.Is(err, errMaxSends):
+				lines = nil
+			case err != nil:
+				return receiveResult{connected: true}, fmt.Errorf("send message: %w", err)
+			default:
+				window.add(id)
+				a.printSent(a.stdout, payload)
+			}
+		case msg, ok := <-c.Receive():
+			if !ok {
+				a.readFinished(c)
+				return receiveResult{connected: true, closeCode: closeCode(c.Err())}, nil
+			}
+			a.out.handle(msg)
+			window.ack(msg)
+			if a.budgetSpent() {
+				a.closeAndDrain(c, budgetReason)
+				return receiveResult{connected: true, done: true}, nil
+			}
+		case <-ctx.Done():
+			return a.receive(ctx, c, receivePlan{}), nil
+		}
+	}
+	select {
+	case err := <-scanErr:
+		if err != nil {
+			fmt.Fprintf(a.stderr, "stdin: %v\n", err)
+		}
+	default:
+	}
+
+	plan := receivePlan{timeout: opts.readTimeout, reason: "all lines answered"}
+	if window != nil {
+		if len(window.ids) == 0 {
+			a.closeAndDrain(c, plan.reason)
+			return receiveResult{connected: true, done: true}, nil
+		}
+		plan.done = func(msg client.Message) bool {
+			window.ack(msg)
+			return len(window.ids) == 0
+		}
+	}
+	return a.receive(ctx, c, plan), nil
+}
+
+// withCorrelationID stores id under field in the JSON line.
+func withCorrelationID(line []byte, field, id string) ([]byte, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("line is not JSON, so -correlation-field cannot be set")
+	}
+	doc, err := setField(doc, field, id)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// lineSource produces n JSON lines of about size bytes and counts what has
+// been read from it.
+type lineSource struct {
+	line []byte
+	left int
+	buf  []byte
+	read atomic.Int64
+}
+
+func newLineSource(n, size int) *lineSource {
+	line := append([]byte(`{"pad":"`+strings.Repeat("x", size-11)+`"}`), '\n')
+	return &lineSource{line: line, left: n}
+}
+
+func (s *lineSource) Read(p []byte) (int, error) {
+	for len(s.buf) < len(p) && s.left > 0 {
+		s.buf = append(s.buf, s.line...)
+		s.left--
+	}
+	if len(s.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	s.read.Add(int64(n))
+	return n, nil
+}
+
+// TestStdinLinesMaxInflightBytes feeds a server that neither reads nor
+// answers for a while: stdin must stop being read once the unacknowledged
+// bytes reach the cap, without any response to go by.
+func TestStdinLinesMaxInflightBytes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the send queue is only measured on Linux")
+	}
+	const lines, size, limit = 4096, 1024, 64 << 10
+	release := make(chan struct{})
+	s := newTestServer(t, func(s *testServer, conn *websocket.Conn) {
+		// A small receive window lets the client's send queue fill.
+		_ = conn.NetConn().(*net.TCPConn).SetReadBuffer(32 << 10)
+		<-release
+		s.drain(conn)
+	})
+	isolateEnv(t)
+	opts, err := parseFlags([]string{"send", "-url", s.url, "-path", "/ws", "-stdin-lines", "-max-inflight-bytes", "64k", "-read-timeout", "200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr syncBuffer
+	a := newApp(opts, &stdout, &stderr)
+	src := newLineSource(lines, size)
+	a.stdin = src
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- a.dispatch(ctx, opts) }()
+
+	time.Sleep(300 * time.Millisecond)
+	// The cap plus what the server's kernel accepted and the buffers on
+	// the way; the 4 MiB of input would otherwise fill the send buffer.
+	if n := src.read.Load(); n > 1<<20 {
+		t.Errorf("read %d bytes of stdin while the server was blocked, want the cap (%d) to hold it back", n, limit)
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("send: %v\nstderr:\n%s", err, stderr.String())
+	}
+	if got := len(s.messages()); got != lines {
+		t.Errorf("server received %d lines, want %d", got, lines)
+	}
+	if !strings.Contains(stderr.String(), "of -max-inflight-bytes 65536; stdin paused") {
+		t.Errorf("stderr has no -max-inflight-bytes summary:\n%s", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "paused 0 times") {
+		t.Errorf("stdin was never paused:\n%s", stderr.String())
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// strictChecker collects the protocol anomalies -strict fails on: a refused
+// handshake, a close code other than 1000, a text message that is not JSON
+// and a message of the wrong type. All methods are no-ops on a nil
+// *strictChecker.
+type strictChecker struct {
+	errw     io.Writer
+	wantType int // websocket.TextMessage or websocket.BinaryMessage
+
+	mu         sync.Mutex
+	violations int
+}
+
+func (s *strictChecker) violation(format string, args ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.violations++
+	fmt.Fprintf(s.errw, "strict: "+format+"\n", args...)
+}
+
+// message checks the type of msg and that text messages are JSON.
+func (s *strictChecker) message(msg client.Message) {
+	if s == nil {
+		return
+	}
+	if msg.Type != s.wantType {
+		s.violation("received a %s message, expected %s", messageKind(msg.Type), messageKind(s.wantType))
+		return
+	}
+	if msg.Type == websocket.TextMessage && !json.Valid(msg.Data) {
+		s.violation("received a text message that is not valid JSON: %.80q", msg.Data)
+	}
+}
+
+// closed checks how the connection ended.
+func (s *strictChecker) closed(err error) {
+	if code := closeCode(err); code != websocket.CloseNormalClosure {
+		s.violation("connection closed with code %d: %v", code, err)
+	}
+}
+
+// handshake records a refused or failed upgrade.
+func (s *strictChecker) handshake(err error) {
+	s.violation("handshake failed: %v", err)
+}
+
+// err is the failure to report at the end of the run, if any.
+func (s *strictChecker) err() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.violations == 0 {
+		return nil
+	}
+	return fmt.Errorf("-strict: %d protocol violation(s)", s.violations)
+}
+
+func messageKind(msgType int) string {
+	if msgType == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// tap relays WebSocket clients to the -url upstream, printing every frame
+// with its direction and optionally recording it.
+type tap struct {
+	a        *app
+	opts     options
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex // serializes output and the record file
+	record *json.Encoder
+	expand *jsonExpander // -expand-json-strings, for the printed frames only
+	out    io.Writer     // stdout, sanitized on a terminal
+	seq    int
+}
+
+// tapRecord is one line of the -record file.
+type tapRecord struct {
+	Time string `json:"time"`
+	Conn int    `json:"conn"`
+	Dir  string `json:"dir"` // "client->server" or "server->client"
+	Type string `json:"type"`
+	Data any    `json:"data,omitempty"`
+	Code int    `json:"code,omitempty"` // close frames only
+}
+
+func (a *app) tap(ctx context.Context, opts options) error {
+	t := &tap{
+		a:      a,
+		opts:   opts,
+		expand: newJSONExpander(&opts.expandStrings, false),
+		out:    sanitizeWriterFor(a.stdout, opts.noSanitize),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+	if opts.tapRecord != "" {
+		f, err := os.Create(opts.tapRecord)
+		if err != nil {
+			return fmt.Errorf("-record: %w", err)
+		}
+		defer f.Close()
+		t.record = json.NewEncoder(f)
+		t.record.SetEscapeHTML(false)
+	}
+	ln, err := net.Listen("tcp", opts.listenAddr)
+	if err != nil {
+		return fmt.Errorf("-listen: %w", err)
+	}
+	upstream, err := client.BuildURL(opts.baseURL, opts.path, opts.port)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", t.handle)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	fmt.Fprintf(a.stderr, "tap listening on ws://%s, relaying to %s\n", ln.Addr(), upstream)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handle dials the upstream first, so a failure can be answered with 502
+// before the client's upgrade, then relays until either side closes.
+func (t *tap) handle(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	t.seq++
+	id := t.seq
+	t.mu.Unlock()
+
+	up, err := t.a.connect(r.Context(), t.opts, nil)
+	if err != nil {
+		fmt.Fprintf(t.a.stderr, "conn %d: upstream: %v\n", id, err)
+		http.Error(w, "upstream: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	down, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Fprintf(t.a.stderr, "conn %d: upgrade from %s: %v\n", id, r.RemoteAddr, err)
+		_ = up.Close(websocket.CloseGoingAway, "")
+		for range up.Receive() {
+		}
+		return
+	}
+	defer down.Close()
+	fmt.Fprintf(t.a.stderr, "conn %d: %s connected\n", id, r.RemoteAddr)
+
+	var downMu sync.Mutex
+	upDone := make(chan struct{})
+	go func() {
+		defer close(upDone)
+		for msg := range up.Receive() {
+			t.show(id, "server->client", msg.Type, msg.Data)
+			downMu.Lock()
+			err := down.WriteMessage(msg.Type, msg.Data)
+			downMu.Unlock()
+			if err != nil {
+				break
+			}
+		}
+		code, reason := closeInfo(up.Err())
+		t.showClose(id, "server->client", code, reason)
+		downMu.Lock()
+		defer downMu.Unlock()
+		if code == websocket.CloseAbnormalClosure {
+			// The upstream dropped without a close frame; do the same.
+			down.Close()
+			return
+		}
+		_ = down.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	}()
+
+	for {
+		msgType, data, err := down.ReadMessage()
+		if err != nil {
+			code, reason := closeInfo(err)
+			select {
+			case <-upDone:
+				// The close came from the upstream and was only echoed back.
+			default:
+				t.showClose(id, "client->server", code, reason)
+				if code == websocket.CloseAbnormalClosure {
+					code = websocket.CloseGoingAway
+				}
+				_ = up.Close(code, reason)
+			}
+			break
+		}
+		t.show(id, "client->server", msgType, data)
+		if err := up.SendMessage(r.Context(), msgType, data); err != nil {
+			fmt.Fprintf(t.a.stderr, "conn %d: relay to server: %v\n", id, err)
+			_ = up.Close(websocket.CloseGoingAway, "")
+			break
+		}
+	}
+	<-upDone
+	fmt.Fprintf(t.a.stderr, "conn %d: finished\n", id)
+}
+
+// closeInfo is the close code and reason carried by a read error; 1006 when
+// the connection ended without a close frame.
+func closeInfo(err error) (int, string) {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return ce.Code, ce.Text
+	}
+	return websocket.CloseAbnormalClosure, ""
+}
+
+func (t *tap) show(id int, dir string, msgType int, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kind := "text"
+	if msgType == websocket.BinaryMessage {
+		kind = "binary"
+		fmt.Fprintf(t.out, "[%d] %s binary (%d bytes)\n", id, dir, len(data))
+	} else if out, ok := t.expand.indent(data, "", "  "); ok {
+		fmt.Fprintf(t.out, "[%d] %s\n%s\n", id, dir, out)
+	} else {
+		fmt.Fprintf(t.out, "[%d] %s %s\n", id, dir, data)
+	}
+	if t.record != nil {
+		_ = t.record.Encode(tapRecord{Time: time.Now().Format(time.RFC3339Nano), Conn: id, Dir: dir, Type: kind, Data: recordData(msgType, data)})
+	}
+}
+
+func (t *tap) showClose(id int, dir string, code int, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, "[%d] %s close %d %s\n", id, dir, code, reason)
+	if t.record != nil {
+		_ = t.record.Encode(tapRecord{Time: time.Now().Format(time.RFC3339Nano), Conn: id, Dir: dir, Type: "close", Code: code, Data: reason})
+	}
+}
+
+// recordData keeps text messages readable in the record: JSON as is, other
+// text as a string; binary data is base64-encoded by encoding/json.
+func recordData(msgType int, data []byte) any {
+	if msgType == websocket.BinaryMessage {
+		return data
+	}
+	return dataField(data)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// target is one server of a -targets-file.
+type target struct {
+	baseURL, path string
+}
+
+// loadTargets reads a -targets-file: one server per line, as "URL PATH" or
+// a full ws:// URL; without a path in either, defPath (-path) is used.
+// Blank lines and lines starting with # are skipped.
+func loadTargets(name, defPath string) ([]target, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("-targets-file: %w", err)
+	}
+	defer f.Close()
+	var targets []target
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("-targets-file %s:%d: want URL [PATH], got %q", name, n, line)
+		}
+		t := target{baseURL: fields[0], path: defPath}
+		if len(fields) == 2 {
+			t.path = fields[1]
+		} else if u, err := url.Parse(fields[0]); err == nil && u.Path != "" && u.Path != "/" {
+			t.path = u.Path
+		}
+		if t.path == "" {
+			return nil, fmt.Errorf("-targets-file %s:%d: %q has no path and -path is not set", name, n, line)
+		}
+		if _, err := client.BuildURL(t.baseURL, t.path, 0); err != nil {
+			return nil, fmt.Errorf("-targets-file %s:%d: %w", name, n, err)
+		}
+		targets = append(targets, t)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("-targets-file: %w", err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("-targets-file %s lists no targets", name)
+	}
+	return targets, nil
+}
+
+// targetResult is the outcome of one -targets-file server.
+type targetResult struct {
+	url      string
+	connect  time.Duration
+	messages int
+	err      error
+}
+
+// fanOut runs the send/receive flow of j against every -targets-file
+// server at once, as -connections does for one server, prefixing output
+// lines with the target's number, and ends with a per-target summary.
+func (a *app) fanOut(ctx context.Context, opts options, j job) error {
+	var mu sync.Mutex // one printer serves every target
+	out := &prefixWriter{w: a.out.w}
+	a.out.w = out
+	emit := func(id int, fn func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.prefix = fmt.Sprintf("[%d] ", id+1)
+		fn()
+		out.prefix = ""
+	}
+
+	results := make([]targetResult, len(opts.targets))
+	var wg sync.WaitGroup
+	for i, t := range opts.targets {
+		wg.Add(1)
+		go func(id int, t target) {
+			defer wg.Done()
+			topts := opts
+			topts.baseURL, topts.path = t.baseURL, t.path
+			r := &results[id]
+			r.url, _ = client.BuildURL(t.baseURL, t.path, opts.port)
+			var res parallelResult
+			r.messages, r.err = a.parallelWorker(ctx, topts, id, j.payload, &res, emit)
+			if len(res.handshakes) > 0 {
+				r.connect = res.handshakes[0]
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	failed := printTargets(a.stderr, results)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed", failed, len(results))
+	}
+	return nil
+}
+
+// printTargets writes the summary table and returns the number of failed
+// targets.
+func printTargets(w io.Writer, results []targetResult) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTARGET\tRESULT\tCONNECT\tMESSAGES\tERROR")
+	failed := 0
+	for i, r := range results {
+		result, connect, errText := "ok", "-", ""
+		if r.connect > 0 {
+			connect = r.connect.Round(time.Microsecond).String()
+		}
+		if r.err != nil {
+			failed++
+			result, errText = "fail", r.err.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", i+1, r.url, result, connect, r.messages, errText)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "targets: %d succeeded, %d failed of %d\n", len(results)-failed, failed, len(results))
+	return failed
+}
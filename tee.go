@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// teeFile is the -tee log: every shown message as one compact JSON line,
+// as -format ndjson prints it, whatever the format of the regular output.
+// Lines are written to the file as they come, so it can be followed during
+// the run. A nil *teeFile does nothing.
+type teeFile struct {
+	f      *os.File
+	errw   io.Writer
+	failed bool // a write error was reported; later ones are not
+}
+
+// openTee creates the -tee file.
+func (a *app) openTee(opts options) error {
+	if opts.tee == "" {
+		return nil
+	}
+	f, err := os.Create(opts.tee)
+	if err != nil {
+		return fmt.Errorf("-tee: %w", err)
+	}
+	a.out.tee = &teeFile{f: f, errw: a.stderr}
+	return nil
+}
+
+// write appends line, one message, to the log.
+func (t *teeFile) write(line []byte) {
+	if t == nil {
+		return
+	}
+	if _, err := t.f.Write(line); err != nil && !t.failed {
+		t.failed = true
+		fmt.Fprintf(t.errw, "-tee: %v\n", err)
+	}
+}
+
+// close closes the file. It is a no-op on a nil *teeFile.
+func (t *teeFile) close() error {
+	if t == nil {
+		return nil
+	}
+	return t.f.Close()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// templateMessage is what an -output-template is executed with.
+type templateMessage struct {
+	Timestamp time.Time // when the message was read
+	Index     int       // position among the shown messages, from 1
+	Type      string    // "text" or "binary"
+	Size      int       // payload bytes
+	Raw       string    // the message as received
+	JSON      any       // the parsed document, nil when the message is not JSON
+}
+
+// parseOutputTemplate compiles -output-template. Besides the text/template
+// builtins it offers json, which renders a value (e.g. {{json .JSON.data}})
+// as compact JSON.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("-output-template: %w", err)
+	}
+	return t, nil
+}
+
+// renderTemplate executes the -output-template for msg.
+func renderTemplate(t *template.Template, msg client.Message, index int) ([]byte, error) {
+	m := templateMessage{Timestamp: msg.Time, Index: index, Type: "text", Size: len(msg.Data), Raw: string(msg.Data)}
+	if msg.Type == websocket.BinaryMessage {
+		m.Type = "binary"
+	}
+	dec := json.NewDecoder(bytes.NewReader(msg.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&m.JSON); err != nil || dec.More() {
+		m.JSON = nil
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, m); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// terminalWidth is not implemented on this platform; callers fall back to
+// defaultTermWidth.
+func terminalWidth(*os.File) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zsuzuki/postws/client"
+)
+
+// throughputResult accumulates what the throughput workers measured.
+type throughputResult struct {
+	mu          sync.Mutex
+	connected   int
+	framesSent  int64
+	framesRecv  int64
+	bytesSent   int64 // payload bytes written
+	bytesRecv   int64 // payload bytes received
+	wireSent    int64 // bytes on the network connection, after compression
+	wireRecv    int64
+	echoed      int64         // received frames matched to a send
+	latency     time.Duration // sum over echoed frames
+	compression bool          // negotiated on every connection
+	end         time.Time     // when the last worker stopped sending
+	errors      []error
+}
+
+func (r *throughputResult) fail(err error) {
+	r.mu.Lock()
+	r.errors = append(r.errors, err)
+	r.mu.Unlock()
+}
+
+// throughput sends frames of -payload-size bytes as fast as possible (or at
+// -rate across the connections) for -duration and reports the payload and
+// wire bytes per second in each direction. Echoed frames are matched to
+// sends in order for the average frame latency.
+func (a *app) throughput(ctx context.Context, opts options) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.benchDuration)
+	defer cancel()
+
+	res := throughputResult{compression: opts.compress}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.connections; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := a.throughputWorker(ctx, opts, &res); err != nil {
+				res.fail(fmt.Errorf("conn %d: %w", id, err))
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := res.end.Sub(start)
+	if res.end.IsZero() {
+		elapsed = time.Since(start)
+	}
+	res.print(a.stdout, opts, elapsed)
+	for _, err := range res.errors {
+		fmt.Fprintf(a.stderr, "error: %v\n", err)
+	}
+	if len(res.errors) > 0 {
+		return fmt.Errorf("%d of %d connections failed", len(res.errors), opts.connections)
+	}
+	return nil
+}
+
+func (a *app) throughputWorker(ctx context.Context, opts options, res *throughputResult) error {
+	payload := throughputPayload(opts)
+	msgType := websocket.TextMessage
+	if opts.binary {
+		msgType = websocket.BinaryMessage
+	}
+	// Every frame carries the same bytes, so build (and deflate) it once.
+	prepared, err := client.Prepare(msgType, payload)
+	if err != nil {
+		return err
+	}
+	copts, err := clientOptions(opts, nil)
+	if err != nil {
+		return err
+	}
+	copts.CountWire = true
+	c, err := a.dial(ctx, copts)
+	if err != nil {
+		if opts.debugErrors {
+			a.printErrorChain(err)
+		}
+		return err
+	}
+	if resp := c.Response(); resp == nil || !strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		res.mu.Lock()
+		res.compression = false
+		res.mu.Unlock()
+	}
+	res.mu.Lock()
+	res.connected++
+	res.mu.Unlock()
+
+	var mu sync.Mutex
+	var pending []time.Time // send times of frames not echoed yet
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for msg := range c.Receive() {
+			var latency time.Duration
+			echo := false
+			mu.Lock()
+			// Only a frame like the ones sent counts as an echo, so a
+			// greeting does not shift the matching.
+			if len(pending) > 0 && msg.Type == msgType && len(msg.Data) == len(payload) {
+				latency = msg.Time.Sub(pending[0])
+				pending = pending[1:]
+				echo = true
+			}
+			mu.Unlock()
+			res.mu.Lock()
+			res.framesRecv++
+			res.bytesRecv += int64(len(msg.Data))
+			if echo {
+				res.echoed++
+				res.latency += latency
+			}
+			res.mu.Unlock()
+		}
+	}()
+
+	var interval time.Duration
+	if opts.rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(opts.connections) / opts.rate)
+	}
+	sendCtx := context.WithoutCancel(ctx)
+	next := time.Now()
+	var sendErr error
+	for ctx.Err() == nil {
+		if interval > 0 {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			next = next.Add(interval)
+		}
+		mu.Lock()
+		pending = append(pending, time.Now())
+		mu.Unlock()
+		if err := c.SendPrepared(sendCtx, prepared); err != nil {
+			sendErr = fmt.Errorf("send: %w", err)
+			break
+		}
+		res.mu.Lock()
+		res.framesSent++
+		res.bytesSent += int64(len(payload))
+		res.mu.Unlock()
+	}
+
+	res.mu.Lock()
+	if now := time.Now(); now.After(res.end) {
+		res.end = now
+	}
+	res.mu.Unlock()
+
+	// Let the echoes of the last frames arrive before closing.
+	grace := time.After(time.Second)
+wait:
+	for sendErr == nil {
+		mu.Lock()
+		left := len(pending)
+		mu.Unlock()
+		if left == 0 {
+			break
+		}
+		select {
+		case <-grace:
+			break wait
+		case <-readDone:
+			break wait
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	_ = c.Close(websocket.CloseNormalClosure, "")
+	<-readDone
+	written, read := c.WireBytes()
+	res.mu.Lock()
+	res.wireSent += written
+	res.wireRecv += read
+	res.mu.Unlock()
+	return sendErr
+}
+
+// throughputPayload is the frame every connection sends: zero bytes, or
+// random data (random letters and digits for text frames, which must be
+// valid UTF-8).
+func throughputPayload(opts options) []byte {
+	payload := make([]byte, opts.payloadSize)
+	if opts.payloadFill == "zero" {
+		return payload
+	}
+	_, _ = rand.Read(payload)
+	if !opts.binary {
+		const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+		for i, b := range payload {
+			payload[i] = alphabet[int(b)%len(alphabet)]
+		}
+	}
+	return payload
+}
+
+func (r *throughputResult) print(w io.Writer, opts options, elapsed time.Duration) {
+	kind := "text"
+	if opts.binary {
+		kind = "binary"
+	}
+	compression := "off"
+	if opts.compress {
+		compression = "not negotiated"
+		if r.compression {
+			compression = "on"
+		}
+	}
+	secs := elapsed.Seconds()
+	mbps := func(n int64) float64 { return float64(n) / 1e6 / secs }
+	fmt.Fprintf(w, "throughput: %s, %d/%d connections, %s %s frames (%s), compression %s\n",
+		elapsed.Round(time.Millisecond), r.connected, opts.connections, formatSize(opts.payloadSize), kind, opts.payloadFill, compression)
+	fmt.Fprintf(w, "sent:       %d frames, payload %.2f MB/s, wire %.2f MB/s\n", r.framesSent, mbps(r.bytesSent), mbps(r.wireSent))
+	fmt.Fprintf(w, "received:   %d frames, payload %.2f MB/s, wire %.2f MB/s\n", r.framesRecv, mbps(r.bytesRecv), mbps(r.wireRecv))
+	if opts.compress && r.bytesSent > 0 {
+		fmt.Fprintf(w, "wire/payload (sent): %.1f%%\n", 100*float64(r.wireSent)/float64(r.bytesSent))
+	}
+	if r.echoed > 0 {
+		fmt.Fprintf(w, "latency:    %s average over %d echoed frames\n", (r.latency / time.Duration(r.echoed)).Round(time.Microsecond), r.echoed)
+	}
+}
+
+// reportSendRate prints the average outgoing rate achieved under
+// -max-send-rate.
+func (a *app) reportSendRate(opts options) {
+	if opts.sendLimit == nil {
+		return
+	}
+	sent, elapsed := opts.sendLimit.Sent()
+	if elapsed <= 0 {
+		return
+	}
+	fmt.Fprintf(a.stderr, "send rate: %.1f KiB/s average over %s of sending, %d bytes (limit %s/s)\n",
+		float64(sent)/1024/elapsed.Seconds(), elapsed.Round(time.Millisecond), sent, formatSize(opts.maxSendRate))
+}
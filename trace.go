@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// traceparentRe matches a version 00 W3C traceparent header value.
+var traceparentRe = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// newTraceparent returns a traceparent with random trace and span ids.
+func newTraceparent(sampled bool) string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:]), flags)
+}
+
+// checkTraceparent validates an explicit -traceparent value.
+func checkTraceparent(v string) error {
+	m := traceparentRe.FindStringSubmatch(v)
+	if m == nil {
+		return fmt.Errorf("invalid -traceparent %q (want 00-<32 hex trace id>-<16 hex span id>-<2 hex flags>)", v)
+	}
+	if strings.Trim(m[1], "0") == "" || strings.Trim(m[2], "0") == "" {
+		return fmt.Errorf("invalid -traceparent %q: trace and span ids must not be all zeros", v)
+	}
+	return nil
+}
+
+// traceID is the trace id part of a traceparent, the value to search for in
+// a tracing UI.
+func traceID(traceparent string) string {
+	if m := traceparentRe.FindStringSubmatch(traceparent); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// transformFlag collects repeated -transform POINTER=TEMPLATE flags.
+type transformFlag []string
+
+func (f *transformFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *transformFlag) Set(v string) error {
+	ptr, _, ok := strings.Cut(v, "=")
+	if !ok || !strings.HasPrefix(ptr, "/") {
+		return fmt.Errorf("invalid -transform %q (want /json/pointer=template)", v)
+	}
+	*f = append(*f, v)
+	return nil
+}
+
+// transformer rewrites every message the send paths emit with the
+// -transform values: each template is rendered with {{now}}, {{unix_ms}}
+// and {{seq}} (counting transformed messages from 1 over the whole run)
+// and stored at its pointer, as a JSON value if it parses and a string
+// otherwise. A nil *transformer leaves messages unchanged.
+type transformer struct {
+	specs []string
+	seq   atomic.Int64
+}
+
+func newTransformer(specs transformFlag) *transformer {
+	if len(specs) == 0 {
+		return nil
+	}
+	return &transformer{specs: specs}
+}
+
+// apply returns msg with the -transform values set. msg must be JSON.
+func (t *transformer) apply(msg []byte) ([]byte, error) {
+	if t == nil {
+		return msg, nil
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("-transform: message is not JSON")
+	}
+	seq, now := int(t.seq.Add(1)), time.Now()
+	var err error
+	for _, spec := range t.specs {
+		ptr, tmpl, _ := strings.Cut(spec, "=")
+		if doc, err = setPointer(doc, ptr, setValue(fillTemplate(tmpl, seq, now))); err != nil {
+			return nil, fmt.Errorf("-transform: %w", err)
+		}
+	}
+	return json.Marshal(doc)
+}
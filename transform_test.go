@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// TestTransformPerConnection checks that the runs sending the payload once
+// per connection apply -transform to each, with a fresh {{seq}}.
+func TestTransformPerConnection(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"connections", []string{"-connections", "3", "-expect-count", "1"}},
+		{"churn", []string{"-churn", "3", "-expect-count", "1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			args := append([]string{"send", "-url", s.url, "-path", "/ws", "-transform", "/seq={{seq}}"}, tc.args...)
+			r := runCommand(t, append(args, "a=1")...)
+			if r.err != nil {
+				t.Fatalf("send: %v\nstderr:\n%s", r.err, r.stderr)
+			}
+			var seqs []int
+			for _, m := range s.messages() {
+				var msg struct {
+					A   string `json:"a"`
+					Seq int    `json:"seq"`
+				}
+				if err := json.Unmarshal(m, &msg); err != nil || msg.A != "1" {
+					t.Fatalf("server received %s, want the payload", m)
+				}
+				seqs = append(seqs, msg.Seq)
+			}
+			slices.Sort(seqs)
+			if !slices.Equal(seqs, []int{1, 2, 3}) {
+				t.Errorf("seq values %v, want 1, 2 and 3 across the connections", seqs)
+			}
+		})
+	}
+}
+
+// TestTransformDiff checks that both -diff sides get the same transformed
+// payload, so a {{seq}} does not make their echoes differ.
+func TestTransformDiff(t *testing.T) {
+	a, b := newTestServer(t, nil), newTestServer(t, nil)
+	r := runCommand(t, "send", "-url", a.url, "-path", "/ws", "-diff", b.url+"/ws", "-transform", "/seq={{seq}}", "a=1")
+	if r.err != nil {
+		t.Fatalf("send -diff: %v\nstderr:\n%s", r.err, r.stderr)
+	}
+	for _, s := range []*testServer{a, b} {
+		if got := s.messages(); len(got) != 1 || string(got[0]) != `{"a":"1","seq":1}` {
+			t.Errorf("server received %q, want one {\"a\":\"1\",\"seq\":1}", got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, normally injected with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When left empty the values come from debug.ReadBuildInfo, which covers
+// `go install` builds.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+const gorillaModule = "github.com/gorilla/websocket"
+
+type versionInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	Go        string
+	Gorilla   string
+}
+
+func buildInfo() versionInfo {
+	info := versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		Go:        runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == gorillaModule {
+				info.Gorilla = dep.Version
+			}
+		}
+	}
+	if info.Version == "" || info.Version == "(devel)" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// userAgent is the default User-Agent of the handshake request.
+func userAgent() string {
+	return "postws/" + buildInfo().Version
+}
+
+func printVersion(w io.Writer) {
+	info := buildInfo()
+	fmt.Fprintf(w, "postws %s\n", info.Version)
+	fmt.Fprintf(w, "  commit:  %s\n", orUnknown(info.Commit))
+	fmt.Fprintf(w, "  built:   %s\n", orUnknown(info.BuildDate))
+	fmt.Fprintf(w, "  go:      %s\n", info.Go)
+	fmt.Fprintf(w, "  gorilla: %s\n", orUnknown(info.Gorilla))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// waitCondition describes the message that must arrive before the payload
+// is sent (or, for -filter, the messages that are shown): either a JSON path
+// compared against a value, or a regular expression matched against the raw
+// message text.
+type waitCondition struct {
+	path  string
+	value string
+	re    *regexp.Regexp
+}
+
+// parseCondition parses the path=value or re:REGEX spec given to flag.
+func parseCondition(flag, spec string) (*waitCondition, error) {
+	if expr, ok := strings.CutPrefix(spec, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s regex: %w", flag, err)
+		}
+		return &waitCondition{re: re}, nil
+	}
+	path, value, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("invalid -%s %q (want path=value or re:REGEX)", flag, spec)
+	}
+	return &waitCondition{path: path, value: value}, nil
+}
+
+func (w *waitCondition) match(msg []byte) bool {
+	if w.re != nil {
+		return w.re.Match(msg)
+	}
+	var doc any
+	if err := json.Unmarshal(msg, &doc); err != nil {
+		return false
+	}
+	v, ok := lookupPath(doc, w.path)
+	if !ok {
+		return false
+	}
+	return valueString(v) == w.value
+}
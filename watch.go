@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zsuzuki/postws/client"
+)
+
+// watchConn repeats the send/receive cycle on the open connection every
+// -watch interval. Cycles run to completion even when ctx is cancelled;
+// the watch then stops before the next one.
+func (a *app) watchConn(ctx context.Context, c *client.Client, opts options, j job) (receiveResult, error) {
+	for cycle := 1; ; cycle++ {
+		a.cycleHeader(cycle)
+		res, err := a.exchange(context.WithoutCancel(ctx), c, opts, j, true)
+		if err != nil {
+			return res, err
+		}
+		j.first = nil // later cycles are transformed as they are sent
+		if a.budgetSpent() {
+			return res, nil // receive has closed the connection
+		}
+		if !res.done && !res.timedOut {
+			// The server ended the connection; -reconnect may take over.
+			return res, nil
+		}
+		if !a.nextCycle(ctx, opts, cycle) {
+			a.closeAndDrain(c, "")
+			return receiveResult{connected: true, done: true}, nil
+		}
+	}
+}
+
+// watchRedial is -watch with -watch-redial: every cycle is a fresh
+// connection.
+func (a *app) watchRedial(ctx context.Context, opts options, j job) error {
+	for cycle := 1; ; cycle++ {
+		a.cycleHeader(cycle)
+		if _, err := a.session(context.WithoutCancel(ctx), opts, j); err != nil {
+			return err
+		}
+		if a.budgetSpent() || !a.nextCycle(ctx, opts, cycle) {
+			return nil
+		}
+	}
+}
+
+// cycleHeader separates watch cycles in the output.
+func (a *app) cycleHeader(cycle int) {
+	fmt.Fprintf(a.stdout, "--- %s (cycle %d) ---\n", time.Now().Format(time.RFC3339), cycle)
+}
+
+// nextCycle waits out the -watch interval and reports whether another cycle
+// should run: not after -watch-count cycles or once ctx is cancelled.
+func (a *app) nextCycle(ctx context.Context, opts options, cycle int) bool {
+	if opts.watchCount > 0 && cycle >= opts.watchCount {
+		return false
+	}
+	if ctx.Err() == nil {
+		select {
+		case <-time.After(opts.watch):
+			return true
+		case <-ctx.Done():
+		}
+	}
+	fmt.Fprintf(a.stderr, "interrupted; stopping after cycle %d\n", cycle)
+	return false
+}
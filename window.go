@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// timeWindow keeps messages whose timestamp field falls within
+// [since, until]; a zero bound is open.
+type timeWindow struct {
+	field  string
+	format string
+	since  time.Time
+	until  time.Time
+}
+
+// newTimeWindow builds the -time-field filter. since and until accept an
+// RFC 3339 timestamp or a duration meaning "that long ago" (e.g. 10m).
+func newTimeWindow(field, format, since, until string, now time.Time) (*timeWindow, error) {
+	w := &timeWindow{field: field, format: format}
+	var err error
+	if w.since, err = parseWindowBound(since, now); err != nil {
+		return nil, fmt.Errorf("invalid -since: %w", err)
+	}
+	if w.until, err = parseWindowBound(until, now); err != nil {
+		return nil, fmt.Errorf("invalid -until: %w", err)
+	}
+	if !w.since.IsZero() && !w.until.IsZero() && w.until.Before(w.since) {
+		return nil, fmt.Errorf("-until is before -since")
+	}
+	return w, nil
+}
+
+func parseWindowBound(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// contains reports whether msg carries a timestamp inside the window.
+// Messages without a parsable timestamp are outside it.
+func (w *timeWindow) contains(msg []byte) bool {
+	var doc any
+	if err := json.Unmarshal(msg, &doc); err != nil {
+		return false
+	}
+	v, ok := lookupPath(doc, w.field)
+	if !ok {
+		return false
+	}
+	ts, err := w.parse(v)
+	if err != nil {
+		return false
+	}
+	if !w.since.IsZero() && ts.Before(w.since) {
+		return false
+	}
+	if !w.until.IsZero() && ts.After(w.until) {
+		return false
+	}
+	return true
+}
+
+// parse reads a timestamp in the configured format: a Go time layout, or
+// unix / unixms for numeric epoch values.
+func (w *timeWindow) parse(v any) (time.Time, error) {
+	switch w.format {
+	case "unix", "unixms":
+		var n float64
+		switch x := v.(type) {
+		case float64:
+			n = x
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			n = f
+		default:
+			return time.Time{}, fmt.Errorf("not a number")
+		}
+		if w.format == "unixms" {
+			return time.UnixMilli(int64(n)), nil
+		}
+		sec := int64(n)
+		return time.Unix(sec, int64((n-float64(sec))*1e9)), nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("not a string")
+	}
+	return time.Parse(w.format, s)
+}